@@ -1,195 +1,1475 @@
 package protowrite
 
-func StringField(name string, id int) *Field {
+// TypedField creates a new field of the given type
+func TypedField(typ Type, name string, id int) *Field {
 	return &Field{
-		Type: "string",
+		Type: typ,
 		Name: name,
 		ID:   id,
 	}
 }
 
-func Uint64Field(name string, id int) *Field {
-	return &Field{
-		Type: "uint64",
-		Name: name,
-		ID:   id,
+func DoubleField(name string, id int) *Field {
+	return TypedField(TypeDouble, name, id)
+}
+
+func FloatField(name string, id int) *Field {
+	return TypedField(TypeFloat, name, id)
+}
+
+func Int32Field(name string, id int) *Field {
+	return TypedField(TypeInt32, name, id)
+}
+
+func Int64Field(name string, id int) *Field {
+	return TypedField(TypeInt64, name, id)
+}
+
+func Uint32Field(name string, id int) *Field {
+	return TypedField(TypeUint32, name, id)
+}
+
+func Uint64Field(name string, id int) *Field {
+	return TypedField(TypeUint64, name, id)
+}
+
+func Sint32Field(name string, id int) *Field {
+	return TypedField(TypeSint32, name, id)
+}
+
+func Sint64Field(name string, id int) *Field {
+	return TypedField(TypeSint64, name, id)
+}
+
+func Fixed32Field(name string, id int) *Field {
+	return TypedField(TypeFixed32, name, id)
+}
+
+func Fixed64Field(name string, id int) *Field {
+	return TypedField(TypeFixed64, name, id)
+}
+
+func Sfixed32Field(name string, id int) *Field {
+	return TypedField(TypeSfixed32, name, id)
+}
+
+func Sfixed64Field(name string, id int) *Field {
+	return TypedField(TypeSfixed64, name, id)
+}
+
+func BoolField(name string, id int) *Field {
+	return TypedField(TypeBool, name, id)
+}
+
+func StringField(name string, id int) *Field {
+	return TypedField(TypeString, name, id)
+}
+
+func BytesField(name string, id int) *Field {
+	return TypedField(TypeBytes, name, id)
+}
+
+// MessageField creates a new field whose type is the message with the given name
+func MessageField(typ, name string, id int) *Field {
+	return TypedField(MessageRef(typ), name, id)
+}
+
+// EnumField creates a new field whose type is the enum with the given name
+func EnumField(typ, name string, id int) *Field {
+	return TypedField(EnumRef(typ), name, id)
+}
+
+// MapField creates a new map field with the given key and value type
+// names, as the MapField methods of the builders do. Use TypedField with
+// MapOf to refer to the key and value types directly
+func MapField(keyType, valueType, name string, id int) *Field {
+	return TypedField(&MapType{Key: typeOf(keyType), Value: typeOf(valueType)}, name, id)
+}
+
+type Builder struct{}
+
+// builderState records the problems found while a builder is being used,
+// so that they can be reported all at once when the object is built
+type builderState struct {
+	errs    ValidationErrors
+	names   map[string]string
+	numbers map[int]string
+}
+
+func (s *builderState) errorf(path, format string, args ...interface{}) {
+	s.errs.addf(path, format, args...)
+}
+
+// checkName reports empty names, and names that have already been
+// used by another element in the same scope
+func (s *builderState) checkName(scope, name, kind string) {
+	if name == "" {
+		s.errorf(scope, `%s name must not be empty`, kind)
+		return
+	}
+	if s.names == nil {
+		s.names = make(map[string]string)
+	}
+	if prev, ok := s.names[name]; ok {
+		s.errorf(joinName(scope, name), `%s %q is already declared as a %s`, kind, name, prev)
+		return
+	}
+	s.names[name] = kind
+}
+
+// checkField reports problems with the name and the number of a field
+func (s *builderState) checkField(scope string, f *Field) {
+	s.checkName(scope, f.Name, "field")
+	if f.ID < 0 {
+		s.errorf(joinName(scope, f.Name), `field number %d must not be negative`, f.ID)
+		return
+	}
+	if s.numbers == nil {
+		s.numbers = make(map[int]string)
+	}
+	if prev, ok := s.numbers[f.ID]; ok {
+		s.errorf(joinName(scope, f.Name), `field number %d is already used by %q`, f.ID, prev)
+		return
+	}
+	s.numbers[f.ID] = f.Name
+}
+
+// checkMethod reports methods without an input or output type
+func (s *builderState) checkMethod(scope string, m *Method) {
+	path := joinName(scope, m.Name)
+	if m.Input == nil {
+		s.errorf(path, `method input type must not be empty`)
+	}
+	if m.Output == nil {
+		s.errorf(path, `method output type must not be empty`)
+	}
+}
+
+// messageType converts the name of a method's input or output type into
+// a reference to a message. Empty names are left unset, so that they can
+// be reported by checkMethod
+func messageType(name string) Type {
+	if name == "" {
+		return nil
+	}
+	return MessageRef(name)
+}
+
+// parseType parses the type of the field at path, reporting invalid
+// types and names that look like misspelled scalar types, such as `unit64`
+func (s *builderState) parseType(path, typ string) Type {
+	t, err := ParseType(typ)
+	if err != nil {
+		s.errorf(path, `%s`, err)
+		return nil
+	}
+	s.checkType(path, t)
+	return t
+}
+
+// parseMapType is like parseType, but for the key and value of a map field
+func (s *builderState) parseMapType(path, keyType, valueType string) *MapType {
+	return &MapType{
+		Key:   s.parseType(path, keyType),
+		Value: s.parseType(path, valueType),
+	}
+}
+
+func (s *builderState) checkType(path string, t Type) {
+	switch t := t.(type) {
+	case TypeRef:
+		if t.Kind != RefUnknown {
+			return
+		}
+		if scalar, ok := misspelledScalar(t.Name); ok {
+			s.errorf(path, `unknown type %q (did you mean %q?)`, t.Name, scalar)
+		}
+	case *MapType:
+		s.checkType(path, t.Key)
+		s.checkType(path, t.Value)
+	}
+}
+
+func (s *builderState) err() error {
+	return s.errs.err()
+}
+
+func (b *Builder) Enum(name string) *EnumBuilder {
+	eb := &EnumBuilder{object: &Enum{Name: name}}
+	if name == "" {
+		eb.state.errorf("", `enum name must not be empty`)
+	}
+	return eb
+}
+
+func (b *Builder) EnumElement(name string, value int) *EnumElementBuilder {
+	eb := &EnumElementBuilder{object: &EnumElement{Name: name, Value: value}}
+	if name == "" {
+		eb.state.errorf("", `enum value name must not be empty`)
+	}
+	return eb
+}
+
+func (b *Builder) Extension(name string) *ExtensionBuilder {
+	eb := &ExtensionBuilder{object: &Extension{Name: name}}
+	if name == "" {
+		eb.state.errorf("", `extendee name must not be empty`)
+	}
+	return eb
+}
+
+// TypedExtension creates an extension of target, which may be a *Message
+// declared in the same File or an external type
+func (b *Builder) TypedExtension(target Type) *ExtensionBuilder {
+	eb := &ExtensionBuilder{object: &Extension{Target: target}}
+	switch target.(type) {
+	case nil:
+		eb.state.errorf("", `extension target must not be empty`)
+	case ScalarType, *MapType, *Enum:
+		eb.state.errorf("", `extension target %q is not a message`, target)
+	}
+	return eb
+}
+
+func (b *Builder) ExtensionRange(start, end int) *ExtensionRangeBuilder {
+	return (&ExtensionRangeBuilder{object: &ExtensionRange{}}).Range(start, end)
+}
+
+func (b *Builder) ExtensionRangeToMax(start int) *ExtensionRangeBuilder {
+	eb := &ExtensionRangeBuilder{object: &ExtensionRange{Ranges: []*Range{{Start: start, ToMax: true}}}}
+	eb.checkRange(start, start)
+	return eb
+}
+
+// Field creates a field whose type is parsed from typ, see ParseType
+func (b *Builder) Field(typ, name string, id int) *FieldBuilder {
+	fb := newFieldBuilder(&Field{Name: name, ID: id})
+	fb.object.Type = fb.state.parseType(name, typ)
+	return fb
+}
+
+// MapField creates a map field whose key and value types are parsed
+// from keyType and valueType, see ParseType
+func (b *Builder) MapField(keyType, valueType, name string, id int) *FieldBuilder {
+	fb := newFieldBuilder(&Field{Name: name, ID: id})
+	fb.object.Type = fb.state.parseMapType(name, keyType, valueType)
+	return fb
+}
+
+// TypedField creates a field of the given type
+func (b *Builder) TypedField(typ Type, name string, id int) *FieldBuilder {
+	return newFieldBuilder(TypedField(typ, name, id))
+}
+
+// Import creates an import statement. Use FileBuilder.Imports to add
+// it to a file
+func (b *Builder) Import(path string, typ ImportType) *ImportBuilder {
+	ib := &ImportBuilder{object: &Import{Path: path, Type: typ}}
+	ib.state.checkName("", path, "import")
+	return ib
+}
+
+// Option creates an option statement, such as `option java_package = "foo";`.
+// Use the Options method of the enclosing declaration's builder to add it
+func (b *Builder) Option(name string, value interface{}) *OptionBuilder {
+	ob := &OptionBuilder{object: &Option{Name: name, Value: value}}
+	if name == "" {
+		ob.state.errorf("", `option name must not be empty`)
+	}
+	return ob
+}
+
+func (b *Builder) File() *FileBuilder {
+	return &FileBuilder{object: &File{}}
+}
+
+func (b *Builder) Message(name string) *MessageBuilder {
+	mb := &MessageBuilder{object: &Message{Name: name}}
+	if name == "" {
+		mb.state.errorf("", `message name must not be empty`)
+	}
+	return mb
+}
+
+func (b *Builder) MessageLiteral() *MessageLiteralBuilder {
+	return &MessageLiteralBuilder{object: &MessageLiteral{}}
+}
+
+func (b *Builder) Method(name, input, output string) *MethodBuilder {
+	return b.TypedMethod(name, messageType(input), messageType(output))
+}
+
+// TypedMethod creates a method whose input and output types may refer
+// to *Message nodes or to external types
+func (b *Builder) TypedMethod(name string, input, output Type) *MethodBuilder {
+	mb := &MethodBuilder{object: &Method{Name: name, Input: input, Output: output}}
+	if name == "" {
+		mb.state.errorf("", `method name must not be empty`)
+	}
+	mb.state.checkMethod("", mb.object)
+	return mb
+}
+
+func (b *Builder) OneOf(name string) *OneOfBuilder {
+	ob := &OneOfBuilder{object: &OneOf{Name: name}}
+	if name == "" {
+		ob.state.errorf("", `oneof name must not be empty`)
+	}
+	return ob
+}
+
+func (b *Builder) Service(name string) *ServiceBuilder {
+	sb := &ServiceBuilder{object: &Service{Name: name}}
+	if name == "" {
+		sb.state.errorf("", `service name must not be empty`)
+	}
+	return sb
+}
+
+type ExtensionBuilder struct {
+	object *Extension
+	state  builderState
+}
+
+func (b *ExtensionBuilder) DoubleField(name string, id int) *ExtensionBuilder {
+	return b.Fields(DoubleField(name, id))
+}
+
+func (b *ExtensionBuilder) FloatField(name string, id int) *ExtensionBuilder {
+	return b.Fields(FloatField(name, id))
+}
+
+func (b *ExtensionBuilder) Int32Field(name string, id int) *ExtensionBuilder {
+	return b.Fields(Int32Field(name, id))
+}
+
+func (b *ExtensionBuilder) Int64Field(name string, id int) *ExtensionBuilder {
+	return b.Fields(Int64Field(name, id))
+}
+
+func (b *ExtensionBuilder) Uint32Field(name string, id int) *ExtensionBuilder {
+	return b.Fields(Uint32Field(name, id))
+}
+
+func (b *ExtensionBuilder) Uint64Field(name string, id int) *ExtensionBuilder {
+	return b.Fields(Uint64Field(name, id))
+}
+
+func (b *ExtensionBuilder) Sint32Field(name string, id int) *ExtensionBuilder {
+	return b.Fields(Sint32Field(name, id))
+}
+
+func (b *ExtensionBuilder) Sint64Field(name string, id int) *ExtensionBuilder {
+	return b.Fields(Sint64Field(name, id))
+}
+
+func (b *ExtensionBuilder) Fixed32Field(name string, id int) *ExtensionBuilder {
+	return b.Fields(Fixed32Field(name, id))
+}
+
+func (b *ExtensionBuilder) Fixed64Field(name string, id int) *ExtensionBuilder {
+	return b.Fields(Fixed64Field(name, id))
+}
+
+func (b *ExtensionBuilder) Sfixed32Field(name string, id int) *ExtensionBuilder {
+	return b.Fields(Sfixed32Field(name, id))
+}
+
+func (b *ExtensionBuilder) Sfixed64Field(name string, id int) *ExtensionBuilder {
+	return b.Fields(Sfixed64Field(name, id))
+}
+
+func (b *ExtensionBuilder) BoolField(name string, id int) *ExtensionBuilder {
+	return b.Fields(BoolField(name, id))
+}
+
+func (b *ExtensionBuilder) StringField(name string, id int) *ExtensionBuilder {
+	return b.Fields(StringField(name, id))
+}
+
+func (b *ExtensionBuilder) BytesField(name string, id int) *ExtensionBuilder {
+	return b.Fields(BytesField(name, id))
+}
+
+func (b *ExtensionBuilder) MessageField(typ, name string, id int) *ExtensionBuilder {
+	return b.Fields(MessageField(typ, name, id))
+}
+
+func (b *ExtensionBuilder) EnumField(typ, name string, id int) *ExtensionBuilder {
+	return b.Fields(EnumField(typ, name, id))
+}
+
+func (b *ExtensionBuilder) TypedField(typ Type, name string, id int) *ExtensionBuilder {
+	return b.Fields(TypedField(typ, name, id))
+}
+
+// Field adds a field whose type is parsed from typ, see ParseType
+func (b *ExtensionBuilder) Field(typ, name string, id int) *ExtensionBuilder {
+	return b.Fields(TypedField(b.state.parseType(joinName(b.object.Name, name), typ), name, id))
+}
+
+func (b *ExtensionBuilder) Fields(v ...*Field) *ExtensionBuilder {
+	for _, f := range v {
+		b.state.checkField(b.object.Name, f)
+	}
+	b.object.Fields = append(b.object.Fields, v...)
+	return b
+}
+
+// LeadingComment sets the leading comment of the extension
+func (b *ExtensionBuilder) LeadingComment(s string) *ExtensionBuilder {
+	b.object.Comments.Leading = s
+	return b
+}
+
+// TrailingComment sets the trailing comment of the extension
+func (b *ExtensionBuilder) TrailingComment(s string) *ExtensionBuilder {
+	b.object.Comments.Trailing = s
+	return b
+}
+
+// DetachedComment adds a detached comment block before the extension
+func (b *ExtensionBuilder) DetachedComment(s string) *ExtensionBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
+	return b
+}
+
+// CommentStyle sets the comment style of the extension
+func (b *ExtensionBuilder) CommentStyle(v CommentStyle) *ExtensionBuilder {
+	b.object.Comments.Style = v
+	return b
+}
+
+func (b *ExtensionBuilder) Build() (*Extension, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
+}
+
+func (b *ExtensionBuilder) MustBuild() *Extension {
+	return mustBuild(b.Build())
+}
+
+type ImportBuilder struct {
+	object *Import
+	state  builderState
+}
+
+// LeadingComment sets the leading comment of the import
+func (b *ImportBuilder) LeadingComment(s string) *ImportBuilder {
+	b.object.Comments.Leading = s
+	return b
+}
+
+// TrailingComment sets the trailing comment of the import
+func (b *ImportBuilder) TrailingComment(s string) *ImportBuilder {
+	b.object.Comments.Trailing = s
+	return b
+}
+
+// DetachedComment adds a detached comment block before the import
+func (b *ImportBuilder) DetachedComment(s string) *ImportBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
+	return b
+}
+
+// CommentStyle sets the comment style of the import
+func (b *ImportBuilder) CommentStyle(v CommentStyle) *ImportBuilder {
+	b.object.Comments.Style = v
+	return b
+}
+
+func (b *ImportBuilder) Build() (*Import, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
+}
+
+func (b *ImportBuilder) MustBuild() *Import {
+	return mustBuild(b.Build())
+}
+
+type OptionBuilder struct {
+	object *Option
+	state  builderState
+}
+
+// LeadingComment sets the leading comment of the option
+func (b *OptionBuilder) LeadingComment(s string) *OptionBuilder {
+	b.object.Comments.Leading = s
+	return b
+}
+
+// TrailingComment sets the trailing comment of the option
+func (b *OptionBuilder) TrailingComment(s string) *OptionBuilder {
+	b.object.Comments.Trailing = s
+	return b
+}
+
+// DetachedComment adds a detached comment block before the option
+func (b *OptionBuilder) DetachedComment(s string) *OptionBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
+	return b
+}
+
+// CommentStyle sets the comment style of the option
+func (b *OptionBuilder) CommentStyle(v CommentStyle) *OptionBuilder {
+	b.object.Comments.Style = v
+	return b
+}
+
+func (b *OptionBuilder) Build() (*Option, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
+}
+
+func (b *OptionBuilder) MustBuild() *Option {
+	return mustBuild(b.Build())
+}
+
+type FileBuilder struct {
+	object *File
+	state  builderState
+}
+
+// Header adds comment blocks to the top of the file, such as a
+// license banner
+func (b *FileBuilder) Header(v ...string) *FileBuilder {
+	b.object.Header = append(b.object.Header, v...)
+	return b
+}
+
+// GeneratedBy adds a header block that marks the file as generated
+// code, see GeneratedHeader
+func (b *FileBuilder) GeneratedBy(generator, version, source string) *FileBuilder {
+	if generator == "" {
+		b.state.errorf("", `generator name must not be empty`)
+	}
+	return b.Header(GeneratedHeader(generator, version, source))
+}
+
+// Syntax sets the syntax of the file.
+func (b *FileBuilder) Syntax(v Syntax) *FileBuilder {
+	b.object.Syntax = v
+	return b
+}
+
+// Edition sets the edition of the file. This also sets the syntax
+// of the file to SyntaxEditions
+func (b *FileBuilder) Edition(v Edition) *FileBuilder {
+	b.object.Syntax = SyntaxEditions
+	b.object.Edition = v
+	return b
+}
+
+func (b *FileBuilder) Package(s string) *FileBuilder {
+	b.object.Package = s
+	return b
+}
+
+// Import adds a single import type.
+func (b *FileBuilder) Import(path string, typ ImportType) *FileBuilder {
+	b.Imports(&Import{
+		Path: path,
+		Type: typ,
+	})
+	return b
+}
+
+func (b *FileBuilder) Imports(v ...*Import) *FileBuilder {
+	for _, imp := range v {
+		b.state.checkName("", imp.Path, "import")
+	}
+	b.object.Imports = append(b.object.Imports, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+func (b *FileBuilder) Enums(v ...*Enum) *FileBuilder {
+	for _, e := range v {
+		b.state.checkName(b.object.Package, e.Name, "enum")
+	}
+	b.object.Enums = append(b.object.Enums, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+func (b *FileBuilder) Extensions(v ...*Extension) *FileBuilder {
+	b.object.Extensions = append(b.object.Extensions, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+func (b *FileBuilder) Messages(v ...*Message) *FileBuilder {
+	for _, m := range v {
+		b.state.checkName(b.object.Package, m.Name, "message")
+	}
+	b.object.Messages = append(b.object.Messages, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+func (b *FileBuilder) Option(name string, value interface{}) *FileBuilder {
+	return b.Options(&Option{
+		Name:  name,
+		Value: value,
+	})
+}
+
+// Options adds option statements, such as those created by Builder.Option,
+// to the file
+func (b *FileBuilder) Options(v ...*Option) *FileBuilder {
+	b.object.Options = append(b.object.Options, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+// Features sets the file-wide Editions features
+func (b *FileBuilder) Features(v *FeatureSet) *FileBuilder {
+	b.object.Features = v
+	return b
+}
+
+func (b *FileBuilder) Services(v ...*Service) *FileBuilder {
+	for _, s := range v {
+		b.state.checkName(b.object.Package, s.Name, "service")
+	}
+	b.object.Services = append(b.object.Services, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+// LeadingComment sets the leading comment of the file
+func (b *FileBuilder) LeadingComment(s string) *FileBuilder {
+	b.object.Comments.Leading = s
+	return b
+}
+
+// TrailingComment sets the trailing comment of the file
+func (b *FileBuilder) TrailingComment(s string) *FileBuilder {
+	b.object.Comments.Trailing = s
+	return b
+}
+
+// DetachedComment adds a detached comment block before the file
+func (b *FileBuilder) DetachedComment(s string) *FileBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
+	return b
+}
+
+// CommentStyle sets the comment style of the file
+func (b *FileBuilder) CommentStyle(v CommentStyle) *FileBuilder {
+	b.object.Comments.Style = v
+	return b
+}
+
+// Build validates the file and returns it. The error lists every
+// problem found, see File.Validate
+func (b *FileBuilder) Build() (*File, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
+	if err := b.object.Validate(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
+}
+
+func (b *FileBuilder) MustBuild() *File {
+	return mustBuild(b.Build())
+}
+
+type EnumBuilder struct {
+	object *Enum
+	state  builderState
+}
+
+// LeadingComment sets the leading comment of the enum
+func (b *EnumBuilder) LeadingComment(s string) *EnumBuilder {
+	b.object.Comments.Leading = s
+	return b
+}
+
+// TrailingComment sets the trailing comment of the enum
+func (b *EnumBuilder) TrailingComment(s string) *EnumBuilder {
+	b.object.Comments.Trailing = s
+	return b
+}
+
+// DetachedComment adds a detached comment block before the enum
+func (b *EnumBuilder) DetachedComment(s string) *EnumBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
+	return b
+}
+
+// CommentStyle sets the comment style of the enum
+func (b *EnumBuilder) CommentStyle(v CommentStyle) *EnumBuilder {
+	b.object.Comments.Style = v
+	return b
+}
+
+// Build returns the enum. Duplicate values are reported here, as
+// they are allowed if the `allow_alias` option is set
+func (b *EnumBuilder) Build() (*Enum, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
+	v := &validator{}
+	v.enum("", b.object)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
+}
+
+func (b *EnumBuilder) MustBuild() *Enum {
+	return mustBuild(b.Build())
+}
+
+// Comment sets the leading comment for the enum. It is the same as
+// LeadingComment
+func (b *EnumBuilder) Comment(s string) *EnumBuilder {
+	return b.LeadingComment(s)
+}
+
+// ReserveNumbers reserves individual numbers
+func (b *EnumBuilder) ReserveNumbers(v ...int) *EnumBuilder {
+	for _, n := range v {
+		b.object.ReservedRanges = append(b.object.ReservedRanges, &Range{Start: n, End: n})
+	}
+	return b
+}
+
+// ReserveRange reserves the numbers from start to end, inclusive
+func (b *EnumBuilder) ReserveRange(start, end int) *EnumBuilder {
+	b.object.ReservedRanges = append(b.object.ReservedRanges, &Range{Start: start, End: end})
+	return b
+}
+
+// ReserveRangeToMax reserves all numbers starting from start
+func (b *EnumBuilder) ReserveRangeToMax(start int) *EnumBuilder {
+	b.object.ReservedRanges = append(b.object.ReservedRanges, &Range{Start: start, ToMax: true})
+	return b
+}
+
+// ReserveNames reserves names
+func (b *EnumBuilder) ReserveNames(v ...string) *EnumBuilder {
+	b.object.ReservedNames = append(b.object.ReservedNames, v...)
+	return b
+}
+
+func (b *EnumBuilder) Option(name string, value interface{}) *EnumBuilder {
+	b.object.Options = append(b.object.Options, &Option{
+		Name:  name,
+		Value: value,
+	})
+	return b
+}
+
+// Options adds option statements, such as those created by Builder.Option,
+// to the enum
+func (b *EnumBuilder) Options(v ...*Option) *EnumBuilder {
+	b.object.Options = append(b.object.Options, v...)
+	return b
+}
+
+func (b *EnumBuilder) Features(v *FeatureSet) *EnumBuilder {
+	b.object.Features = v
+	return b
+}
+
+func (b *EnumBuilder) Element(name string, value int) *EnumBuilder {
+	return b.EnumElements(&EnumElement{
+		Name:  name,
+		Value: value,
+	})
+}
+
+func (b *EnumBuilder) EnumElements(el ...*EnumElement) *EnumBuilder {
+	for _, v := range el {
+		b.state.checkName(b.object.Name, v.Name, "enum value")
+	}
+	b.object.Elements = append(b.object.Elements, el...)
+	return b
+}
+
+type EnumElementBuilder struct {
+	object *EnumElement
+	state  builderState
+}
+
+// Comment sets the trailing comment for the enum value. It is the same as
+// TrailingComment
+func (b *EnumElementBuilder) Comment(s string) *EnumElementBuilder {
+	return b.TrailingComment(s)
+}
+
+func (b *EnumElementBuilder) Features(v *FeatureSet) *EnumElementBuilder {
+	b.object.Features = v
+	return b
+}
+
+// Option adds a compact option to the enum value
+func (b *EnumElementBuilder) Option(name string, value interface{}) *EnumElementBuilder {
+	b.object.Options = append(b.object.Options, &Option{
+		Name:    name,
+		Value:   value,
+		Compact: true,
+	})
+	return b
+}
+
+// Deprecated sets the `deprecated` option for the enum value
+func (b *EnumElementBuilder) Deprecated(v bool) *EnumElementBuilder {
+	return b.Option("deprecated", v)
+}
+
+// DebugRedact sets the `debug_redact` option for the enum value
+func (b *EnumElementBuilder) DebugRedact(v bool) *EnumElementBuilder {
+	return b.Option("debug_redact", v)
+}
+
+// LeadingComment sets the leading comment of the enum value
+func (b *EnumElementBuilder) LeadingComment(s string) *EnumElementBuilder {
+	b.object.Comments.Leading = s
+	return b
+}
+
+// TrailingComment sets the trailing comment of the enum value
+func (b *EnumElementBuilder) TrailingComment(s string) *EnumElementBuilder {
+	b.object.Comments.Trailing = s
+	return b
+}
+
+// DetachedComment adds a detached comment block before the enum value
+func (b *EnumElementBuilder) DetachedComment(s string) *EnumElementBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
+	return b
+}
+
+// CommentStyle sets the comment style of the enum value
+func (b *EnumElementBuilder) CommentStyle(v CommentStyle) *EnumElementBuilder {
+	b.object.Comments.Style = v
+	return b
+}
+
+func (b *EnumElementBuilder) Build() (*EnumElement, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
+}
+
+func (b *EnumElementBuilder) MustBuild() *EnumElement {
+	return mustBuild(b.Build())
+}
+
+type MessageBuilder struct {
+	object *Message
+	state  builderState
+}
+
+// Comment sets the leading comment for the message. It is the same as
+// LeadingComment
+func (b *MessageBuilder) Comment(s string) *MessageBuilder {
+	return b.LeadingComment(s)
+}
+
+func (b *MessageBuilder) DoubleField(name string, id int) *MessageBuilder {
+	return b.Fields(DoubleField(name, id))
+}
+
+func (b *MessageBuilder) FloatField(name string, id int) *MessageBuilder {
+	return b.Fields(FloatField(name, id))
+}
+
+func (b *MessageBuilder) Int32Field(name string, id int) *MessageBuilder {
+	return b.Fields(Int32Field(name, id))
+}
+
+func (b *MessageBuilder) Int64Field(name string, id int) *MessageBuilder {
+	return b.Fields(Int64Field(name, id))
+}
+
+func (b *MessageBuilder) Uint32Field(name string, id int) *MessageBuilder {
+	return b.Fields(Uint32Field(name, id))
+}
+
+func (b *MessageBuilder) Uint64Field(name string, id int) *MessageBuilder {
+	return b.Fields(Uint64Field(name, id))
+}
+
+func (b *MessageBuilder) Sint32Field(name string, id int) *MessageBuilder {
+	return b.Fields(Sint32Field(name, id))
+}
+
+func (b *MessageBuilder) Sint64Field(name string, id int) *MessageBuilder {
+	return b.Fields(Sint64Field(name, id))
+}
+
+func (b *MessageBuilder) Fixed32Field(name string, id int) *MessageBuilder {
+	return b.Fields(Fixed32Field(name, id))
+}
+
+func (b *MessageBuilder) Fixed64Field(name string, id int) *MessageBuilder {
+	return b.Fields(Fixed64Field(name, id))
+}
+
+func (b *MessageBuilder) Sfixed32Field(name string, id int) *MessageBuilder {
+	return b.Fields(Sfixed32Field(name, id))
+}
+
+func (b *MessageBuilder) Sfixed64Field(name string, id int) *MessageBuilder {
+	return b.Fields(Sfixed64Field(name, id))
+}
+
+func (b *MessageBuilder) BoolField(name string, id int) *MessageBuilder {
+	return b.Fields(BoolField(name, id))
+}
+
+func (b *MessageBuilder) StringField(name string, id int) *MessageBuilder {
+	return b.Fields(StringField(name, id))
+}
+
+func (b *MessageBuilder) BytesField(name string, id int) *MessageBuilder {
+	return b.Fields(BytesField(name, id))
+}
+
+func (b *MessageBuilder) MessageField(typ, name string, id int) *MessageBuilder {
+	return b.Fields(MessageField(typ, name, id))
+}
+
+func (b *MessageBuilder) EnumField(typ, name string, id int) *MessageBuilder {
+	return b.Fields(EnumField(typ, name, id))
+}
+
+func (b *MessageBuilder) TypedField(typ Type, name string, id int) *MessageBuilder {
+	return b.Fields(TypedField(typ, name, id))
+}
+
+// MapField adds a map field whose key and value types are parsed
+// from keyType and valueType, see ParseType
+func (b *MessageBuilder) MapField(keyType, valueType, name string, id int) *MessageBuilder {
+	return b.Fields(TypedField(b.state.parseMapType(joinName(b.object.Name, name), keyType, valueType), name, id))
+}
+
+func (b *MessageBuilder) Option(name string, value interface{}) *MessageBuilder {
+	return b.Options(&Option{
+		Name:  name,
+		Value: value,
+	})
+}
+
+// Options adds option statements, such as those created by Builder.Option,
+// to the message
+func (b *MessageBuilder) Options(v ...*Option) *MessageBuilder {
+	b.object.Options = append(b.object.Options, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+// ReserveNumbers reserves individual numbers
+func (b *MessageBuilder) ReserveNumbers(v ...int) *MessageBuilder {
+	ranges := make([]*Range, 0, len(v))
+	for _, n := range v {
+		ranges = append(ranges, &Range{Start: n, End: n})
+	}
+	return b.reserve(&Reserved{Ranges: ranges})
+}
+
+// ReserveRange reserves the numbers from start to end, inclusive
+func (b *MessageBuilder) ReserveRange(start, end int) *MessageBuilder {
+	return b.reserve(&Reserved{Ranges: []*Range{{Start: start, End: end}}})
+}
+
+// ReserveRangeToMax reserves all numbers starting from start
+func (b *MessageBuilder) ReserveRangeToMax(start int) *MessageBuilder {
+	return b.reserve(&Reserved{Ranges: []*Range{{Start: start, ToMax: true}}})
+}
+
+// ReserveNames reserves names
+func (b *MessageBuilder) ReserveNames(v ...string) *MessageBuilder {
+	return b.reserve(&Reserved{Names: v})
+}
+
+func (b *MessageBuilder) reserve(r *Reserved) *MessageBuilder {
+	b.object.ReservedRanges = append(b.object.ReservedRanges, r.Ranges...)
+	b.object.ReservedNames = append(b.object.ReservedNames, r.Names...)
+	b.object.Body = appendBody(b.object.Body, []*Reserved{r})
+	return b
+}
+
+// ExtensionRange adds an extension range from start to end, inclusive
+func (b *MessageBuilder) ExtensionRange(start, end int) *MessageBuilder {
+	return b.ExtensionRanges(&ExtensionRange{Ranges: []*Range{{Start: start, End: end}}})
+}
+
+func (b *MessageBuilder) ExtensionRanges(v ...*ExtensionRange) *MessageBuilder {
+	b.object.ExtensionRanges = append(b.object.ExtensionRanges, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+func (b *MessageBuilder) Features(v *FeatureSet) *MessageBuilder {
+	b.object.Features = v
+	return b
+}
+
+func (b *MessageBuilder) Extensions(v ...*Extension) *MessageBuilder {
+	b.object.Extensions = append(b.object.Extensions, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+func (b *MessageBuilder) Messages(v ...*Message) *MessageBuilder {
+	for _, m := range v {
+		b.state.checkName(b.object.Name, m.Name, "message")
+	}
+	b.object.Messages = append(b.object.Messages, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+func (b *MessageBuilder) OneOfs(v ...*OneOf) *MessageBuilder {
+	for _, oneof := range v {
+		b.state.checkName(b.object.Name, oneof.Name, "oneof")
+		for _, f := range oneof.Fields {
+			b.state.checkField(b.object.Name, f)
+		}
+	}
+	b.object.OneOfs = append(b.object.OneOfs, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+func (b *MessageBuilder) Enums(v ...*Enum) *MessageBuilder {
+	for _, e := range v {
+		b.state.checkName(b.object.Name, e.Name, "enum")
+	}
+	b.object.Enums = append(b.object.Enums, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+// Field adds a field whose type is parsed from typ, see ParseType
+func (b *MessageBuilder) Field(typ, name string, id int) *MessageBuilder {
+	return b.Fields(TypedField(b.state.parseType(joinName(b.object.Name, name), typ), name, id))
+}
+
+func (b *MessageBuilder) Fields(v ...*Field) *MessageBuilder {
+	for _, f := range v {
+		b.state.checkField(b.object.Name, f)
+	}
+	b.object.Fields = append(b.object.Fields, v...)
+	b.object.Body = appendBody(b.object.Body, v)
+	return b
+}
+
+// LeadingComment sets the leading comment of the message
+func (b *MessageBuilder) LeadingComment(s string) *MessageBuilder {
+	b.object.Comments.Leading = s
+	return b
+}
+
+// TrailingComment sets the trailing comment of the message
+func (b *MessageBuilder) TrailingComment(s string) *MessageBuilder {
+	b.object.Comments.Trailing = s
+	return b
+}
+
+// DetachedComment adds a detached comment block before the message
+func (b *MessageBuilder) DetachedComment(s string) *MessageBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
+	return b
+}
+
+// CommentStyle sets the comment style of the message
+func (b *MessageBuilder) CommentStyle(v CommentStyle) *MessageBuilder {
+	b.object.Comments.Style = v
+	return b
+}
+
+// Build validates the message and returns it. Checks that depend on
+// the syntax of the file are deferred until the file is built
+func (b *MessageBuilder) Build() (*Message, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
 	}
+	v := &validator{}
+	v.declarations("", make(map[string]string), []*Message{b.object}, nil, nil)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
 }
 
-type Builder struct{}
+func (b *MessageBuilder) MustBuild() *Message {
+	return mustBuild(b.Build())
+}
 
-func (b *Builder) Enum(name string) *EnumBuilder {
-	return &EnumBuilder{object: &Enum{Name: name}}
+type OneOfBuilder struct {
+	object *OneOf
+	state  builderState
 }
 
-func (b *Builder) EnumElement(name string, value int) *EnumElementBuilder {
-	return &EnumElementBuilder{object: &EnumElement{Name: name, Value: value}}
+func (b *OneOfBuilder) DoubleField(name string, id int) *OneOfBuilder {
+	return b.Fields(DoubleField(name, id))
 }
 
-func (b *Builder) Extension(name string) *ExtensionBuilder {
-	return &ExtensionBuilder{object: &Extension{Name: name}}
+func (b *OneOfBuilder) FloatField(name string, id int) *OneOfBuilder {
+	return b.Fields(FloatField(name, id))
 }
 
-func (b *Builder) File() *FileBuilder {
-	return &FileBuilder{object: &File{}}
+func (b *OneOfBuilder) Int32Field(name string, id int) *OneOfBuilder {
+	return b.Fields(Int32Field(name, id))
 }
 
-func (b *Builder) Message(name string) *MessageBuilder {
-	return &MessageBuilder{object: &Message{Name: name}}
+func (b *OneOfBuilder) Int64Field(name string, id int) *OneOfBuilder {
+	return b.Fields(Int64Field(name, id))
 }
 
-func (b *Builder) MessageLiteral() *MessageLiteralBuilder {
-	return &MessageLiteralBuilder{object: &MessageLiteral{}}
+func (b *OneOfBuilder) Uint32Field(name string, id int) *OneOfBuilder {
+	return b.Fields(Uint32Field(name, id))
 }
 
-func (b *Builder) OneOf(name string) *OneOfBuilder {
-	return &OneOfBuilder{object: &OneOf{Name: name}}
+func (b *OneOfBuilder) Uint64Field(name string, id int) *OneOfBuilder {
+	return b.Fields(Uint64Field(name, id))
 }
 
-func (b *Builder) Service(name string) *ServiceBuilder {
-	return &ServiceBuilder{object: &Service{Name: name}}
+func (b *OneOfBuilder) Sint32Field(name string, id int) *OneOfBuilder {
+	return b.Fields(Sint32Field(name, id))
 }
 
-type ExtensionBuilder struct {
-	object *Extension
+func (b *OneOfBuilder) Sint64Field(name string, id int) *OneOfBuilder {
+	return b.Fields(Sint64Field(name, id))
 }
 
-func (b *ExtensionBuilder) StringField(name string, id int) *ExtensionBuilder {
-	b.object.Fields = append(b.object.Fields, StringField(name, id))
+func (b *OneOfBuilder) Fixed32Field(name string, id int) *OneOfBuilder {
+	return b.Fields(Fixed32Field(name, id))
+}
+
+func (b *OneOfBuilder) Fixed64Field(name string, id int) *OneOfBuilder {
+	return b.Fields(Fixed64Field(name, id))
+}
+
+func (b *OneOfBuilder) Sfixed32Field(name string, id int) *OneOfBuilder {
+	return b.Fields(Sfixed32Field(name, id))
+}
+
+func (b *OneOfBuilder) Sfixed64Field(name string, id int) *OneOfBuilder {
+	return b.Fields(Sfixed64Field(name, id))
+}
+
+func (b *OneOfBuilder) BoolField(name string, id int) *OneOfBuilder {
+	return b.Fields(BoolField(name, id))
+}
+
+func (b *OneOfBuilder) StringField(name string, id int) *OneOfBuilder {
+	return b.Fields(StringField(name, id))
+}
+
+func (b *OneOfBuilder) BytesField(name string, id int) *OneOfBuilder {
+	return b.Fields(BytesField(name, id))
+}
+
+func (b *OneOfBuilder) MessageField(typ, name string, id int) *OneOfBuilder {
+	return b.Fields(MessageField(typ, name, id))
+}
+
+func (b *OneOfBuilder) EnumField(typ, name string, id int) *OneOfBuilder {
+	return b.Fields(EnumField(typ, name, id))
+}
+
+func (b *OneOfBuilder) TypedField(typ Type, name string, id int) *OneOfBuilder {
+	return b.Fields(TypedField(typ, name, id))
+}
+
+// Field adds a field whose type is parsed from typ, see ParseType
+func (b *OneOfBuilder) Field(typ, name string, id int) *OneOfBuilder {
+	return b.Fields(TypedField(b.state.parseType(joinName(b.object.Name, name), typ), name, id))
+}
+
+func (b *OneOfBuilder) Features(v *FeatureSet) *OneOfBuilder {
+	b.object.Features = v
 	return b
 }
 
-func (b *ExtensionBuilder) Uint64Field(name string, id int) *ExtensionBuilder {
-	b.object.Fields = append(b.object.Fields, Uint64Field(name, id))
+func (b *OneOfBuilder) Fields(v ...*Field) *OneOfBuilder {
+	for _, f := range v {
+		b.state.checkField(b.object.Name, f)
+	}
+	b.object.Fields = append(b.object.Fields, v...)
 	return b
 }
 
-func (b *ExtensionBuilder) Field(typ, name string, id int) *ExtensionBuilder {
-	b.object.Fields = append(b.object.Fields, &Field{
-		Type: typ,
-		Name: name,
-		ID:   id,
-	})
+// LeadingComment sets the leading comment of the oneof
+func (b *OneOfBuilder) LeadingComment(s string) *OneOfBuilder {
+	b.object.Comments.Leading = s
 	return b
 }
 
-func (b *ExtensionBuilder) MustBuild() *Extension {
-	return b.object
+// TrailingComment sets the trailing comment of the oneof
+func (b *OneOfBuilder) TrailingComment(s string) *OneOfBuilder {
+	b.object.Comments.Trailing = s
+	return b
 }
 
-type FileBuilder struct {
-	object *File
+// DetachedComment adds a detached comment block before the oneof
+func (b *OneOfBuilder) DetachedComment(s string) *OneOfBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
+	return b
 }
 
-func (b *FileBuilder) Package(s string) *FileBuilder {
-	b.object.Package = s
+// CommentStyle sets the comment style of the oneof
+func (b *OneOfBuilder) CommentStyle(v CommentStyle) *OneOfBuilder {
+	b.object.Comments.Style = v
 	return b
 }
 
-// Import adds a single import type.
-func (b *FileBuilder) Import(path string, typ ImportType) *FileBuilder {
-	b.Imports(&Import{
-		Path: path,
-		Type: typ,
+func (b *OneOfBuilder) Build() (*OneOf, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
+}
+
+func (b *OneOfBuilder) MustBuild() *OneOf {
+	return mustBuild(b.Build())
+}
+
+type ExtensionRangeBuilder struct {
+	object *ExtensionRange
+	state  builderState
+}
+
+func (b *ExtensionRangeBuilder) checkRange(start, end int) {
+	if start < 0 || end < 0 {
+		b.state.errorf("", `extension range %d to %d must not contain negative numbers`, start, end)
+	}
+}
+
+// Range adds another range of numbers to the extension range
+func (b *ExtensionRangeBuilder) Range(start, end int) *ExtensionRangeBuilder {
+	b.checkRange(start, end)
+	b.object.Ranges = append(b.object.Ranges, &Range{Start: start, End: end})
+	return b
+}
+
+// Declaration adds an extension declaration
+func (b *ExtensionRangeBuilder) Declaration(number int, fullName, typ string) *ExtensionRangeBuilder {
+	return b.Declarations(&ExtensionDeclaration{
+		Number:   number,
+		FullName: fullName,
+		Type:     typ,
 	})
+}
+
+func (b *ExtensionRangeBuilder) Declarations(v ...*ExtensionDeclaration) *ExtensionRangeBuilder {
+	b.object.Declarations = append(b.object.Declarations, v...)
 	return b
 }
 
-func (b *FileBuilder) Imports(v ...*Import) *FileBuilder {
-	b.object.Imports = append(b.object.Imports, v...)
+func (b *ExtensionRangeBuilder) Verification(v VerificationState) *ExtensionRangeBuilder {
+	b.object.Verification = v
 	return b
 }
 
-func (b *FileBuilder) Enums(v ...*Enum) *FileBuilder {
-	b.object.Enums = append(b.object.Enums, v...)
+// Option adds an option to the extension range
+func (b *ExtensionRangeBuilder) Option(name string, value interface{}) *ExtensionRangeBuilder {
+	b.object.Options = append(b.object.Options, &Option{
+		Name:    name,
+		Value:   value,
+		Compact: true,
+	})
 	return b
 }
 
-func (b *FileBuilder) Extensions(v ...*Extension) *FileBuilder {
-	b.object.Extensions = append(b.object.Extensions, v...)
+// LeadingComment sets the leading comment of the extension range
+func (b *ExtensionRangeBuilder) LeadingComment(s string) *ExtensionRangeBuilder {
+	b.object.Comments.Leading = s
 	return b
 }
 
-func (b *FileBuilder) Messages(v ...*Message) *FileBuilder {
-	b.object.Messages = append(b.object.Messages, v...)
+// TrailingComment sets the trailing comment of the extension range
+func (b *ExtensionRangeBuilder) TrailingComment(s string) *ExtensionRangeBuilder {
+	b.object.Comments.Trailing = s
 	return b
 }
 
-func (b *FileBuilder) Option(name string, value interface{}) *FileBuilder {
-	b.object.Options = append(b.object.Options, &Option{
-		Name:  name,
-		Value: value,
-	})
+// DetachedComment adds a detached comment block before the extension range
+func (b *ExtensionRangeBuilder) DetachedComment(s string) *ExtensionRangeBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
 	return b
 }
 
-func (b *FileBuilder) Services(v ...*Service) *FileBuilder {
-	b.object.Services = append(b.object.Services, v...)
+// CommentStyle sets the comment style of the extension range
+func (b *ExtensionRangeBuilder) CommentStyle(v CommentStyle) *ExtensionRangeBuilder {
+	b.object.Comments.Style = v
 	return b
 }
 
-func (b *FileBuilder) Build() (*File, error) {
+func (b *ExtensionRangeBuilder) Build() (*ExtensionRange, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
 	return b.object, nil
 }
 
-type EnumBuilder struct {
-	object *Enum
+func (b *ExtensionRangeBuilder) MustBuild() *ExtensionRange {
+	return mustBuild(b.Build())
 }
 
-func (b *EnumBuilder) MustBuild() *Enum {
-	return b.object
+type FieldBuilder struct {
+	object *Field
+	state  builderState
 }
 
-func (b *EnumBuilder) Comment(s string) *EnumBuilder {
-	b.object.Comment = s
+func newFieldBuilder(f *Field) *FieldBuilder {
+	fb := &FieldBuilder{object: f}
+	fb.state.checkField("", f)
+	return fb
+}
+
+func (b *FieldBuilder) Cardinality(v FieldCardinality) *FieldBuilder {
+	b.object.Cardinality = v
 	return b
 }
 
-func (b *EnumBuilder) Element(name string, value int) *EnumBuilder {
-	return b.EnumElements(&EnumElement{
-		Name:  name,
-		Value: value,
+// Comment sets the trailing comment for the field. It is the same as
+// TrailingComment
+func (b *FieldBuilder) Comment(s string) *FieldBuilder {
+	return b.TrailingComment(s)
+}
+
+// Option adds a compact option to the field
+func (b *FieldBuilder) Option(name string, value interface{}) *FieldBuilder {
+	b.object.Options = append(b.object.Options, &Option{
+		Name:    name,
+		Value:   value,
+		Compact: true,
 	})
+	return b
 }
 
-func (b *EnumBuilder) EnumElements(el ...*EnumElement) *EnumBuilder {
-	b.object.Elements = append(b.object.Elements, el...)
+func (b *FieldBuilder) Features(v *FeatureSet) *FieldBuilder {
+	b.object.Features = v
 	return b
 }
 
-type EnumElementBuilder struct {
-	object *EnumElement
+// Deprecated sets the `deprecated` option for the field
+func (b *FieldBuilder) Deprecated(v bool) *FieldBuilder {
+	return b.Option("deprecated", v)
 }
 
-func (b *EnumElementBuilder) Comment(s string) *EnumElementBuilder {
-	b.object.Comment = s
+// JSONName sets the `json_name` option for the field
+func (b *FieldBuilder) JSONName(s string) *FieldBuilder {
+	return b.Option("json_name", s)
+}
+
+// Packed sets the `packed` option for the field
+func (b *FieldBuilder) Packed(v bool) *FieldBuilder {
+	return b.Option("packed", v)
+}
+
+// Default sets the `default` option for the field. Use an Identifier
+// for enum fields
+func (b *FieldBuilder) Default(v interface{}) *FieldBuilder {
+	return b.Option("default", v)
+}
+
+// DebugRedact sets the `debug_redact` option for the field
+func (b *FieldBuilder) DebugRedact(v bool) *FieldBuilder {
+	return b.Option("debug_redact", v)
+}
+
+// Retention sets the `retention` option for the field
+func (b *FieldBuilder) Retention(v OptionRetention) *FieldBuilder {
+	return b.Option("retention", Identifier(v.String()))
+}
+
+// Targets adds a `targets` option to the field for each of the given
+// target types
+func (b *FieldBuilder) Targets(v ...OptionTargetType) *FieldBuilder {
+	for _, target := range v {
+		b.Option("targets", Identifier(target.String()))
+	}
 	return b
 }
 
-func (b *EnumElementBuilder) MustBuild() *EnumElement {
-	return b.object
+// LeadingComment sets the leading comment of the field
+func (b *FieldBuilder) LeadingComment(s string) *FieldBuilder {
+	b.object.Comments.Leading = s
+	return b
 }
 
-type MessageBuilder struct {
-	object *Message
+// TrailingComment sets the trailing comment of the field
+func (b *FieldBuilder) TrailingComment(s string) *FieldBuilder {
+	b.object.Comments.Trailing = s
+	return b
 }
 
-func (b *MessageBuilder) Comment(s string) *MessageBuilder {
-	b.object.Comment = s
+// DetachedComment adds a detached comment block before the field
+func (b *FieldBuilder) DetachedComment(s string) *FieldBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
 	return b
 }
 
-func (b *MessageBuilder) StringField(name string, id int) *MessageBuilder {
-	b.object.Fields = append(b.object.Fields, StringField(name, id))
+// CommentStyle sets the comment style of the field
+func (b *FieldBuilder) CommentStyle(v CommentStyle) *FieldBuilder {
+	b.object.Comments.Style = v
 	return b
 }
 
-func (b *MessageBuilder) Uint64Field(name string, id int) *MessageBuilder {
-	b.object.Fields = append(b.object.Fields, Uint64Field(name, id))
+func (b *FieldBuilder) Build() (*Field, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
+}
+
+func (b *FieldBuilder) MustBuild() *Field {
+	return mustBuild(b.Build())
+}
+
+type ServiceBuilder struct {
+	object *Service
+	state  builderState
+}
+
+func (b *ServiceBuilder) Method(name, input, output string) *ServiceBuilder {
+	return b.TypedMethod(name, messageType(input), messageType(output))
+}
+
+// TypedMethod adds a method whose input and output types may refer
+// to *Message nodes or to external types
+func (b *ServiceBuilder) TypedMethod(name string, input, output Type) *ServiceBuilder {
+	return b.Methods(&Method{
+		Name:   name,
+		Input:  input,
+		Output: output,
+	})
+}
+
+// Comment sets the leading comment for the service. It is the same as
+// LeadingComment
+func (b *ServiceBuilder) Comment(s string) *ServiceBuilder {
+	return b.LeadingComment(s)
+}
+
+func (b *ServiceBuilder) Methods(v ...*Method) *ServiceBuilder {
+	for _, m := range v {
+		b.state.checkName(b.object.Name, m.Name, "method")
+		b.state.checkMethod(b.object.Name, m)
+	}
+	b.object.Methods = append(b.object.Methods, v...)
 	return b
 }
 
-func (b *MessageBuilder) Option(name string, value interface{}) *MessageBuilder {
+func (b *ServiceBuilder) Option(name string, value interface{}) *ServiceBuilder {
 	b.object.Options = append(b.object.Options, &Option{
 		Name:  name,
 		Value: value,
@@ -197,91 +1477,146 @@ func (b *MessageBuilder) Option(name string, value interface{}) *MessageBuilder
 	return b
 }
 
-func (b *MessageBuilder) Extensions(v ...*Extension) *MessageBuilder {
-	b.object.Extensions = append(b.object.Extensions, v...)
+// Options adds option statements, such as those created by Builder.Option,
+// to the service
+func (b *ServiceBuilder) Options(v ...*Option) *ServiceBuilder {
+	b.object.Options = append(b.object.Options, v...)
 	return b
 }
 
-func (b *MessageBuilder) Messages(v ...*Message) *MessageBuilder {
-	b.object.Messages = append(b.object.Messages, v...)
-	return b
+// StreamingMethod adds a method that may stream its input, its output, or both
+func (b *ServiceBuilder) StreamingMethod(name, input, output string, clientStream, serverStream bool) *ServiceBuilder {
+	return b.Methods(&Method{
+		Name:            name,
+		Input:           messageType(input),
+		Output:          messageType(output),
+		ClientStreaming: clientStream,
+		ServerStreaming: serverStream,
+	})
 }
 
-func (b *MessageBuilder) OneOfs(v ...*OneOf) *MessageBuilder {
-	b.object.OneOfs = append(b.object.OneOfs, v...)
+// LeadingComment sets the leading comment of the service
+func (b *ServiceBuilder) LeadingComment(s string) *ServiceBuilder {
+	b.object.Comments.Leading = s
 	return b
 }
 
-func (b *MessageBuilder) Enums(v ...*Enum) *MessageBuilder {
-	b.object.Enums = append(b.object.Enums, v...)
+// TrailingComment sets the trailing comment of the service
+func (b *ServiceBuilder) TrailingComment(s string) *ServiceBuilder {
+	b.object.Comments.Trailing = s
 	return b
 }
 
-func (b *MessageBuilder) Field(typ, name string, id int) *MessageBuilder {
-	return b.Fields(&Field{
-		Type: typ,
-		Name: name,
-		ID:   id,
-	})
+// DetachedComment adds a detached comment block before the service
+func (b *ServiceBuilder) DetachedComment(s string) *ServiceBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
+	return b
 }
 
-func (b *MessageBuilder) Fields(v ...*Field) *MessageBuilder {
-	b.object.Fields = append(b.object.Fields, v...)
+// CommentStyle sets the comment style of the service
+func (b *ServiceBuilder) CommentStyle(v CommentStyle) *ServiceBuilder {
+	b.object.Comments.Style = v
 	return b
 }
 
-func (b *MessageBuilder) Build() (*Message, error) {
+func (b *ServiceBuilder) Build() (*Service, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
 	return b.object, nil
 }
 
-func (b *MessageBuilder) MustBuild() *Message {
-	obj, err := b.Build()
-	if err != nil {
-		panic(err)
-	}
-	return obj
+func (b *ServiceBuilder) MustBuild() *Service {
+	return mustBuild(b.Build())
 }
 
-type OneOfBuilder struct {
-	object *OneOf
+type MethodBuilder struct {
+	object *Method
+	state  builderState
 }
 
-func (b *OneOfBuilder) StringField(name string, id int) *OneOfBuilder {
-	b.object.Fields = append(b.object.Fields, StringField(name, id))
+// Comment sets the leading comment for the method. It is the same as
+// LeadingComment
+func (b *MethodBuilder) Comment(s string) *MethodBuilder {
+	return b.LeadingComment(s)
+}
+
+// Stream specifies if the client and/or the server stream their messages
+func (b *MethodBuilder) Stream(client, server bool) *MethodBuilder {
+	b.object.ClientStreaming = client
+	b.object.ServerStreaming = server
 	return b
 }
 
-func (b *OneOfBuilder) Uint64Field(name string, id int) *OneOfBuilder {
-	b.object.Fields = append(b.object.Fields, Uint64Field(name, id))
+func (b *MethodBuilder) Option(name string, value interface{}) *MethodBuilder {
+	b.object.Options = append(b.object.Options, &Option{
+		Name:  name,
+		Value: value,
+	})
 	return b
 }
 
-func (b *OneOfBuilder) MustBuild() *OneOf {
-	return b.object
+// Options adds option statements, such as those created by Builder.Option,
+// to the method
+func (b *MethodBuilder) Options(v ...*Option) *MethodBuilder {
+	b.object.Options = append(b.object.Options, v...)
+	return b
 }
 
-type ServiceBuilder struct {
-	object *Service
+// Deprecated sets the `deprecated` option for the method
+func (b *MethodBuilder) Deprecated(v bool) *MethodBuilder {
+	return b.Option("deprecated", v)
 }
 
-func (b *ServiceBuilder) Method(name, input, output string) *ServiceBuilder {
-	b.object.Methods = append(b.object.Methods, &Method{
-		Name:   name,
-		Input:  input,
-		Output: output,
-	})
+// IdempotencyLevel sets the `idempotency_level` option for the method
+func (b *MethodBuilder) IdempotencyLevel(v IdempotencyLevel) *MethodBuilder {
+	return b.Option("idempotency_level", Identifier(v.String()))
+}
+
+// LeadingComment sets the leading comment of the method
+func (b *MethodBuilder) LeadingComment(s string) *MethodBuilder {
+	b.object.Comments.Leading = s
 	return b
 }
 
-func (b *ServiceBuilder) MustBuild() *Service {
-	return b.object
+// TrailingComment sets the trailing comment of the method
+func (b *MethodBuilder) TrailingComment(s string) *MethodBuilder {
+	b.object.Comments.Trailing = s
+	return b
+}
+
+// DetachedComment adds a detached comment block before the method
+func (b *MethodBuilder) DetachedComment(s string) *MethodBuilder {
+	b.object.Comments.Detached = append(b.object.Comments.Detached, s)
+	return b
+}
+
+// CommentStyle sets the comment style of the method
+func (b *MethodBuilder) CommentStyle(v CommentStyle) *MethodBuilder {
+	b.object.Comments.Style = v
+	return b
+}
+
+func (b *MethodBuilder) Build() (*Method, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
+}
+
+func (b *MethodBuilder) MustBuild() *Method {
+	return mustBuild(b.Build())
 }
 
 type MessageLiteralBuilder struct {
 	object *MessageLiteral
+	state  builderState
 }
 
 func (b *MessageLiteralBuilder) Field(name string, value interface{}) *MessageLiteralBuilder {
+	if name == "" {
+		b.state.errorf("", `message literal field name must not be empty`)
+	}
 	b.object.Fields = append(b.object.Fields, &MessageLiteralField{
 		Name:  name,
 		Value: value,
@@ -289,6 +1624,30 @@ func (b *MessageLiteralBuilder) Field(name string, value interface{}) *MessageLi
 	return b
 }
 
+func (b *MessageLiteralBuilder) Build() (*MessageLiteral, error) {
+	if err := b.state.err(); err != nil {
+		return nil, err
+	}
+	return b.object, nil
+}
+
 func (b *MessageLiteralBuilder) MustBuild() *MessageLiteral {
-	return b.object
+	return mustBuild(b.Build())
+}
+
+// appendBody records the order in which declarations are added to a
+// File or a Message
+func appendBody[T BodyElement](body []BodyElement, v []T) []BodyElement {
+	for _, x := range v {
+		body = append(body, x)
+	}
+	return body
+}
+
+// mustBuild panics if err is not nil
+func mustBuild[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
 }
@@ -0,0 +1,179 @@
+package protowrite_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lestrrat-go/protowrite"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilderErrors(t *testing.T) {
+	var b protowrite.Builder
+
+	testcases := []struct {
+		Name   string
+		Build  func() error
+		Errors int
+	}{
+		{
+			Name: "empty message name",
+			Build: func() error {
+				_, err := b.Message("").Build()
+				return err
+			},
+			Errors: 1,
+		},
+		{
+			Name: "duplicate field names and numbers",
+			Build: func() error {
+				_, err := b.Message("Message").
+					StringField("a", 1).
+					Uint64Field("a", 2).
+					StringField("b", 1).
+					Build()
+				return err
+			},
+			Errors: 2,
+		},
+		{
+			Name: "negative field number",
+			Build: func() error {
+				_, err := b.Field("string", "a", -1).Build()
+				return err
+			},
+			Errors: 1,
+		},
+		{
+			Name: "field conflicts with nested message",
+			Build: func() error {
+				_, err := b.Message("Message").
+					StringField("Nested", 1).
+					Messages(b.Message("Nested").MustBuild()).
+					Build()
+				return err
+			},
+			Errors: 1,
+		},
+		{
+			Name: "duplicate field number across oneof",
+			Build: func() error {
+				_, err := b.Message("Message").
+					StringField("a", 1).
+					OneOfs(b.OneOf("choice").StringField("b", 1).MustBuild()).
+					Build()
+				return err
+			},
+			Errors: 1,
+		},
+		{
+			Name: "duplicate oneof fields",
+			Build: func() error {
+				_, err := b.OneOf("choice").StringField("a", 1).StringField("a", 2).Build()
+				return err
+			},
+			Errors: 1,
+		},
+		{
+			Name: "duplicate enum values names",
+			Build: func() error {
+				_, err := b.Enum("Kind").Element("ZERO", 0).Element("ZERO", 1).Build()
+				return err
+			},
+			Errors: 1,
+		},
+		{
+			Name: "duplicate enum numbers",
+			Build: func() error {
+				_, err := b.Enum("Kind").Element("ZERO", 0).Element("NONE", 0).Build()
+				return err
+			},
+			Errors: 1,
+		},
+		{
+			Name: "extension fields",
+			Build: func() error {
+				_, err := b.Extension("").StringField("", 100).Uint64Field("b", -1).Build()
+				return err
+			},
+			Errors: 3,
+		},
+		{
+			Name: "service methods",
+			Build: func() error {
+				_, err := b.Service("Svc").
+					Method("Call", "Request", "Response").
+					Method("Call", "Request", "").
+					Build()
+				return err
+			},
+			Errors: 2,
+		},
+		{
+			Name: "empty method name",
+			Build: func() error {
+				_, err := b.Method("", "Request", "Response").Build()
+				return err
+			},
+			Errors: 1,
+		},
+		{
+			Name: "negative extension range",
+			Build: func() error {
+				_, err := b.ExtensionRange(-1, 100).Build()
+				return err
+			},
+			Errors: 1,
+		},
+		{
+			Name: "misspelled scalar types",
+			Build: func() error {
+				_, err := b.Message("Message").
+					Field("unit64", "a", 1).
+					Field("Unit64", "b", 2).
+					MapField("strnig", "int", "c", 3).
+					Build()
+				return err
+			},
+			Errors: 3,
+		},
+		{
+			Name: "invalid type names",
+			Build: func() error {
+				_, err := b.Extension("Message").Field("foo..Bar", "a", 100).Field("map<string>", "b", 101).Build()
+				return err
+			},
+			Errors: 2,
+		},
+		{
+			Name: "duplicate top-level declarations",
+			Build: func() error {
+				_, err := b.File().
+					Package(`foo.bar`).
+					Messages(b.Message("Thing").MustBuild()).
+					Enums(b.Enum("Thing").Element("ZERO", 0).MustBuild()).
+					Build()
+				return err
+			},
+			Errors: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Build()
+			require.Error(t, err, `Build should fail`)
+
+			var list protowrite.ValidationErrors
+			require.True(t, errors.As(err, &list), `error should be a ValidationErrors`)
+			require.Len(t, list, tc.Errors, `number of errors should match (%s)`, err)
+		})
+	}
+
+	t.Run("MustBuild panics", func(t *testing.T) {
+		require.Panics(t, func() {
+			b.Enum("Kind").Element("", 0).MustBuild()
+		})
+	})
+}
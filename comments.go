@@ -0,0 +1,168 @@
+package protowrite
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CommentStyle specifies how comments are written
+type CommentStyle int
+
+const (
+	// CommentStyleLine writes comments using `//`
+	CommentStyleLine CommentStyle = iota
+	// CommentStyleBlock writes comments using `/* */`
+	CommentStyleBlock
+)
+
+// Comments holds the comments attached to a declaration. The model
+// follows the one used by SourceCodeInfo in descriptor.proto
+type Comments struct {
+	// Detached lists comment blocks that are written before the
+	// declaration, each separated from what follows it by a blank line.
+	// They are not considered part of the declaration's documentation
+	Detached []string
+	// Leading is written on the lines immediately before the declaration
+	Leading string
+	// Trailing is written after the declaration on the same line. For
+	// declarations with a body, such as messages, it is written after
+	// the opening brace. Trailing comments that span multiple lines are
+	// always written using `/* */`, so that they are not mistaken for the
+	// leading comment of the next declaration
+	Trailing string
+	// Style specifies how the comments are written. Defaults to CommentStyleLine
+	Style CommentStyle
+}
+
+// GeneratedHeader returns a header block that marks a file as generated
+// by generator, for use in File.Header. The first line is the standard
+// `Code generated by <generator>. DO NOT EDIT.` marker, which is
+// recognized by linters and code review tools. It is followed by the
+// version of the generator and the source the file was generated from,
+// each of which is omitted if empty
+func GeneratedHeader(generator, version, source string) string {
+	lines := []string{fmt.Sprintf("Code generated by %s. DO NOT EDIT.", generator)}
+	if version != "" {
+		lines = append(lines, fmt.Sprintf("version: %s %s", generator, version))
+	}
+	if source != "" {
+		lines = append(lines, fmt.Sprintf("source: %s", source))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// withLeading returns c, using s as the leading comment if c has none.
+// It is used to support the deprecated Comment fields
+func (c Comments) withLeading(s string) Comments {
+	if c.Leading == "" {
+		c.Leading = s
+	}
+	return c
+}
+
+// withTrailing returns c, using s as the trailing comment if c has none
+func (c Comments) withTrailing(s string) Comments {
+	if c.Trailing == "" {
+		c.Trailing = s
+	}
+	return c
+}
+
+// encodeLeading writes the detached and leading comments, each starting
+// on a new line at the current indentation level
+func (c *Comments) encodeLeading(e *encodeState) {
+	if len(c.Detached) == 0 && c.Leading == "" {
+		return
+	}
+	c.writeLeading(e, e.indent())
+}
+
+func (c *Comments) writeLeading(dst io.Writer, indent string) {
+	for _, s := range c.Detached {
+		writeComment(dst, indent, s, c.Style)
+		io.WriteString(dst, "\n")
+	}
+	if c.Leading != "" {
+		writeComment(dst, indent, c.Leading, c.Style)
+	}
+}
+
+// encodeTrailing writes the trailing comment, if any
+func (c *Comments) encodeTrailing(e *encodeState) {
+	s := c.Trailing
+	if s == "" {
+		return
+	}
+	if c.Style == CommentStyleLine && !strings.Contains(s, "\n") {
+		e.WriteString(" //")
+		writeCommentLine(e, s)
+		return
+	}
+	// unlike leading comments, the comment starts on the same line as
+	// the declaration, and the closing marker is not on a line of its own
+	lines := strings.Split(strings.ReplaceAll(s, "*/", "* /"), "\n")
+	e.WriteString(" /*")
+	writeCommentLine(e, lines[0])
+	for _, line := range lines[1:] {
+		e.newline()
+		e.WriteString(" *")
+		writeCommentLine(e, line)
+	}
+	e.WriteString(" */")
+}
+
+// writeComment writes s on its own lines, prefixed with indent
+func writeComment(dst io.Writer, indent, s string, style CommentStyle) {
+	if style == CommentStyleBlock {
+		io.WriteString(dst, "\n")
+		io.WriteString(dst, indent)
+		writeBlockComment(dst, indent, s)
+		return
+	}
+	for {
+		line, rest, found := strings.Cut(s, "\n")
+		io.WriteString(dst, "\n")
+		io.WriteString(dst, indent)
+		io.WriteString(dst, "//")
+		writeCommentLine(dst, line)
+		if !found {
+			return
+		}
+		s = rest
+	}
+}
+
+// writeBlockComment writes s as a `/* */` comment. Comments that span
+// multiple lines are written with a leading '*' on every line, which is
+// stripped when the comment is parsed
+func writeBlockComment(dst io.Writer, indent, s string) {
+	// "*/" would terminate the comment early
+	lines := strings.Split(strings.ReplaceAll(s, "*/", "* /"), "\n")
+	if len(lines) == 1 {
+		io.WriteString(dst, "/*")
+		writeCommentLine(dst, lines[0])
+		io.WriteString(dst, " */")
+		return
+	}
+	io.WriteString(dst, "/*")
+	for _, line := range lines {
+		io.WriteString(dst, "\n")
+		io.WriteString(dst, indent)
+		io.WriteString(dst, " *")
+		writeCommentLine(dst, line)
+	}
+	io.WriteString(dst, "\n")
+	io.WriteString(dst, indent)
+	io.WriteString(dst, " */")
+}
+
+// writeCommentLine writes line preceded by the space that separates it
+// from the comment marker. Empty lines are not written
+func writeCommentLine(dst io.Writer, line string) {
+	if line == "" {
+		return
+	}
+	io.WriteString(dst, " ")
+	io.WriteString(dst, line)
+}
@@ -0,0 +1,1407 @@
+package protowrite
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// DescriptorOptions controls the conversion between File objects and
+// protobuf descriptors
+type DescriptorOptions struct {
+	// Path is the name of the file, as it would appear in an import
+	// statement. Defaults to the package name with dots replaced by
+	// slashes, followed by ".proto"
+	Path string
+	// Resolver is used to look up imported files and the declarations
+	// within them. Defaults to protoregistry.GlobalFiles
+	Resolver protodesc.Resolver
+}
+
+func (o *DescriptorOptions) path(f *File) string {
+	if o != nil && o.Path != "" {
+		return o.Path
+	}
+	return strings.ReplaceAll(f.Package, ".", "/") + ".proto"
+}
+
+func (o *DescriptorOptions) resolver() protodesc.Resolver {
+	if o != nil && o.Resolver != nil {
+		return o.Resolver
+	}
+	return protoregistry.GlobalFiles
+}
+
+var scalarFieldTypes = map[ScalarType]descriptorpb.FieldDescriptorProto_Type{
+	TypeDouble:   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	TypeFloat:    descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	TypeInt32:    descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	TypeInt64:    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	TypeUint32:   descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	TypeUint64:   descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	TypeSint32:   descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+	TypeSint64:   descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+	TypeFixed32:  descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+	TypeFixed64:  descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+	TypeSfixed32: descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+	TypeSfixed64: descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+	TypeBool:     descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	TypeString:   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	TypeBytes:    descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+}
+
+// ToDescriptorProto converts f into a FileDescriptorProto. Type names are
+// resolved into their fully-qualified forms, map fields are expanded into
+// map entry messages, and proto3 optional fields are given synthetic oneofs,
+// just as protoc would do.
+//
+// Options are resolved into the corresponding option messages. Custom
+// options may refer to extensions declared in f or in any of its imports.
+// Comments are not included.
+func ToDescriptorProto(f *File, options *DescriptorOptions) (*descriptorpb.FileDescriptorProto, error) {
+	b := &descriptorBuilder{
+		file:     f,
+		resolver: options.resolver(),
+		symbols:  make(symbolTable),
+		names:    newTypeNames(f),
+	}
+	fdp, err := b.build(options.path(f))
+	if err != nil {
+		return nil, fmt.Errorf(`failed to convert file to descriptor: %w`, err)
+	}
+	return fdp, nil
+}
+
+// ToDescriptor converts f into a protoreflect.FileDescriptor, which can
+// be used with packages such as dynamicpb. See ToDescriptorProto for details
+func ToDescriptor(f *File, options *DescriptorOptions) (protoreflect.FileDescriptor, error) {
+	fdp, err := ToDescriptorProto(f, options)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := protodesc.NewFile(fdp, options.resolver())
+	if err != nil {
+		return nil, fmt.Errorf(`failed to create file descriptor: %w`, err)
+	}
+	return fd, nil
+}
+
+// pendingOptions records options that need to be applied once all of the
+// declarations in the file have been converted, so that custom options
+// declared in the same file can be resolved
+type pendingOptions struct {
+	scope   string
+	target  proto.Message
+	options []*Option
+}
+
+type descriptorBuilder struct {
+	file     *File
+	resolver protodesc.Resolver
+	symbols  symbolTable
+	// names holds the fully-qualified names of the messages and
+	// enums in file, which may be referred to directly as types
+	names   *typeNames
+	pending []*pendingOptions
+	// shared lists extension ranges declared in the same statement,
+	// which share a single options message while being built
+	shared [][]*descriptorpb.DescriptorProto_ExtensionRange
+	// local is the descriptor for the file being built, without
+	// any of its options. It is used to resolve custom options
+	local protoreflect.FileDescriptor
+}
+
+func (b *descriptorBuilder) build(path string) (*descriptorpb.FileDescriptorProto, error) {
+	f := b.file
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name: proto.String(path),
+	}
+	if f.Package != "" {
+		fdp.Package = proto.String(f.Package)
+	}
+
+	switch f.Syntax {
+	case SyntaxProto2:
+		// protoc leaves the syntax unset for proto2 files
+	case SyntaxProto3:
+		fdp.Syntax = proto.String("proto3")
+	case SyntaxEditions:
+		fdp.Syntax = proto.String("editions")
+		switch f.edition() {
+		case Edition2023:
+			fdp.Edition = descriptorpb.Edition_EDITION_2023.Enum()
+		case Edition2024:
+			fdp.Edition = descriptorpb.Edition_EDITION_2024.Enum()
+		default:
+			return nil, fmt.Errorf(`unsupported edition %q`, f.edition())
+		}
+	default:
+		return nil, fmt.Errorf(`unknown syntax %s`, f.Syntax)
+	}
+
+	for i, imp := range f.Imports {
+		fd, err := b.resolver.FindFileByPath(imp.Path)
+		if err != nil {
+			return nil, fmt.Errorf(`failed to resolve import %q: %w`, imp.Path, err)
+		}
+		b.addImportSymbols(fd)
+		fdp.Dependency = append(fdp.Dependency, imp.Path)
+		switch imp.Type {
+		case ImportPublic:
+			fdp.PublicDependency = append(fdp.PublicDependency, int32(i))
+		case ImportWeak:
+			fdp.WeakDependency = append(fdp.WeakDependency, int32(i))
+		}
+	}
+	b.symbols.addFile(f)
+
+	fileOptions := &descriptorpb.FileOptions{}
+	if err := b.features(f.Features, &fileOptions.Features); err != nil {
+		return nil, fmt.Errorf(`invalid features for file: %w`, err)
+	}
+	b.deferOptions(f.Package, fileOptions, f.Options)
+	fdp.Options = fileOptions
+
+	for _, m := range f.Messages {
+		dp, err := b.message(f.Package, m)
+		if err != nil {
+			return nil, err
+		}
+		fdp.MessageType = append(fdp.MessageType, dp)
+	}
+	for _, e := range f.Enums {
+		ep, err := b.enum(f.Package, e)
+		if err != nil {
+			return nil, err
+		}
+		fdp.EnumType = append(fdp.EnumType, ep)
+	}
+	for _, ext := range f.Extensions {
+		list, err := b.extension(f.Package, ext)
+		if err != nil {
+			return nil, err
+		}
+		fdp.Extension = append(fdp.Extension, list...)
+	}
+	for _, s := range f.Services {
+		sp, err := b.service(f.Package, s)
+		if err != nil {
+			return nil, err
+		}
+		fdp.Service = append(fdp.Service, sp)
+	}
+
+	if err := b.applyPendingOptions(fdp); err != nil {
+		return nil, err
+	}
+	for _, list := range b.shared {
+		for _, erp := range list[1:] {
+			erp.Options = proto.Clone(list[0].Options).(*descriptorpb.ExtensionRangeOptions)
+		}
+	}
+	clearEmptyOptions(fdp)
+	return fdp, nil
+}
+
+// addImportSymbols registers the symbols in fd, as well as those in
+// the files that fd imports publicly
+func (b *descriptorBuilder) addImportSymbols(fd protoreflect.FileDescriptor) {
+	b.symbols.addDescriptor(fd)
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if imp := imports.Get(i); imp.IsPublic {
+			b.addImportSymbols(imp.FileDescriptor)
+		}
+	}
+}
+
+func (b *descriptorBuilder) deferOptions(scope string, target proto.Message, options []*Option) {
+	if len(options) == 0 {
+		return
+	}
+	b.pending = append(b.pending, &pendingOptions{scope: scope, target: target, options: options})
+}
+
+func (b *descriptorBuilder) features(fs *FeatureSet, dst **descriptorpb.FeatureSet) error {
+	if fs == nil {
+		return nil
+	}
+	if b.file.Syntax != SyntaxEditions {
+		return fmt.Errorf(`features are not allowed in %s`, b.file.Syntax)
+	}
+
+	v := &descriptorpb.FeatureSet{}
+	switch fs.FieldPresence {
+	case FieldPresenceExplicit:
+		v.FieldPresence = descriptorpb.FeatureSet_EXPLICIT.Enum()
+	case FieldPresenceImplicit:
+		v.FieldPresence = descriptorpb.FeatureSet_IMPLICIT.Enum()
+	case FieldPresenceLegacyRequired:
+		v.FieldPresence = descriptorpb.FeatureSet_LEGACY_REQUIRED.Enum()
+	}
+	switch fs.EnumType {
+	case EnumTypeOpen:
+		v.EnumType = descriptorpb.FeatureSet_OPEN.Enum()
+	case EnumTypeClosed:
+		v.EnumType = descriptorpb.FeatureSet_CLOSED.Enum()
+	}
+	switch fs.RepeatedFieldEncoding {
+	case RepeatedFieldEncodingPacked:
+		v.RepeatedFieldEncoding = descriptorpb.FeatureSet_PACKED.Enum()
+	case RepeatedFieldEncodingExpanded:
+		v.RepeatedFieldEncoding = descriptorpb.FeatureSet_EXPANDED.Enum()
+	}
+	switch fs.UTF8Validation {
+	case UTF8ValidationVerify:
+		v.Utf8Validation = descriptorpb.FeatureSet_VERIFY.Enum()
+	case UTF8ValidationNone:
+		v.Utf8Validation = descriptorpb.FeatureSet_NONE.Enum()
+	}
+	switch fs.MessageEncoding {
+	case MessageEncodingLengthPrefixed:
+		v.MessageEncoding = descriptorpb.FeatureSet_LENGTH_PREFIXED.Enum()
+	case MessageEncodingDelimited:
+		v.MessageEncoding = descriptorpb.FeatureSet_DELIMITED.Enum()
+	}
+	switch fs.JSONFormat {
+	case JSONFormatAllow:
+		v.JsonFormat = descriptorpb.FeatureSet_ALLOW.Enum()
+	case JSONFormatLegacyBestEffort:
+		v.JsonFormat = descriptorpb.FeatureSet_LEGACY_BEST_EFFORT.Enum()
+	}
+	*dst = v
+	return nil
+}
+
+// resolveType resolves a type name used within scope
+func (b *descriptorBuilder) resolveType(scope, name string) (string, symbolKind, error) {
+	full, kind, ok := b.symbols.resolve(scope, name, true)
+	if !ok {
+		return "", 0, fmt.Errorf(`failed to resolve type %q in %q`, name, scope)
+	}
+	if !kind.isType() {
+		return "", 0, fmt.Errorf(`%q in %q resolves to %s %q, which is not a type`, name, scope, kind, full)
+	}
+	return full, kind, nil
+}
+
+// resolveRef resolves a reference to a message or an enum used within scope
+func (b *descriptorBuilder) resolveRef(scope string, typ Type) (string, symbolKind, error) {
+	var name string
+	var want RefKind
+	switch typ := typ.(type) {
+	case TypeRef:
+		name, want = typ.Name, typ.Kind
+	case ExternalType:
+		name, want = "."+typ.String(), typ.Kind
+	case *Message:
+		full, err := b.names.fullName(typ)
+		return full, symbolMessage, err
+	case *Enum:
+		full, err := b.names.fullName(typ)
+		return full, symbolEnum, err
+	case nil:
+		return "", 0, fmt.Errorf(`type must be specified`)
+	default:
+		return "", 0, fmt.Errorf(`%q is not a message or an enum`, typ)
+	}
+	full, kind, err := b.resolveType(scope, name)
+	if err != nil {
+		return "", 0, err
+	}
+	if (want == RefMessage && kind != symbolMessage) || (want == RefEnum && kind != symbolEnum) {
+		return "", 0, fmt.Errorf(`%q in %q resolves to %s %q, expected a %s`, name, scope, kind, full, want)
+	}
+	return full, kind, nil
+}
+
+func (b *descriptorBuilder) fieldType(scope string, typ Type) (descriptorpb.FieldDescriptorProto_Type, string, error) {
+	if typ, ok := typ.(ScalarType); ok {
+		if t, ok := scalarFieldTypes[typ]; ok {
+			return t, "", nil
+		}
+		return 0, "", fmt.Errorf(`unknown scalar type %q`, typ)
+	}
+	if _, ok := typ.(*MapType); ok {
+		return 0, "", fmt.Errorf(`%q may not be used here`, typ)
+	}
+	full, kind, err := b.resolveRef(scope, typ)
+	if err != nil {
+		return 0, "", err
+	}
+	if kind == symbolEnum {
+		return descriptorpb.FieldDescriptorProto_TYPE_ENUM, "." + full, nil
+	}
+	return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, "." + full, nil
+}
+
+// jsonName computes the default JSON name of a field, the same way protoc does
+func jsonName(name string) string {
+	var sb strings.Builder
+	upper := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// mapEntryName computes the name of the map entry message for a map
+// field, the same way protoc does
+func mapEntryName(fieldName string) string {
+	var sb strings.Builder
+	upper := true
+	for i := 0; i < len(fieldName); i++ {
+		c := fieldName[i]
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		sb.WriteByte(c)
+	}
+	sb.WriteString("Entry")
+	return sb.String()
+}
+
+func (b *descriptorBuilder) message(scope string, m *Message) (*descriptorpb.DescriptorProto, error) {
+	name := joinName(scope, m.Name)
+	dp := &descriptorpb.DescriptorProto{
+		Name: proto.String(m.Name),
+	}
+
+	msgOptions := &descriptorpb.MessageOptions{}
+	if err := b.features(m.Features, &msgOptions.Features); err != nil {
+		return nil, fmt.Errorf(`invalid features for message %q: %w`, name, err)
+	}
+	b.deferOptions(name, msgOptions, m.Options)
+	dp.Options = msgOptions
+
+	for _, v := range m.Messages {
+		nested, err := b.message(name, v)
+		if err != nil {
+			return nil, err
+		}
+		dp.NestedType = append(dp.NestedType, nested)
+	}
+	for _, v := range m.Enums {
+		ep, err := b.enum(name, v)
+		if err != nil {
+			return nil, err
+		}
+		dp.EnumType = append(dp.EnumType, ep)
+	}
+	for _, v := range m.Extensions {
+		list, err := b.extension(name, v)
+		if err != nil {
+			return nil, err
+		}
+		dp.Extension = append(dp.Extension, list...)
+	}
+
+	for i, oneof := range m.OneOfs {
+		oneofOptions := &descriptorpb.OneofOptions{}
+		if err := b.features(oneof.Features, &oneofOptions.Features); err != nil {
+			return nil, fmt.Errorf(`invalid features for oneof %q in %q: %w`, oneof.Name, name, err)
+		}
+		dp.OneofDecl = append(dp.OneofDecl, &descriptorpb.OneofDescriptorProto{
+			Name:    proto.String(oneof.Name),
+			Options: oneofOptions,
+		})
+		for _, field := range oneof.Fields {
+			fp, err := b.field(name, field, dp)
+			if err != nil {
+				return nil, err
+			}
+			fp.OneofIndex = proto.Int32(int32(i))
+			dp.Field = append(dp.Field, fp)
+		}
+	}
+
+	var synthetic []*descriptorpb.FieldDescriptorProto
+	for _, field := range m.Fields {
+		fp, err := b.field(name, field, dp)
+		if err != nil {
+			return nil, err
+		}
+		if b.file.Syntax == SyntaxProto3 && field.Cardinality == CardinalityOptional {
+			fp.Proto3Optional = proto.Bool(true)
+			synthetic = append(synthetic, fp)
+		}
+		dp.Field = append(dp.Field, fp)
+	}
+
+	// proto3 optional fields are placed in synthetic oneofs, which must
+	// come after all the real oneofs
+	for _, fp := range synthetic {
+		oneofName := "_" + fp.GetName()
+		for hasMemberNamed(dp, oneofName) {
+			oneofName = "X" + oneofName
+		}
+		fp.OneofIndex = proto.Int32(int32(len(dp.OneofDecl)))
+		dp.OneofDecl = append(dp.OneofDecl, &descriptorpb.OneofDescriptorProto{
+			Name: proto.String(oneofName),
+		})
+	}
+
+	for _, r := range m.ReservedRanges {
+		dp.ReservedRange = append(dp.ReservedRange, &descriptorpb.DescriptorProto_ReservedRange{
+			Start: proto.Int32(int32(r.Start)),
+			// reserved ranges in descriptors are exclusive
+			End: proto.Int32(int32(r.end(MaxFieldNumber) + 1)),
+		})
+	}
+	dp.ReservedName = append(dp.ReservedName, m.ReservedNames...)
+
+	for _, er := range m.ExtensionRanges {
+		list, err := b.extensionRange(name, er)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid extension range in %q: %w`, name, err)
+		}
+		dp.ExtensionRange = append(dp.ExtensionRange, list...)
+	}
+	return dp, nil
+}
+
+func hasMemberNamed(dp *descriptorpb.DescriptorProto, name string) bool {
+	for _, fp := range dp.Field {
+		if fp.GetName() == name {
+			return true
+		}
+	}
+	for _, op := range dp.OneofDecl {
+		if op.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *descriptorBuilder) extensionRange(scope string, er *ExtensionRange) ([]*descriptorpb.DescriptorProto_ExtensionRange, error) {
+	options := &descriptorpb.ExtensionRangeOptions{}
+	for _, d := range er.Declarations {
+		dp := &descriptorpb.ExtensionRangeOptions_Declaration{
+			Number: proto.Int32(int32(d.Number)),
+		}
+		if d.FullName != "" {
+			dp.FullName = proto.String(d.FullName)
+		}
+		if d.Type != "" {
+			dp.Type = proto.String(d.Type)
+		}
+		if d.Reserved {
+			dp.Reserved = proto.Bool(true)
+		}
+		if d.Repeated {
+			dp.Repeated = proto.Bool(true)
+		}
+		options.Declaration = append(options.Declaration, dp)
+	}
+	switch er.Verification {
+	case VerificationDeclaration:
+		options.Verification = descriptorpb.ExtensionRangeOptions_DECLARATION.Enum()
+	case VerificationUnverified:
+		options.Verification = descriptorpb.ExtensionRangeOptions_UNVERIFIED.Enum()
+	}
+	b.deferOptions(scope, options, er.Options)
+
+	var list []*descriptorpb.DescriptorProto_ExtensionRange
+	for _, r := range er.Ranges {
+		list = append(list, &descriptorpb.DescriptorProto_ExtensionRange{
+			Start: proto.Int32(int32(r.Start)),
+			// extension ranges in descriptors are exclusive
+			End:     proto.Int32(int32(r.end(MaxFieldNumber) + 1)),
+			Options: options,
+		})
+	}
+	// every range shares the same options until they have been applied
+	if len(list) > 1 {
+		b.shared = append(b.shared, list)
+	}
+	return list, nil
+}
+
+// field converts a field declared in scope. dp is the message that contains
+// the field, and is used to add map entry messages. It is nil for extensions
+func (b *descriptorBuilder) field(scope string, field *Field, dp *descriptorpb.DescriptorProto) (*descriptorpb.FieldDescriptorProto, error) {
+	fp := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(field.Name),
+		Number:   proto.Int32(int32(field.ID)),
+		JsonName: proto.String(jsonName(field.Name)),
+	}
+
+	switch field.Cardinality {
+	case CardinalityRequired:
+		fp.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+	case CardinalityRepeated:
+		fp.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	default:
+		fp.Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	}
+
+	if mt, ok := field.Type.(*MapType); ok {
+		if dp == nil {
+			return nil, fmt.Errorf(`map field %q is not allowed in extensions`, field.Name)
+		}
+		if err := mt.validate(); err != nil {
+			return nil, fmt.Errorf(`invalid map field %q in %q: %w`, field.Name, scope, err)
+		}
+		entry, err := b.mapEntry(scope, field.Name, mt)
+		if err != nil {
+			return nil, err
+		}
+		dp.NestedType = append(dp.NestedType, entry)
+		fp.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		fp.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		fp.TypeName = proto.String("." + joinName(scope, entry.GetName()))
+	} else {
+		typ, typeName, err := b.fieldType(scope, field.Type)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid field %q in %q: %w`, field.Name, scope, err)
+		}
+		fp.Type = typ.Enum()
+		if typeName != "" {
+			fp.TypeName = proto.String(typeName)
+		}
+	}
+
+	fieldOptions := &descriptorpb.FieldOptions{}
+	if err := b.features(field.Features, &fieldOptions.Features); err != nil {
+		return nil, fmt.Errorf(`invalid features for field %q in %q: %w`, field.Name, scope, err)
+	}
+	var options []*Option
+	for _, option := range field.Options {
+		// json_name and default are not real options, and are stored
+		// in the field descriptor itself
+		switch option.Name {
+		case "json_name":
+			s, ok := option.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf(`invalid json_name for field %q in %q: expected string, got %T`, field.Name, scope, option.Value)
+			}
+			fp.JsonName = proto.String(s)
+		case "default":
+			s, err := b.defaultValue(scope, fp, option.Value)
+			if err != nil {
+				return nil, fmt.Errorf(`invalid default value for field %q in %q: %w`, field.Name, scope, err)
+			}
+			fp.DefaultValue = proto.String(s)
+		default:
+			options = append(options, option)
+		}
+	}
+	b.deferOptions(scope, fieldOptions, options)
+	fp.Options = fieldOptions
+	return fp, nil
+}
+
+func (b *descriptorBuilder) mapEntry(scope, name string, mt *MapType) (*descriptorpb.DescriptorProto, error) {
+	keyType, _, err := b.fieldType(scope, mt.Key)
+	if err != nil {
+		return nil, err
+	}
+	valueType, valueTypeName, err := b.fieldType(scope, mt.Value)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid map field %q in %q: %w`, name, scope, err)
+	}
+
+	value := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("value"),
+		Number:   proto.Int32(2),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     valueType.Enum(),
+		JsonName: proto.String("value"),
+	}
+	if valueTypeName != "" {
+		value.TypeName = proto.String(valueTypeName)
+	}
+	return &descriptorpb.DescriptorProto{
+		Name: proto.String(mapEntryName(name)),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			{
+				Name:     proto.String("key"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     keyType.Enum(),
+				JsonName: proto.String("key"),
+			},
+			value,
+		},
+		Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+	}, nil
+}
+
+// defaultValue formats the value of the `default` pseudo-option the
+// way it is stored in field descriptors
+func (b *descriptorBuilder) defaultValue(scope string, fp *descriptorpb.FieldDescriptorProto, v interface{}) (string, error) {
+	switch fp.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf(`expected string, got %T`, v)
+		}
+		return s, nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			return "", fmt.Errorf(`expected string or []byte, got %T`, v)
+		}
+		return escapeBytes(s), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		id, ok := v.(Identifier)
+		if !ok {
+			return "", fmt.Errorf(`expected enum value name, got %T`, v)
+		}
+		return string(id), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		switch v {
+		case true, Identifier("true"):
+			return "true", nil
+		case false, Identifier("false"):
+			return "false", nil
+		}
+		return "", fmt.Errorf(`expected bool, got %T`, v)
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		f, err := toFloat(v)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case math.IsInf(f, 1):
+			return "inf", nil
+		case math.IsInf(f, -1):
+			return "-inf", nil
+		case math.IsNaN(f):
+			return "nan", nil
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return "", fmt.Errorf(`message fields may not have default values`)
+	default:
+		if n, ok := toInt64(v); ok {
+			return strconv.FormatInt(n, 10), nil
+		}
+		if n, ok := toUint64(v); ok {
+			return strconv.FormatUint(n, 10), nil
+		}
+		return "", fmt.Errorf(`expected integer, got %T`, v)
+	}
+}
+
+// escapeBytes escapes s using C-style escapes, like protoc does for
+// default values of bytes fields
+func escapeBytes(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\'':
+			sb.WriteString(`\'`)
+		case '\\':
+			sb.WriteString(`\\`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&sb, `\%03o`, c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func (b *descriptorBuilder) enum(scope string, e *Enum) (*descriptorpb.EnumDescriptorProto, error) {
+	name := joinName(scope, e.Name)
+	ep := &descriptorpb.EnumDescriptorProto{
+		Name: proto.String(e.Name),
+	}
+	enumOptions := &descriptorpb.EnumOptions{}
+	if err := b.features(e.Features, &enumOptions.Features); err != nil {
+		return nil, fmt.Errorf(`invalid features for enum %q: %w`, name, err)
+	}
+	b.deferOptions(name, enumOptions, e.Options)
+	ep.Options = enumOptions
+
+	for _, v := range e.Elements {
+		valueOptions := &descriptorpb.EnumValueOptions{}
+		if err := b.features(v.Features, &valueOptions.Features); err != nil {
+			return nil, fmt.Errorf(`invalid features for enum value %q in %q: %w`, v.Name, name, err)
+		}
+		b.deferOptions(name, valueOptions, v.Options)
+		ep.Value = append(ep.Value, &descriptorpb.EnumValueDescriptorProto{
+			Name:    proto.String(v.Name),
+			Number:  proto.Int32(int32(v.Value)),
+			Options: valueOptions,
+		})
+	}
+	for _, r := range e.ReservedRanges {
+		ep.ReservedRange = append(ep.ReservedRange, &descriptorpb.EnumDescriptorProto_EnumReservedRange{
+			Start: proto.Int32(int32(r.Start)),
+			// unlike messages, enum reserved ranges are inclusive
+			End: proto.Int32(int32(r.end(MaxEnumValue))),
+		})
+	}
+	ep.ReservedName = append(ep.ReservedName, e.ReservedNames...)
+	return ep, nil
+}
+
+func (b *descriptorBuilder) extension(scope string, ext *Extension) ([]*descriptorpb.FieldDescriptorProto, error) {
+	target := ext.Target
+	if target == nil {
+		target = TypeRef{Name: ext.Name}
+	}
+	extendee, kind, err := b.resolveRef(scope, target)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid extension: %w`, err)
+	}
+	if kind != symbolMessage {
+		return nil, fmt.Errorf(`invalid extension: %q is not a message`, target)
+	}
+
+	var list []*descriptorpb.FieldDescriptorProto
+	for _, field := range ext.Fields {
+		fp, err := b.field(scope, field, nil)
+		if err != nil {
+			return nil, err
+		}
+		fp.Extendee = proto.String("." + extendee)
+		if b.file.Syntax == SyntaxProto3 && field.Cardinality == CardinalityOptional {
+			fp.Proto3Optional = proto.Bool(true)
+		}
+		list = append(list, fp)
+	}
+	return list, nil
+}
+
+func (b *descriptorBuilder) service(scope string, s *Service) (*descriptorpb.ServiceDescriptorProto, error) {
+	name := joinName(scope, s.Name)
+	sp := &descriptorpb.ServiceDescriptorProto{
+		Name: proto.String(s.Name),
+	}
+	serviceOptions := &descriptorpb.ServiceOptions{}
+	b.deferOptions(name, serviceOptions, s.Options)
+	sp.Options = serviceOptions
+
+	for _, m := range s.Methods {
+		input, kind, err := b.resolveRef(scope, m.Input)
+		if err == nil && kind != symbolMessage {
+			err = fmt.Errorf(`%q is not a message`, m.Input)
+		}
+		if err != nil {
+			return nil, fmt.Errorf(`invalid input type for method %q in %q: %w`, m.Name, name, err)
+		}
+		output, kind, err := b.resolveRef(scope, m.Output)
+		if err == nil && kind != symbolMessage {
+			err = fmt.Errorf(`%q is not a message`, m.Output)
+		}
+		if err != nil {
+			return nil, fmt.Errorf(`invalid output type for method %q in %q: %w`, m.Name, name, err)
+		}
+
+		mp := &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(m.Name),
+			InputType:  proto.String("." + input),
+			OutputType: proto.String("." + output),
+		}
+		if m.ClientStreaming {
+			mp.ClientStreaming = proto.Bool(true)
+		}
+		if m.ServerStreaming {
+			mp.ServerStreaming = proto.Bool(true)
+		}
+		methodOptions := &descriptorpb.MethodOptions{}
+		b.deferOptions(name, methodOptions, m.Options)
+		mp.Options = methodOptions
+		sp.Method = append(sp.Method, mp)
+	}
+	return sp, nil
+}
+
+// applyPendingOptions resolves and applies all options that were deferred
+// while building fdp.
+func (b *descriptorBuilder) applyPendingOptions(fdp *descriptorpb.FileDescriptorProto) error {
+	if len(b.pending) == 0 {
+		return nil
+	}
+
+	// Custom options may refer to extensions declared in this very file,
+	// so we need a descriptor for it. Options are removed while building it,
+	// as they are what we are trying to compute.
+	stripped := proto.Clone(fdp).(*descriptorpb.FileDescriptorProto)
+	clearEmptyOptions(stripped)
+	local, err := protodesc.NewFile(stripped, b.resolver)
+	if err != nil {
+		return fmt.Errorf(`failed to create file descriptor: %w`, err)
+	}
+	b.local = local
+
+	for _, p := range b.pending {
+		for _, option := range p.options {
+			if err := b.applyOption(p.scope, p.target.ProtoReflect(), option); err != nil {
+				return fmt.Errorf(`failed to apply option %q in %q: %w`, option.Name, p.scope, err)
+			}
+		}
+	}
+	return nil
+}
+
+// clearEmptyOptions removes option messages that do not contain anything
+func clearEmptyOptions(fdp *descriptorpb.FileDescriptorProto) {
+	isEmpty := func(m proto.Message) bool {
+		return proto.Size(m) == 0
+	}
+	if isEmpty(fdp.Options) {
+		fdp.Options = nil
+	}
+	var clearMessage func(*descriptorpb.DescriptorProto)
+	clearFields := func(list []*descriptorpb.FieldDescriptorProto) {
+		for _, fp := range list {
+			if isEmpty(fp.Options) {
+				fp.Options = nil
+			}
+		}
+	}
+	clearEnums := func(list []*descriptorpb.EnumDescriptorProto) {
+		for _, ep := range list {
+			if isEmpty(ep.Options) {
+				ep.Options = nil
+			}
+			for _, vp := range ep.Value {
+				if isEmpty(vp.Options) {
+					vp.Options = nil
+				}
+			}
+		}
+	}
+	clearMessage = func(dp *descriptorpb.DescriptorProto) {
+		if isEmpty(dp.Options) {
+			dp.Options = nil
+		}
+		clearFields(dp.Field)
+		clearFields(dp.Extension)
+		clearEnums(dp.EnumType)
+		for _, op := range dp.OneofDecl {
+			if isEmpty(op.Options) {
+				op.Options = nil
+			}
+		}
+		for _, erp := range dp.ExtensionRange {
+			if isEmpty(erp.Options) {
+				erp.Options = nil
+			}
+		}
+		for _, nested := range dp.NestedType {
+			clearMessage(nested)
+		}
+	}
+	for _, dp := range fdp.MessageType {
+		clearMessage(dp)
+	}
+	clearFields(fdp.Extension)
+	clearEnums(fdp.EnumType)
+	for _, sp := range fdp.Service {
+		if isEmpty(sp.Options) {
+			sp.Options = nil
+		}
+		for _, mp := range sp.Method {
+			if isEmpty(mp.Options) {
+				mp.Options = nil
+			}
+		}
+	}
+}
+
+// optionNamePart is a component of an option name such as `(foo.bar).baz`
+type optionNamePart struct {
+	Name      string
+	Extension bool
+}
+
+func splitOptionName(name string) ([]optionNamePart, error) {
+	var parts []optionNamePart
+	for name != "" {
+		if strings.HasPrefix(name, "(") {
+			end := strings.IndexByte(name, ')')
+			if end < 0 {
+				return nil, fmt.Errorf(`unterminated extension name in option name`)
+			}
+			parts = append(parts, optionNamePart{Name: name[1:end], Extension: true})
+			name = name[end+1:]
+		} else {
+			end := strings.IndexByte(name, '.')
+			if end < 0 {
+				end = len(name)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf(`empty component in option name`)
+			}
+			parts = append(parts, optionNamePart{Name: name[:end]})
+			name = name[end:]
+		}
+		if name != "" {
+			if name[0] != '.' {
+				return nil, fmt.Errorf(`unexpected character %q in option name`, name[0])
+			}
+			name = name[1:]
+		}
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf(`empty option name`)
+	}
+	return parts, nil
+}
+
+// findDescriptor looks up a declaration by its fully-qualified name,
+// first in the file being built, then in the resolver
+func (b *descriptorBuilder) findDescriptor(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d := findInFile(b.local, name); d != nil {
+		return d, nil
+	}
+	return b.resolver.FindDescriptorByName(name)
+}
+
+func findInFile(fd protoreflect.FileDescriptor, name protoreflect.FullName) protoreflect.Descriptor {
+	if fd == nil {
+		return nil
+	}
+	var findIn func(messages protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors, exts protoreflect.ExtensionDescriptors) protoreflect.Descriptor
+	findIn = func(messages protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors, exts protoreflect.ExtensionDescriptors) protoreflect.Descriptor {
+		for i := 0; i < exts.Len(); i++ {
+			if d := exts.Get(i); d.FullName() == name {
+				return d
+			}
+		}
+		for i := 0; i < enums.Len(); i++ {
+			if d := enums.Get(i); d.FullName() == name {
+				return d
+			}
+		}
+		for i := 0; i < messages.Len(); i++ {
+			md := messages.Get(i)
+			if md.FullName() == name {
+				return md
+			}
+			if d := findIn(md.Messages(), md.Enums(), md.Extensions()); d != nil {
+				return d
+			}
+		}
+		return nil
+	}
+	return findIn(fd.Messages(), fd.Enums(), fd.Extensions())
+}
+
+func (b *descriptorBuilder) findExtension(scope, name string, containing protoreflect.MessageDescriptor) (protoreflect.FieldDescriptor, error) {
+	full, kind, ok := b.symbols.resolve(scope, name, false)
+	if !ok {
+		return nil, fmt.Errorf(`failed to resolve extension %q`, name)
+	}
+	if kind != symbolExtension {
+		return nil, fmt.Errorf(`%q resolves to %s %q, which is not an extension`, name, kind, full)
+	}
+	d, err := b.findDescriptor(protoreflect.FullName(full))
+	if err != nil {
+		return nil, fmt.Errorf(`failed to find extension %q: %w`, full, err)
+	}
+	xd, ok := d.(protoreflect.ExtensionDescriptor)
+	if !ok {
+		return nil, fmt.Errorf(`%q is not an extension`, full)
+	}
+	if xd.ContainingMessage().FullName() != containing.FullName() {
+		return nil, fmt.Errorf(`extension %q extends %q, not %q`, full, xd.ContainingMessage().FullName(), containing.FullName())
+	}
+	return dynamicpb.NewExtensionType(xd).TypeDescriptor(), nil
+}
+
+func (b *descriptorBuilder) applyOption(scope string, msg protoreflect.Message, option *Option) error {
+	parts, err := splitOptionName(option.Name)
+	if err != nil {
+		return err
+	}
+
+	for i, part := range parts {
+		var fd protoreflect.FieldDescriptor
+		if part.Extension {
+			fd, err = b.findExtension(scope, part.Name, msg.Descriptor())
+			if err != nil {
+				return err
+			}
+		} else {
+			fd = msg.Descriptor().Fields().ByName(protoreflect.Name(part.Name))
+			if fd == nil {
+				return fmt.Errorf(`unknown option %q for %s`, part.Name, msg.Descriptor().FullName())
+			}
+		}
+
+		if i == len(parts)-1 {
+			return b.setField(scope, msg, fd, option.Value)
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf(`option %q is not a singular message`, part.Name)
+		}
+		msg = msg.Mutable(fd).Message()
+	}
+	return nil
+}
+
+// listValues returns the elements of v if it is a list. As in writeValue,
+// slices and arrays are lists, except for byte slices which are a
+// single bytes value
+func listValues(v interface{}) ([]interface{}, bool) {
+	if values, ok := v.([]interface{}); ok {
+		return values, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	if rv.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	values := make([]interface{}, rv.Len())
+	for i := range values {
+		values[i] = rv.Index(i).Interface()
+	}
+	return values, true
+}
+
+// setField sets the value of fd in msg. Values for repeated fields are
+// appended to the existing values
+func (b *descriptorBuilder) setField(scope string, msg protoreflect.Message, fd protoreflect.FieldDescriptor, v interface{}) error {
+	values, isList := listValues(v)
+	if !isList {
+		values = []interface{}{v}
+	}
+
+	switch {
+	case fd.IsMap():
+		m := msg.Mutable(fd).Map()
+		for _, elem := range values {
+			ml, ok := elem.(*MessageLiteral)
+			if !ok {
+				return fmt.Errorf(`expected message literal for map entry of %q, got %T`, fd.Name(), elem)
+			}
+			var key protoreflect.MapKey
+			var value protoreflect.Value
+			value = m.NewValue()
+			for _, field := range ml.Fields {
+				switch field.Name {
+				case "key":
+					kv, err := b.scalarValue(fd.MapKey(), field.Value)
+					if err != nil {
+						return err
+					}
+					key = kv.MapKey()
+				case "value":
+					if fd.MapValue().Message() != nil {
+						vml, ok := field.Value.(*MessageLiteral)
+						if !ok {
+							return fmt.Errorf(`expected message literal for map value of %q, got %T`, fd.Name(), field.Value)
+						}
+						if err := b.fillMessage(scope, value.Message(), vml); err != nil {
+							return err
+						}
+					} else {
+						sv, err := b.scalarValue(fd.MapValue(), field.Value)
+						if err != nil {
+							return err
+						}
+						value = sv
+					}
+				default:
+					return fmt.Errorf(`unknown field %q in map entry of %q`, field.Name, fd.Name())
+				}
+			}
+			if !key.IsValid() {
+				key = fd.MapKey().Default().MapKey()
+			}
+			m.Set(key, value)
+		}
+		return nil
+	case fd.IsList():
+		list := msg.Mutable(fd).List()
+		for _, elem := range values {
+			if fd.Message() != nil {
+				ml, ok := elem.(*MessageLiteral)
+				if !ok {
+					return fmt.Errorf(`expected message literal for %q, got %T`, fd.Name(), elem)
+				}
+				nv := list.NewElement()
+				if err := b.fillMessage(scope, nv.Message(), ml); err != nil {
+					return err
+				}
+				list.Append(nv)
+				continue
+			}
+			sv, err := b.scalarValue(fd, elem)
+			if err != nil {
+				return err
+			}
+			list.Append(sv)
+		}
+		return nil
+	}
+
+	if isList {
+		return fmt.Errorf(`list value given for non-repeated field %q`, fd.Name())
+	}
+	if fd.Message() != nil {
+		ml, ok := v.(*MessageLiteral)
+		if !ok {
+			return fmt.Errorf(`expected message literal for %q, got %T`, fd.Name(), v)
+		}
+		return b.fillMessage(scope, msg.Mutable(fd).Message(), ml)
+	}
+	sv, err := b.scalarValue(fd, v)
+	if err != nil {
+		return err
+	}
+	msg.Set(fd, sv)
+	return nil
+}
+
+func (b *descriptorBuilder) fillMessage(scope string, msg protoreflect.Message, ml *MessageLiteral) error {
+	md := msg.Descriptor()
+	for _, field := range ml.Fields {
+		name := field.Name
+		if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+			name = name[1 : len(name)-1]
+			if i := strings.LastIndexByte(name, '/'); i >= 0 {
+				if err := b.fillAny(scope, msg, name, name[i+1:], field.Value); err != nil {
+					return err
+				}
+				continue
+			}
+
+			fd, err := b.findExtension(scope, "."+strings.TrimPrefix(name, "."), md)
+			if err != nil {
+				return err
+			}
+			if err := b.setField(scope, msg, fd, field.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fd := md.Fields().ByTextName(name)
+		if fd == nil {
+			return fmt.Errorf(`unknown field %q in %s`, name, md.FullName())
+		}
+		if err := b.setField(scope, msg, fd, field.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillAny handles the expanded form of google.protobuf.Any values, such
+// as `[type.googleapis.com/foo.Bar]: { ... }`
+func (b *descriptorBuilder) fillAny(scope string, msg protoreflect.Message, typeURL, typeName string, v interface{}) error {
+	md := msg.Descriptor()
+	if md.FullName() != "google.protobuf.Any" {
+		return fmt.Errorf(`type URL %q can only be used in google.protobuf.Any, not %s`, typeURL, md.FullName())
+	}
+	ml, ok := v.(*MessageLiteral)
+	if !ok {
+		return fmt.Errorf(`expected message literal for %q, got %T`, typeURL, v)
+	}
+	d, err := b.findDescriptor(protoreflect.FullName(typeName))
+	if err != nil {
+		return fmt.Errorf(`failed to find message %q: %w`, typeName, err)
+	}
+	inner, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return fmt.Errorf(`%q is not a message`, typeName)
+	}
+
+	value := dynamicpb.NewMessage(inner)
+	if err := b.fillMessage(scope, value, ml); err != nil {
+		return err
+	}
+	buf, err := proto.MarshalOptions{Deterministic: true}.Marshal(value)
+	if err != nil {
+		return fmt.Errorf(`failed to marshal %q: %w`, typeName, err)
+	}
+	msg.Set(md.Fields().ByName("type_url"), protoreflect.ValueOfString(typeURL))
+	msg.Set(md.Fields().ByName("value"), protoreflect.ValueOfBytes(buf))
+	return nil
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), v <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case Hex:
+		return int64(v), v <= math.MaxInt64
+	case Octal:
+		return int64(v), v <= math.MaxInt64
+	}
+	return 0, false
+}
+
+func toUint64(v interface{}) (uint64, bool) {
+	switch v := v.(type) {
+	case uint:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case Hex:
+		return uint64(v), true
+	case Octal:
+		return uint64(v), true
+	}
+	if n, ok := toInt64(v); ok && n >= 0 {
+		return uint64(n), true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case Identifier:
+		switch strings.ToLower(string(v)) {
+		case "inf", "infinity", "+inf", "+infinity":
+			return math.Inf(1), nil
+		case "-inf", "-infinity":
+			return math.Inf(-1), nil
+		case "nan", "-nan", "+nan":
+			return math.NaN(), nil
+		}
+	}
+	if n, ok := toInt64(v); ok {
+		return float64(n), nil
+	}
+	if n, ok := toUint64(v); ok {
+		return float64(n), nil
+	}
+	return 0, fmt.Errorf(`expected number, got %T`, v)
+}
+
+func (b *descriptorBuilder) scalarValue(fd protoreflect.FieldDescriptor, v interface{}) (protoreflect.Value, error) {
+	outOfRange := func() (protoreflect.Value, error) {
+		return protoreflect.Value{}, fmt.Errorf(`value %v is out of range for %s field %q`, v, fd.Kind(), fd.Name())
+	}
+
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		switch v {
+		case true, Identifier("true"):
+			return protoreflect.ValueOfBool(true), nil
+		case false, Identifier("false"):
+			return protoreflect.ValueOfBool(false), nil
+		}
+	case protoreflect.EnumKind:
+		if e, ok := v.(protoreflect.Enum); ok {
+			return protoreflect.ValueOfEnum(e.Number()), nil
+		}
+		if id, ok := v.(Identifier); ok {
+			ev := fd.Enum().Values().ByName(protoreflect.Name(id))
+			if ev == nil {
+				return protoreflect.Value{}, fmt.Errorf(`unknown value %q for enum %s`, id, fd.Enum().FullName())
+			}
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		if n, ok := toInt64(v); ok {
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return outOfRange()
+			}
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if n, ok := toInt64(v); ok {
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return outOfRange()
+			}
+			return protoreflect.ValueOfInt32(int32(n)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if n, ok := toInt64(v); ok {
+			return protoreflect.ValueOfInt64(n), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if n, ok := toUint64(v); ok {
+			if n > math.MaxUint32 {
+				return outOfRange()
+			}
+			return protoreflect.ValueOfUint32(uint32(n)), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if n, ok := toUint64(v); ok {
+			return protoreflect.ValueOfUint64(n), nil
+		}
+	case protoreflect.FloatKind:
+		if f, err := toFloat(v); err == nil {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+	case protoreflect.DoubleKind:
+		if f, err := toFloat(v); err == nil {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+	case protoreflect.StringKind:
+		if s, ok := v.(string); ok {
+			return protoreflect.ValueOfString(s), nil
+		}
+	case protoreflect.BytesKind:
+		switch v := v.(type) {
+		case string:
+			return protoreflect.ValueOfBytes([]byte(v)), nil
+		case []byte:
+			return protoreflect.ValueOfBytes(v), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf(`invalid value of type %T for %s field %q`, v, fd.Kind(), fd.Name())
+}
@@ -0,0 +1,330 @@
+package protowrite_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/lestrrat-go/protowrite"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestToDescriptor(t *testing.T) {
+	var b protowrite.Builder
+
+	t.Run("Sanity", func(t *testing.T) {
+		src, err := os.ReadFile(`testdata/sanity.golden`)
+		require.NoError(t, err, `os.ReadFile should succeed`)
+		file, err := protowrite.Unmarshal(src)
+		require.NoError(t, err, `protowrite.Unmarshal should succeed`)
+
+		fd, err := protowrite.ToDescriptor(file, &protowrite.DescriptorOptions{Path: `foo/bar/sanity.proto`})
+		require.NoError(t, err, `protowrite.ToDescriptor should succeed`)
+		require.Equal(t, `foo/bar/sanity.proto`, fd.Path())
+		require.Equal(t, protoreflect.FullName(`foo.bar`), fd.Package())
+
+		msg := fd.Messages().ByName(`Message`)
+		require.NotNil(t, msg, `Message should exist`)
+		require.Equal(t, 1, msg.Oneofs().Len())
+		require.Equal(t, `id`, string(msg.Fields().ByName(`num`).ContainingOneof().Name()))
+		require.Equal(t, protoreflect.FullName(`foo.bar.Message.NestedMessage`), msg.Fields().ByName(`extra`).Message().FullName())
+
+		nested := msg.Messages().ByName(`NestedMessage`)
+		require.Equal(t, protoreflect.FullName(`foo.bar.Message.NestedMessage.Kind`), nested.Fields().ByName(`kind`).Enum().FullName())
+
+		// the custom option must be resolved to the extension declared in the file
+		ext := nested.Extensions().ByName(`fizz`)
+		require.NotNil(t, ext, `fizz should exist`)
+		var found bool
+		nested.Options().ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			if fd.FullName() == ext.FullName() {
+				found = true
+				require.Equal(t, `buzz`, v.String())
+			}
+			return true
+		})
+		require.True(t, found, `(NestedMessage.fizz) should be set`)
+
+		method := fd.Services().ByName(`FooService`).Methods().ByName(`Bar`)
+		require.Equal(t, protoreflect.FullName(`foo.bar.Message`), method.Input().FullName())
+	})
+	t.Run("Proto3 optional and maps", func(t *testing.T) {
+		file, err := b.File().
+			Package(`foo.bar`).
+			Messages(
+				b.Message("Message").
+					Fields(
+						b.Field("string", "name", 1).
+							Cardinality(protowrite.CardinalityOptional).
+							MustBuild(),
+					).
+					MustBuild(),
+				b.Message("Other").
+					MapField("string", "Message", "by_name", 1).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder should succeed`)
+
+		fdp, err := protowrite.ToDescriptorProto(file, nil)
+		require.NoError(t, err, `protowrite.ToDescriptorProto should succeed`)
+		require.Equal(t, `foo/bar.proto`, fdp.GetName())
+
+		msg := fdp.GetMessageType()[0]
+		require.True(t, msg.GetField()[0].GetProto3Optional())
+		require.Equal(t, int32(0), msg.GetField()[0].GetOneofIndex())
+		require.Equal(t, `_name`, msg.GetOneofDecl()[0].GetName())
+
+		other := fdp.GetMessageType()[1]
+		require.Equal(t, descriptorpb.FieldDescriptorProto_LABEL_REPEATED, other.GetField()[0].GetLabel())
+		require.Equal(t, `.foo.bar.Other.ByNameEntry`, other.GetField()[0].GetTypeName())
+		entry := other.GetNestedType()[0]
+		require.Equal(t, `ByNameEntry`, entry.GetName())
+		require.True(t, entry.GetOptions().GetMapEntry())
+		require.Equal(t, `.foo.bar.Message`, entry.GetField()[1].GetTypeName())
+	})
+	t.Run("Options", func(t *testing.T) {
+		file, err := b.File().
+			Syntax(protowrite.SyntaxProto2).
+			Package(`foo.bar`).
+			Option("java_package", "com.example.foo").
+			Option("optimize_for", protowrite.Identifier("CODE_SIZE")).
+			Enums(
+				b.Enum("Status").
+					Element("STATUS_UNKNOWN", 0).
+					EnumElements(b.EnumElement("STATUS_OLD", 1).Deprecated(true).MustBuild()).
+					MustBuild(),
+			).
+			Messages(
+				b.Message("Message").
+					Option("deprecated", true).
+					Fields(
+						b.Field("int32", "count", 1).
+							Option("default", -5).
+							Option("json_name", "cnt").
+							Option("deprecated", true).
+							MustBuild(),
+						b.Field("string", "label", 2).
+							Retention(protowrite.RetentionSource).
+							Targets(protowrite.TargetTypeField, protowrite.TargetTypeMethod).
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder should succeed`)
+
+		fdp, err := protowrite.ToDescriptorProto(file, nil)
+		require.NoError(t, err, `protowrite.ToDescriptorProto should succeed`)
+		require.True(t, proto.Equal(&descriptorpb.FileOptions{
+			JavaPackage: proto.String("com.example.foo"),
+			OptimizeFor: descriptorpb.FileOptions_CODE_SIZE.Enum(),
+		}, fdp.GetOptions()), `file options should match`)
+
+		msg := fdp.GetMessageType()[0]
+		require.True(t, msg.GetOptions().GetDeprecated())
+		field := msg.GetField()[0]
+		require.Equal(t, `-5`, field.GetDefaultValue())
+		require.Equal(t, `cnt`, field.GetJsonName())
+		require.True(t, field.GetOptions().GetDeprecated())
+
+		label := msg.GetField()[1]
+		require.Equal(t, descriptorpb.FieldOptions_RETENTION_SOURCE, label.GetOptions().GetRetention())
+		require.Equal(t, []descriptorpb.FieldOptions_OptionTargetType{
+			descriptorpb.FieldOptions_TARGET_TYPE_FIELD,
+			descriptorpb.FieldOptions_TARGET_TYPE_METHOD,
+		}, label.GetOptions().GetTargets())
+		require.True(t, fdp.GetEnumType()[0].GetValue()[1].GetOptions().GetDeprecated(), `enum value should be deprecated`)
+	})
+	t.Run("Typed list options", func(t *testing.T) {
+		rules := b.Message("Rules").
+			Fields(b.Field("string", "tags", 1).Cardinality(protowrite.CardinalityRepeated).MustBuild()).
+			Fields(b.Field("int32", "sizes", 2).Cardinality(protowrite.CardinalityRepeated).MustBuild()).
+			MustBuild()
+		file, err := b.File().
+			Package(`foo.bar`).
+			Import("google/protobuf/descriptor.proto", protowrite.ImportDefault).
+			Extensions(b.Extension("google.protobuf.MessageOptions").TypedField(rules, "rules", 50000).MustBuild()).
+			Messages(
+				rules,
+				b.Message("Message").
+					Option("(rules)", b.MessageLiteral().
+						Field("tags", []string{"a", "b"}).
+						Field("sizes", [2]int32{1, 2}).
+						MustBuild()).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder should succeed`)
+
+		buf, err := protowrite.Marshal(file)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Contains(t, string(buf), `tags: ["a", "b"]
+        sizes: [1, 2]`)
+
+		fd, err := protowrite.ToDescriptor(file, nil)
+		require.NoError(t, err, `protowrite.ToDescriptor should succeed`)
+		ext := fd.Extensions().ByName(`rules`)
+		var value protoreflect.Message
+		fd.Messages().ByName(`Message`).Options().ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			if fd.FullName() == ext.FullName() {
+				value = v.Message()
+			}
+			return true
+		})
+		require.NotNil(t, value, `(rules) should be set`)
+		tags := value.Get(value.Descriptor().Fields().ByName(`tags`)).List()
+		require.Equal(t, 2, tags.Len())
+		require.Equal(t, `b`, tags.Get(1).String())
+		sizes := value.Get(value.Descriptor().Fields().ByName(`sizes`)).List()
+		require.Equal(t, int64(2), sizes.Get(1).Int())
+	})
+	t.Run("Errors", func(t *testing.T) {
+		testcases := []struct {
+			Name  string
+			Build func() (*protowrite.File, error)
+		}{
+			{
+				Name: "unresolved field type",
+				Build: func() (*protowrite.File, error) {
+					return b.File().
+						Package(`foo.bar`).
+						Messages(b.Message("Message").Field("Missing", "missing", 1).MustBuild()).
+						Build()
+				},
+			},
+			{
+				Name: "unresolved method input",
+				Build: func() (*protowrite.File, error) {
+					return b.File().
+						Package(`foo.bar`).
+						Services(b.Service("Svc").Method("Call", "Request", "Request").MustBuild()).
+						Build()
+				},
+			},
+			{
+				Name: "unknown option",
+				Build: func() (*protowrite.File, error) {
+					return b.File().
+						Package(`foo.bar`).
+						Option("no_such_option", true).
+						Build()
+				},
+			},
+			{
+				Name: "option with wrong type",
+				Build: func() (*protowrite.File, error) {
+					return b.File().
+						Package(`foo.bar`).
+						Option("java_package", 1).
+						Build()
+				},
+			},
+		}
+
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				file, err := tc.Build()
+				require.NoError(t, err, `builder should succeed`)
+				_, err = protowrite.ToDescriptorProto(file, nil)
+				require.Error(t, err, `protowrite.ToDescriptorProto should fail`)
+			})
+		}
+	})
+}
+
+func TestFromDescriptor(t *testing.T) {
+	t.Run("Round trip", func(t *testing.T) {
+		paths := []string{
+			`google/protobuf/descriptor.proto`,
+			`google/protobuf/struct.proto`,
+			`google/protobuf/compiler/plugin.proto`,
+		}
+		for _, path := range paths {
+			path := path
+			t.Run(path, func(t *testing.T) {
+				fd, err := protoregistry.GlobalFiles.FindFileByPath(path)
+				require.NoError(t, err, `protoregistry.GlobalFiles.FindFileByPath should succeed`)
+
+				file, err := protowrite.FromDescriptor(fd)
+				require.NoError(t, err, `protowrite.FromDescriptor should succeed`)
+
+				buf, err := protowrite.Marshal(file)
+				require.NoError(t, err, `protowrite.Marshal should succeed`)
+
+				parsed, err := protowrite.Unmarshal(buf)
+				require.NoError(t, err, `protowrite.Unmarshal should succeed`)
+
+				fdp, err := protowrite.ToDescriptorProto(parsed, &protowrite.DescriptorOptions{Path: path})
+				require.NoError(t, err, `protowrite.ToDescriptorProto should succeed`)
+				require.True(t, proto.Equal(protodesc.ToFileDescriptorProto(fd), fdp), `descriptors should match`)
+			})
+		}
+	})
+	t.Run("FileDescriptorProto", func(t *testing.T) {
+		const src = `
+name: "foo/bar.proto"
+package: "foo.bar"
+syntax: "proto3"
+message_type: {
+  name: "Message"
+  field: { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING oneof_index: 0 proto3_optional: true json_name: "name" }
+  field: { name: "labels" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".foo.bar.Message.LabelsEntry" json_name: "labels" }
+  field: { name: "kind" number: 3 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".foo.bar.Kind" json_name: "kind" }
+  nested_type: {
+    name: "LabelsEntry"
+    field: { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING json_name: "key" }
+    field: { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".foo.bar.Message" json_name: "value" }
+    options: { map_entry: true }
+  }
+  oneof_decl: { name: "_name" }
+}
+enum_type: {
+  name: "Kind"
+  value: { name: "KIND_UNKNOWN" number: 0 }
+}
+source_code_info: {
+  location: { path: [12] span: [0, 0, 0] leading_comments: " Generated file.\n" }
+  location: { path: [4, 0] span: [0, 0, 0] leading_detached_comments: " Messages\n" leading_comments: " Message is a message.\n It has fields.\n" }
+  location: { path: [4, 0, 2, 2] span: [0, 0, 0] leading_comments: " kind of message\n" }
+  location: { path: [4, 0, 2, 0] span: [0, 0, 0] trailing_comments: " the name\n" }
+  location: { path: [5, 0, 2, 0] span: [0, 0, 0] trailing_comments: " unknown kind\n" }
+}
+`
+		var fdp descriptorpb.FileDescriptorProto
+		require.NoError(t, prototext.Unmarshal([]byte(src), &fdp), `prototext.Unmarshal should succeed`)
+
+		file, err := protowrite.FromDescriptorProto(&fdp, nil)
+		require.NoError(t, err, `protowrite.FromDescriptorProto should succeed`)
+
+		buf, err := protowrite.Marshal(file)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Equal(t, `// Generated file.
+syntax = "proto3";
+
+package foo.bar;
+
+// Messages
+
+// Message is a message.
+// It has fields.
+message Message {
+    optional string name = 1; // the name
+    map<string, Message> labels = 2;
+    // kind of message
+    Kind kind = 3;
+}
+
+enum Kind {
+    KIND_UNKNOWN = 0; // unknown kind
+}`, string(buf))
+	})
+}
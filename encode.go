@@ -0,0 +1,185 @@
+package protowrite
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type encoder interface {
+	encode(*encodeState) error
+}
+
+// encodeState holds the state needed while writing declarations.
+// Declarations that change the state for their bodies, such as messages
+// changing the scope used to write type names, restore it before returning
+type encodeState struct {
+	*bufio.Writer
+	// dst records the first error returned by the destination
+	dst     *errWriter
+	options *marshalOptions
+
+	// depth is the current level of indentation. indents caches the
+	// indentation string for each level that has been used so far
+	depth   int
+	indents []string
+	// scratch is reused to format numbers and string literals
+	scratch []byte
+
+	syntax  Syntax
+	inOneOf bool
+	// scope is the fully-qualified name of the package or message
+	// whose body is being written
+	scope string
+	// path lists the names of the declarations being written, starting
+	// with the package. It is only joined when an error is reported
+	path      []string
+	features  *FeatureSet
+	typeNames *typeNames
+}
+
+func newEncodeState(dst io.Writer, mo *marshalOptions) *encodeState {
+	w := &errWriter{w: dst}
+	if mo.lineEnding == LineEndingCRLF {
+		w.w = &crlfWriter{w: dst}
+	}
+	return &encodeState{
+		Writer:    bufio.NewWriter(w),
+		dst:       w,
+		options:   mo,
+		indents:   []string{""},
+		syntax:    SyntaxProto3,
+		typeNames: &typeNames{symbols: make(symbolTable), nodes: make(map[Type]string)},
+	}
+}
+
+// indent returns the indentation for the current depth
+func (e *encodeState) indent() string {
+	for len(e.indents) <= e.depth {
+		e.indents = append(e.indents, e.indents[len(e.indents)-1]+e.options.indent)
+	}
+	return e.indents[e.depth]
+}
+
+func (e *encodeState) moreIndent() {
+	e.depth++
+}
+
+func (e *encodeState) lessIndent() {
+	e.depth--
+}
+
+// newline starts a new line at the current indentation
+func (e *encodeState) newline() {
+	e.WriteByte('\n')
+	e.WriteString(e.indent())
+}
+
+// separate writes the blank lines that separate top-level declarations
+func (e *encodeState) separate() {
+	for i := 0; i < e.options.blankLines; i++ {
+		e.WriteByte('\n')
+	}
+}
+
+func (e *encodeState) writeInt(n int) {
+	e.scratch = strconv.AppendInt(e.scratch[:0], int64(n), 10)
+	e.Write(e.scratch)
+}
+
+// writeQuoted writes s as a string literal, using the configured quote style
+func (e *encodeState) writeQuoted(s string) {
+	e.scratch = appendQuoted(e.scratch[:0], s, e.options.quote.char())
+	e.Write(e.scratch)
+}
+
+// pushPath records that the declaration name is being written. It must
+// be paired with a call to popPath
+func (e *encodeState) pushPath(name string) {
+	e.path = append(e.path, name)
+}
+
+func (e *encodeState) popPath() {
+	e.path = e.path[:len(e.path)-1]
+}
+
+// withScope makes the body of the message name the current scope, and
+// returns the previous scope
+func (e *encodeState) withScope(name string) string {
+	prev := e.scope
+	e.scope = joinName(prev, name)
+	return prev
+}
+
+// typeName returns the name that should be written for typ
+func (e *encodeState) typeName(typ Type) (string, error) {
+	return e.typeNames.name(e.scope, typ)
+}
+
+// check returns a *WriteError if writing to the destination has failed
+func (e *encodeState) check() error {
+	if err := e.dst.err; err != nil {
+		var path []string
+		for _, name := range e.path {
+			if name != "" {
+				path = append(path, name)
+			}
+		}
+		return &WriteError{Path: strings.Join(path, "."), Err: err}
+	}
+	return nil
+}
+
+// flush writes any buffered output to the destination
+func (e *encodeState) flush() error {
+	e.Flush()
+	return e.check()
+}
+
+// errWriter remembers the first error returned by the underlying writer,
+// and discards everything written after it
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// crlfWriter converts each "\n" written to it into "\r\n". String
+// literals never contain raw newlines, so every newline in the output
+// is a line ending
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (w *crlfWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			if _, err := w.w.Write(p); err != nil {
+				return 0, err
+			}
+			break
+		}
+		if _, err := w.w.Write(p[:i]); err != nil {
+			return 0, err
+		}
+		if _, err := io.WriteString(w.w, "\r\n"); err != nil {
+			return 0, err
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
@@ -0,0 +1,210 @@
+package protowrite
+
+import (
+	"fmt"
+)
+
+// VerificationState corresponds to the `verification` option on
+// extension ranges
+type VerificationState int
+
+const (
+	VerificationDefault VerificationState = iota
+	VerificationDeclaration
+	VerificationUnverified
+)
+
+func (v VerificationState) String() string {
+	switch v {
+	case VerificationDeclaration:
+		return "DECLARATION"
+	case VerificationUnverified:
+		return "UNVERIFIED"
+	default:
+		return "VERIFICATION_DEFAULT"
+	}
+}
+
+// ExtensionDeclaration corresponds to the `declaration` option on
+// extension ranges
+type ExtensionDeclaration struct {
+	Number int
+	// FullName is the fully-qualified name of the extension, including
+	// the leading dot
+	FullName string
+	// Type is the type of the extension. Message and enum types must
+	// be fully-qualified, including the leading dot
+	Type     string
+	Reserved bool
+	Repeated bool
+}
+
+func (d *ExtensionDeclaration) literal() *MessageLiteral {
+	ml := &MessageLiteral{SingleLine: true}
+	ml.Fields = append(ml.Fields, &MessageLiteralField{Name: "number", Value: d.Number})
+	if d.FullName != "" {
+		ml.Fields = append(ml.Fields, &MessageLiteralField{Name: "full_name", Value: d.FullName})
+	}
+	if d.Type != "" {
+		ml.Fields = append(ml.Fields, &MessageLiteralField{Name: "type", Value: d.Type})
+	}
+	if d.Reserved {
+		ml.Fields = append(ml.Fields, &MessageLiteralField{Name: "reserved", Value: d.Reserved})
+	}
+	if d.Repeated {
+		ml.Fields = append(ml.Fields, &MessageLiteralField{Name: "repeated", Value: d.Repeated})
+	}
+	return ml
+}
+
+// ExtensionRange represents an `extensions` statement in a message,
+// which declares one or more ranges of field numbers that are available
+// for extensions.
+type ExtensionRange struct {
+	Ranges       []*Range
+	Declarations []*ExtensionDeclaration
+	Verification VerificationState
+	// Options lists any other options for this extension range. These
+	// are always written in compact form
+	Options  []*Option
+	Comments Comments
+}
+
+func (er *ExtensionRange) contains(n int) bool {
+	for _, r := range er.Ranges {
+		if r.contains(n, MaxFieldNumber) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNumber returns an error if n cannot be used for an extension
+// field within this range
+func (er *ExtensionRange) checkNumber(n int) error {
+	if len(er.Declarations) == 0 || er.Verification == VerificationUnverified {
+		return nil
+	}
+	for _, d := range er.Declarations {
+		if d.Number == n {
+			if d.Reserved {
+				return fmt.Errorf(`extension number %d is reserved by its declaration`, n)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf(`extension number %d is not declared in extension range %s`, n, formatRanges(er.Ranges))
+}
+
+func (er *ExtensionRange) encode(e *encodeState) error {
+	if len(er.Ranges) == 0 {
+		return fmt.Errorf(`extension range must contain at least one range`)
+	}
+	for i, r := range er.Ranges {
+		if err := r.validate(1, MaxFieldNumber); err != nil {
+			return fmt.Errorf(`invalid extension range %d: %w`, i, err)
+		}
+	}
+	for _, d := range er.Declarations {
+		if !er.contains(d.Number) {
+			return fmt.Errorf(`declared extension number %d is outside of extension range %s`, d.Number, formatRanges(er.Ranges))
+		}
+	}
+
+	er.Comments.encodeLeading(e)
+	e.newline()
+	e.WriteString("extensions ")
+	e.WriteString(formatRanges(er.Ranges))
+
+	var options []*Option
+	for _, d := range er.Declarations {
+		options = append(options, &Option{Name: "declaration", Value: d.literal()})
+	}
+	if v := er.Verification; v != VerificationDefault {
+		options = append(options, &Option{Name: "verification", Value: Identifier(v.String())})
+	}
+	options = append(options, er.Options...)
+
+	if err := encodeCompactOptions(e, options); err != nil {
+		return fmt.Errorf(`failed to encode options for extension range: %w`, err)
+	}
+	e.WriteByte(';')
+	er.Comments.encodeTrailing(e)
+	return e.check()
+}
+
+// checkExtendees verifies that extension fields that extend messages
+// declared in the same file use field numbers within the extension ranges
+// declared by those messages. Extendees are resolved using the symbols
+// known to tn, and those that are not declared in the file are assumed
+// to be declared elsewhere, and are not checked.
+func (f *File) checkExtendees(tn *typeNames) error {
+	messages := make(map[string]*Message)
+	for typ, full := range tn.nodes {
+		if m, ok := typ.(*Message); ok {
+			messages[full] = m
+		}
+	}
+	lookup := func(scope, name string) *Message {
+		full, kind, ok := tn.symbols.resolve(scope, name, true)
+		if !ok || kind != symbolMessage {
+			return nil
+		}
+		return messages[full]
+	}
+
+	check := func(scope string, ext *Extension) error {
+		var m *Message
+		switch target := ext.Target.(type) {
+		case nil:
+			m = lookup(scope, ext.Name)
+		case *Message:
+			m = target
+		case ExternalType:
+			m = lookup(scope, "."+target.String())
+		default:
+			m = lookup(scope, target.String())
+		}
+		if m == nil {
+			return nil
+		}
+		for _, field := range ext.Fields {
+			var er *ExtensionRange
+			for _, candidate := range m.ExtensionRanges {
+				if candidate.contains(field.ID) {
+					er = candidate
+					break
+				}
+			}
+			if er == nil {
+				return fmt.Errorf(`extension field %q uses number %d, which is outside of the extension ranges of %q`, field.Name, field.ID, m.Name)
+			}
+			if err := er.checkNumber(field.ID); err != nil {
+				return fmt.Errorf(`invalid extension field %q: %w`, field.Name, err)
+			}
+		}
+		return nil
+	}
+
+	for _, ext := range f.Extensions {
+		if err := check(f.Package, ext); err != nil {
+			return err
+		}
+	}
+	var walk func(string, []*Message) error
+	walk = func(scope string, list []*Message) error {
+		for _, m := range list {
+			name := joinName(scope, m.Name)
+			for _, ext := range m.Extensions {
+				if err := check(name, ext); err != nil {
+					return err
+				}
+			}
+			if err := walk(name, m.Messages); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(f.Package, f.Messages)
+}
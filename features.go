@@ -0,0 +1,247 @@
+package protowrite
+
+import (
+	"fmt"
+)
+
+// FieldPresence corresponds to the `features.field_presence` option
+type FieldPresence int
+
+const (
+	FieldPresenceUnknown FieldPresence = iota
+	FieldPresenceExplicit
+	FieldPresenceImplicit
+	FieldPresenceLegacyRequired
+)
+
+func (v FieldPresence) String() string {
+	switch v {
+	case FieldPresenceExplicit:
+		return "EXPLICIT"
+	case FieldPresenceImplicit:
+		return "IMPLICIT"
+	case FieldPresenceLegacyRequired:
+		return "LEGACY_REQUIRED"
+	default:
+		return "FIELD_PRESENCE_UNKNOWN"
+	}
+}
+
+// EnumType corresponds to the `features.enum_type` option
+type EnumType int
+
+const (
+	EnumTypeUnknown EnumType = iota
+	EnumTypeOpen
+	EnumTypeClosed
+)
+
+func (v EnumType) String() string {
+	switch v {
+	case EnumTypeOpen:
+		return "OPEN"
+	case EnumTypeClosed:
+		return "CLOSED"
+	default:
+		return "ENUM_TYPE_UNKNOWN"
+	}
+}
+
+// RepeatedFieldEncoding corresponds to the `features.repeated_field_encoding` option
+type RepeatedFieldEncoding int
+
+const (
+	RepeatedFieldEncodingUnknown RepeatedFieldEncoding = iota
+	RepeatedFieldEncodingPacked
+	RepeatedFieldEncodingExpanded
+)
+
+func (v RepeatedFieldEncoding) String() string {
+	switch v {
+	case RepeatedFieldEncodingPacked:
+		return "PACKED"
+	case RepeatedFieldEncodingExpanded:
+		return "EXPANDED"
+	default:
+		return "REPEATED_FIELD_ENCODING_UNKNOWN"
+	}
+}
+
+// UTF8Validation corresponds to the `features.utf8_validation` option
+type UTF8Validation int
+
+const (
+	UTF8ValidationUnknown UTF8Validation = iota
+	UTF8ValidationVerify
+	UTF8ValidationNone
+)
+
+func (v UTF8Validation) String() string {
+	switch v {
+	case UTF8ValidationVerify:
+		return "VERIFY"
+	case UTF8ValidationNone:
+		return "NONE"
+	default:
+		return "UTF8_VALIDATION_UNKNOWN"
+	}
+}
+
+// MessageEncoding corresponds to the `features.message_encoding` option
+type MessageEncoding int
+
+const (
+	MessageEncodingUnknown MessageEncoding = iota
+	MessageEncodingLengthPrefixed
+	MessageEncodingDelimited
+)
+
+func (v MessageEncoding) String() string {
+	switch v {
+	case MessageEncodingLengthPrefixed:
+		return "LENGTH_PREFIXED"
+	case MessageEncodingDelimited:
+		return "DELIMITED"
+	default:
+		return "MESSAGE_ENCODING_UNKNOWN"
+	}
+}
+
+// JSONFormat corresponds to the `features.json_format` option
+type JSONFormat int
+
+const (
+	JSONFormatUnknown JSONFormat = iota
+	JSONFormatAllow
+	JSONFormatLegacyBestEffort
+)
+
+func (v JSONFormat) String() string {
+	switch v {
+	case JSONFormatAllow:
+		return "ALLOW"
+	case JSONFormatLegacyBestEffort:
+		return "LEGACY_BEST_EFFORT"
+	default:
+		return "JSON_FORMAT_UNKNOWN"
+	}
+}
+
+// FeatureSet represents the set of Editions features that can be
+// specified on a declaration. Features that are left as their zero
+// value ("Unknown") are not set, and are inherited from the enclosing
+// declaration, or the edition defaults.
+//
+// Features may only be used in files using SyntaxEditions.
+type FeatureSet struct {
+	FieldPresence         FieldPresence
+	EnumType              EnumType
+	RepeatedFieldEncoding RepeatedFieldEncoding
+	UTF8Validation        UTF8Validation
+	MessageEncoding       MessageEncoding
+	JSONFormat            JSONFormat
+}
+
+// editionDefaults returns the fully resolved feature set for the given edition
+func editionDefaults(e Edition) (*FeatureSet, error) {
+	switch e {
+	case Edition2023, Edition2024:
+		return &FeatureSet{
+			FieldPresence:         FieldPresenceExplicit,
+			EnumType:              EnumTypeOpen,
+			RepeatedFieldEncoding: RepeatedFieldEncodingPacked,
+			UTF8Validation:        UTF8ValidationVerify,
+			MessageEncoding:       MessageEncodingLengthPrefixed,
+			JSONFormat:            JSONFormatAllow,
+		}, nil
+	default:
+		return nil, fmt.Errorf(`unsupported edition %q`, e)
+	}
+}
+
+// resolve returns a new FeatureSet where features that are not set
+// in fs are filled in using the values from parent
+func (fs *FeatureSet) resolve(parent *FeatureSet) *FeatureSet {
+	var resolved FeatureSet
+	if parent != nil {
+		resolved = *parent
+	}
+	if fs == nil {
+		return &resolved
+	}
+
+	if v := fs.FieldPresence; v != FieldPresenceUnknown {
+		resolved.FieldPresence = v
+	}
+	if v := fs.EnumType; v != EnumTypeUnknown {
+		resolved.EnumType = v
+	}
+	if v := fs.RepeatedFieldEncoding; v != RepeatedFieldEncodingUnknown {
+		resolved.RepeatedFieldEncoding = v
+	}
+	if v := fs.UTF8Validation; v != UTF8ValidationUnknown {
+		resolved.UTF8Validation = v
+	}
+	if v := fs.MessageEncoding; v != MessageEncodingUnknown {
+		resolved.MessageEncoding = v
+	}
+	if v := fs.JSONFormat; v != JSONFormatUnknown {
+		resolved.JSONFormat = v
+	}
+	return &resolved
+}
+
+// options returns the list of options required to express fs, omitting
+// features whose values are the same as those inherited from parent.
+func (fs *FeatureSet) options(parent *FeatureSet, compact bool) []*Option {
+	if fs == nil {
+		return nil
+	}
+	if parent == nil {
+		parent = &FeatureSet{}
+	}
+
+	var options []*Option
+	add := func(name string, v fmt.Stringer) {
+		options = append(options, &Option{
+			Name:    "features." + name,
+			Value:   Identifier(v.String()),
+			Compact: compact,
+		})
+	}
+	if v := fs.FieldPresence; v != FieldPresenceUnknown && v != parent.FieldPresence {
+		add("field_presence", v)
+	}
+	if v := fs.EnumType; v != EnumTypeUnknown && v != parent.EnumType {
+		add("enum_type", v)
+	}
+	if v := fs.RepeatedFieldEncoding; v != RepeatedFieldEncodingUnknown && v != parent.RepeatedFieldEncoding {
+		add("repeated_field_encoding", v)
+	}
+	if v := fs.UTF8Validation; v != UTF8ValidationUnknown && v != parent.UTF8Validation {
+		add("utf8_validation", v)
+	}
+	if v := fs.MessageEncoding; v != MessageEncodingUnknown && v != parent.MessageEncoding {
+		add("message_encoding", v)
+	}
+	if v := fs.JSONFormat; v != JSONFormatUnknown && v != parent.JSONFormat {
+		add("json_format", v)
+	}
+	return options
+}
+
+// pushFeatures computes the options needed to express fs, and makes the
+// resolved feature set available to nested declarations. It returns the
+// previous feature set, which the caller restores once it is done
+func (e *encodeState) pushFeatures(fs *FeatureSet, compact bool) (*FeatureSet, []*Option, error) {
+	if fs != nil && e.syntax != SyntaxEditions {
+		return nil, nil, fmt.Errorf(`features are not allowed in %s`, e.syntax)
+	}
+
+	parent := e.features
+	options := fs.options(parent, compact)
+	if fs != nil {
+		e.features = fs.resolve(parent)
+	}
+	return parent, options, nil
+}
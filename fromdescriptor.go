@@ -0,0 +1,937 @@
+package protowrite
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Field numbers used in SourceCodeInfo paths. See descriptor.proto
+const (
+	pathFileImports    = 3
+	pathFileMessages   = 4
+	pathFileEnums      = 5
+	pathFileServices   = 6
+	pathFileExtensions = 7
+	pathFileSyntax     = 12
+	pathFileEdition    = 14
+
+	pathMessageFields     = 2
+	pathMessageMessages   = 3
+	pathMessageEnums      = 4
+	pathMessageExtensions = 6
+	pathMessageOneOfs     = 8
+
+	pathEnumValues     = 2
+	pathServiceMethods = 2
+)
+
+// FromDescriptor rebuilds a File from fd. Comments recorded in the
+// descriptor's source information are kept, map entry messages are turned
+// back into map fields, and fields in synthetic oneofs become proto3
+// optional fields.
+//
+// Type names are written in the shortest form that resolves to the
+// correct declaration.
+func FromDescriptor(fd protoreflect.FileDescriptor) (*File, error) {
+	return fromDescriptor(protodesc.ToFileDescriptorProto(fd), fd)
+}
+
+// FromDescriptorProto is like FromDescriptor, but takes a FileDescriptorProto,
+// such as those found in descriptor sets produced by protoc. Imports are
+// looked up using options.Resolver, but are not required to be present.
+// Custom options can only be converted if their declarations can be found.
+func FromDescriptorProto(fdp *descriptorpb.FileDescriptorProto, options *DescriptorOptions) (*File, error) {
+	fd, err := protodesc.FileOptions{AllowUnresolvable: true}.New(fdp, options.resolver())
+	if err != nil {
+		return nil, fmt.Errorf(`failed to create file descriptor: %w`, err)
+	}
+	return fromDescriptor(fdp, fd)
+}
+
+type descriptorConverter struct {
+	fdp      *descriptorpb.FileDescriptorProto
+	symbols  symbolTable
+	types    *protoregistry.Types
+	comments map[string]*descriptorpb.SourceCodeInfo_Location
+}
+
+func fromDescriptor(fdp *descriptorpb.FileDescriptorProto, fd protoreflect.FileDescriptor) (*File, error) {
+	c := &descriptorConverter{
+		fdp:      fdp,
+		symbols:  make(symbolTable),
+		types:    &protoregistry.Types{},
+		comments: make(map[string]*descriptorpb.SourceCodeInfo_Location),
+	}
+	for _, loc := range fdp.GetSourceCodeInfo().GetLocation() {
+		c.comments[pathKey(loc.GetPath())] = loc
+	}
+
+	c.addFileSymbols(fd, true, make(map[string]bool))
+
+	file, err := c.file()
+	if err != nil {
+		return nil, fmt.Errorf(`failed to convert descriptor for %q: %w`, fdp.GetName(), err)
+	}
+	return file, nil
+}
+
+// addFileSymbols registers the declarations in fd and its imports. Only
+// the symbols of the file itself, and of the files it (transitively) imports
+// publicly are visible from the file, but extensions from all imports
+// are registered so that custom options can be decoded
+//
+// visited records the files that have already been processed, and
+// whether their symbols were visible
+func (c *descriptorConverter) addFileSymbols(fd protoreflect.FileDescriptor, visible bool, visited map[string]bool) {
+	wasVisible, ok := visited[fd.Path()]
+	if ok && (wasVisible || !visible) {
+		return
+	}
+	visited[fd.Path()] = visible
+
+	if visible {
+		c.symbols.addDescriptor(fd)
+	}
+	if !ok {
+		c.addExtensionTypes(fd.Extensions())
+		c.addNestedExtensionTypes(fd.Messages())
+	}
+
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		imp := imports.Get(i)
+		if imp.IsPlaceholder() {
+			continue
+		}
+		c.addFileSymbols(imp.FileDescriptor, visible && (imp.IsPublic || fd.Path() == c.fdp.GetName()), visited)
+	}
+}
+
+func (c *descriptorConverter) addExtensionTypes(list protoreflect.ExtensionDescriptors) {
+	for i := 0; i < list.Len(); i++ {
+		// an extension may be registered more than once if it is
+		// reachable through multiple imports. That's fine
+		_ = c.types.RegisterExtension(dynamicpb.NewExtensionType(list.Get(i)))
+	}
+}
+
+func (c *descriptorConverter) addNestedExtensionTypes(list protoreflect.MessageDescriptors) {
+	for i := 0; i < list.Len(); i++ {
+		md := list.Get(i)
+		c.addExtensionTypes(md.Extensions())
+		c.addNestedExtensionTypes(md.Messages())
+	}
+}
+
+func pathKey(path []int32) string {
+	var sb strings.Builder
+	for i, v := range path {
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(strconv.Itoa(int(v)))
+	}
+	return sb.String()
+}
+
+func appendPath(path []int32, v ...int32) []int32 {
+	ret := make([]int32, 0, len(path)+len(v))
+	ret = append(ret, path...)
+	return append(ret, v...)
+}
+
+// cleanComment converts a comment from SourceCodeInfo into the form used
+// by this package, which does not include the space after the comment
+// marker or the final newline
+func cleanComment(s string) string {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// commentsAt returns the comments recorded for the declaration at path
+func (c *descriptorConverter) commentsAt(path []int32) Comments {
+	loc, ok := c.comments[pathKey(path)]
+	if !ok {
+		return Comments{}
+	}
+	ret := Comments{
+		Leading:  cleanComment(loc.GetLeadingComments()),
+		Trailing: cleanComment(loc.GetTrailingComments()),
+	}
+	for _, s := range loc.GetLeadingDetachedComments() {
+		ret.Detached = append(ret.Detached, cleanComment(s))
+	}
+	return ret
+}
+
+func (c *descriptorConverter) file() (*File, error) {
+	fdp := c.fdp
+	f := &File{
+		Package:  fdp.GetPackage(),
+		Comments: c.commentsAt([]int32{pathFileSyntax}),
+	}
+
+	switch fdp.GetSyntax() {
+	case "", "proto2":
+		f.Syntax = SyntaxProto2
+	case "proto3":
+		f.Syntax = SyntaxProto3
+	case "editions":
+		f.Syntax = SyntaxEditions
+		switch fdp.GetEdition() {
+		case descriptorpb.Edition_EDITION_2023:
+			f.Edition = Edition2023
+		case descriptorpb.Edition_EDITION_2024:
+			f.Edition = Edition2024
+		default:
+			return nil, fmt.Errorf(`unsupported edition %s`, fdp.GetEdition())
+		}
+		f.Comments = c.commentsAt([]int32{pathFileEdition})
+	default:
+		return nil, fmt.Errorf(`unknown syntax %q`, fdp.GetSyntax())
+	}
+	// detached comments before the syntax statement make up the header
+	f.Header, f.Comments.Detached = f.Comments.Detached, nil
+
+	public := make(map[int32]struct{})
+	for _, i := range fdp.GetPublicDependency() {
+		public[i] = struct{}{}
+	}
+	weak := make(map[int32]struct{})
+	for _, i := range fdp.GetWeakDependency() {
+		weak[i] = struct{}{}
+	}
+	for i, path := range fdp.GetDependency() {
+		imp := &Import{Path: path, Comments: c.commentsAt([]int32{pathFileImports, int32(i)})}
+		if _, ok := public[int32(i)]; ok {
+			imp.Type = ImportPublic
+		} else if _, ok := weak[int32(i)]; ok {
+			imp.Type = ImportWeak
+		}
+		f.Imports = append(f.Imports, imp)
+	}
+
+	var err error
+	if f.Options, f.Features, err = c.options(f.Package, fdp.GetOptions(), false); err != nil {
+		return nil, fmt.Errorf(`invalid file options: %w`, err)
+	}
+
+	for i, dp := range fdp.GetMessageType() {
+		m, err := c.message(f.Package, dp, []int32{pathFileMessages, int32(i)})
+		if err != nil {
+			return nil, err
+		}
+		f.Messages = append(f.Messages, m)
+	}
+	for i, ep := range fdp.GetEnumType() {
+		e, err := c.enum(f.Package, ep, []int32{pathFileEnums, int32(i)})
+		if err != nil {
+			return nil, err
+		}
+		f.Enums = append(f.Enums, e)
+	}
+	if f.Extensions, err = c.extensions(f.Package, fdp.GetExtension(), []int32{pathFileExtensions}); err != nil {
+		return nil, err
+	}
+	for i, sp := range fdp.GetService() {
+		s, err := c.service(f.Package, sp, []int32{pathFileServices, int32(i)})
+		if err != nil {
+			return nil, err
+		}
+		f.Services = append(f.Services, s)
+	}
+	return f, nil
+}
+
+// typeName converts a fully-qualified type name into the shortest
+// name that refers to the same type from within scope
+func (c *descriptorConverter) typeName(scope, name string) string {
+	if !strings.HasPrefix(name, ".") {
+		// already relative. Nothing we can do
+		return name
+	}
+	return c.symbols.shortestName(scope, name[1:], true)
+}
+
+func (c *descriptorConverter) message(scope string, dp *descriptorpb.DescriptorProto, path []int32) (*Message, error) {
+	name := joinName(scope, dp.GetName())
+	m := &Message{
+		Name:     dp.GetName(),
+		Comments: c.commentsAt(path),
+	}
+
+	var err error
+	if m.Options, m.Features, err = c.options(name, dp.GetOptions(), false, "map_entry"); err != nil {
+		return nil, fmt.Errorf(`invalid options for message %q: %w`, name, err)
+	}
+
+	// map entry messages are not written out, but are needed to
+	// reconstruct the map fields that use them
+	entries := make(map[string]*descriptorpb.DescriptorProto)
+	for i, nested := range dp.GetNestedType() {
+		if nested.GetOptions().GetMapEntry() {
+			entries["."+joinName(name, nested.GetName())] = nested
+			continue
+		}
+		v, err := c.message(name, nested, appendPath(path, pathMessageMessages, int32(i)))
+		if err != nil {
+			return nil, err
+		}
+		m.Messages = append(m.Messages, v)
+	}
+	for i, ep := range dp.GetEnumType() {
+		e, err := c.enum(name, ep, appendPath(path, pathMessageEnums, int32(i)))
+		if err != nil {
+			return nil, err
+		}
+		m.Enums = append(m.Enums, e)
+	}
+	if m.Extensions, err = c.extensions(name, dp.GetExtension(), appendPath(path, pathMessageExtensions)); err != nil {
+		return nil, err
+	}
+
+	oneofs := make([]*OneOf, len(dp.GetOneofDecl()))
+	for i, op := range dp.GetOneofDecl() {
+		oneof := &OneOf{
+			Name:     op.GetName(),
+			Comments: c.commentsAt(appendPath(path, pathMessageOneOfs, int32(i))),
+		}
+		options, features, err := c.options(name, op.GetOptions(), false)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid options for oneof %q in %q: %w`, op.GetName(), name, err)
+		}
+		if len(options) > 0 {
+			return nil, fmt.Errorf(`options other than features are not supported in oneof %q in %q`, op.GetName(), name)
+		}
+		oneof.Features = features
+		oneofs[i] = oneof
+	}
+
+	for i, fp := range dp.GetField() {
+		field, err := c.field(name, fp, entries, appendPath(path, pathMessageFields, int32(i)))
+		if err != nil {
+			return nil, err
+		}
+		if fp.OneofIndex == nil || fp.GetProto3Optional() {
+			m.Fields = append(m.Fields, field)
+			continue
+		}
+		idx := int(fp.GetOneofIndex())
+		if idx >= len(oneofs) {
+			return nil, fmt.Errorf(`field %q in %q refers to unknown oneof %d`, fp.GetName(), name, idx)
+		}
+		oneofs[idx].Fields = append(oneofs[idx].Fields, field)
+	}
+	// synthetic oneofs only contain a single proto3 optional field,
+	// and are not written out
+	for i, oneof := range oneofs {
+		if !isSyntheticOneOf(dp, i) {
+			m.OneOfs = append(m.OneOfs, oneof)
+		}
+	}
+
+	for _, r := range dp.GetReservedRange() {
+		// reserved ranges in descriptors are exclusive
+		m.ReservedRanges = append(m.ReservedRanges, fromExclusiveRange(r.GetStart(), r.GetEnd()))
+	}
+	m.ReservedNames = append(m.ReservedNames, dp.GetReservedName()...)
+
+	if m.ExtensionRanges, err = c.extensionRanges(name, dp.GetExtensionRange()); err != nil {
+		return nil, fmt.Errorf(`invalid extension range in %q: %w`, name, err)
+	}
+	return m, nil
+}
+
+func isSyntheticOneOf(dp *descriptorpb.DescriptorProto, idx int) bool {
+	for _, fp := range dp.GetField() {
+		if fp.OneofIndex != nil && int(fp.GetOneofIndex()) == idx && !fp.GetProto3Optional() {
+			return false
+		}
+	}
+	for _, fp := range dp.GetField() {
+		if fp.OneofIndex != nil && int(fp.GetOneofIndex()) == idx {
+			return true
+		}
+	}
+	return false
+}
+
+func fromExclusiveRange(start, end int32) *Range {
+	if int(end) > MaxFieldNumber {
+		return &Range{Start: int(start), ToMax: true}
+	}
+	return &Range{Start: int(start), End: int(end) - 1}
+}
+
+// extensionRanges converts extension ranges, merging consecutive ranges
+// that share the same options into a single statement
+func (c *descriptorConverter) extensionRanges(scope string, list []*descriptorpb.DescriptorProto_ExtensionRange) ([]*ExtensionRange, error) {
+	var ret []*ExtensionRange
+	var prev *descriptorpb.ExtensionRangeOptions
+	for _, erp := range list {
+		r := fromExclusiveRange(erp.GetStart(), erp.GetEnd())
+		if len(ret) > 0 && proto.Equal(prev, erp.GetOptions()) {
+			last := ret[len(ret)-1]
+			last.Ranges = append(last.Ranges, r)
+			continue
+		}
+
+		er := &ExtensionRange{Ranges: []*Range{r}}
+		options := erp.GetOptions()
+		for _, d := range options.GetDeclaration() {
+			er.Declarations = append(er.Declarations, &ExtensionDeclaration{
+				Number:   int(d.GetNumber()),
+				FullName: d.GetFullName(),
+				Type:     d.GetType(),
+				Reserved: d.GetReserved(),
+				Repeated: d.GetRepeated(),
+			})
+		}
+		if options != nil && options.Verification != nil {
+			switch options.GetVerification() {
+			case descriptorpb.ExtensionRangeOptions_DECLARATION:
+				er.Verification = VerificationDeclaration
+			case descriptorpb.ExtensionRangeOptions_UNVERIFIED:
+				er.Verification = VerificationUnverified
+			}
+		}
+
+		rest, features, err := c.options(scope, options, true, "declaration", "verification")
+		if err != nil {
+			return nil, err
+		}
+		if features != nil {
+			return nil, fmt.Errorf(`features are not supported in extension ranges`)
+		}
+		er.Options = rest
+		ret = append(ret, er)
+		prev = options
+	}
+	return ret, nil
+}
+
+var fieldTypeNames = map[descriptorpb.FieldDescriptorProto_Type]ScalarType{}
+
+func init() {
+	for name, typ := range scalarFieldTypes {
+		fieldTypeNames[typ] = name
+	}
+}
+
+func (c *descriptorConverter) fieldType(scope string, fp *descriptorpb.FieldDescriptorProto) (Type, error) {
+	switch fp.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		return MessageRef(c.typeName(scope, fp.GetTypeName())), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return EnumRef(c.typeName(scope, fp.GetTypeName())), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return nil, fmt.Errorf(`groups are not supported`)
+	}
+	if typ, ok := fieldTypeNames[fp.GetType()]; ok {
+		return typ, nil
+	}
+	if fp.GetTypeName() != "" {
+		// protoc leaves the type unset until the name is resolved
+		return TypeRef{Name: c.typeName(scope, fp.GetTypeName())}, nil
+	}
+	return nil, fmt.Errorf(`unknown field type %s`, fp.GetType())
+}
+
+func (c *descriptorConverter) field(scope string, fp *descriptorpb.FieldDescriptorProto, entries map[string]*descriptorpb.DescriptorProto, path []int32) (*Field, error) {
+	field := &Field{
+		Name:     fp.GetName(),
+		ID:       int(fp.GetNumber()),
+		Comments: c.commentsAt(path),
+	}
+
+	if entry, ok := entries[fp.GetTypeName()]; ok && fp.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		var key, value Type
+		for _, v := range entry.GetField() {
+			typ, err := c.fieldType(scope, v)
+			if err != nil {
+				return nil, fmt.Errorf(`invalid map field %q in %q: %w`, fp.GetName(), scope, err)
+			}
+			switch v.GetNumber() {
+			case 1:
+				key = typ
+			case 2:
+				value = typ
+			}
+		}
+		field.Type = &MapType{Key: key, Value: value}
+	} else {
+		typ, err := c.fieldType(scope, fp)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid field %q in %q: %w`, fp.GetName(), scope, err)
+		}
+		field.Type = typ
+
+		switch fp.GetLabel() {
+		case descriptorpb.FieldDescriptorProto_LABEL_REPEATED:
+			field.Cardinality = CardinalityRepeated
+		case descriptorpb.FieldDescriptorProto_LABEL_REQUIRED:
+			field.Cardinality = CardinalityRequired
+		default:
+			if fp.GetProto3Optional() {
+				field.Cardinality = CardinalityOptional
+			}
+		}
+	}
+
+	var options []*Option
+	if fp.JsonName != nil && fp.GetJsonName() != jsonName(fp.GetName()) {
+		options = append(options, &Option{Name: "json_name", Value: fp.GetJsonName(), Compact: true})
+	}
+	if fp.DefaultValue != nil {
+		v, err := c.defaultValue(fp)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid default value for field %q in %q: %w`, fp.GetName(), scope, err)
+		}
+		options = append(options, &Option{Name: "default", Value: v, Compact: true})
+	}
+	rest, features, err := c.options(scope, fp.GetOptions(), true)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid options for field %q in %q: %w`, fp.GetName(), scope, err)
+	}
+	field.Options = append(options, rest...)
+	field.Features = features
+	return field, nil
+}
+
+// defaultValue converts the default value of a field, which is stored
+// as a string in the descriptor, into an option value
+func (c *descriptorConverter) defaultValue(fp *descriptorpb.FieldDescriptorProto) (interface{}, error) {
+	s := fp.GetDefaultValue()
+	switch fp.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return s, nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return unescapeBytes(s)
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return Identifier(s), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return strconv.ParseBool(s)
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		switch s {
+		case "inf", "-inf", "nan":
+			return Identifier(s), nil
+		}
+		return strconv.ParseFloat(s, 64)
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT64, descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int(n), nil
+	default:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return int(n), nil
+	}
+}
+
+// unescapeBytes reverses escapeBytes
+func unescapeBytes(s string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", fmt.Errorf(`invalid escape sequence at end of string`)
+		}
+		switch c := s[i]; c {
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case '"', '\'', '\\', '?':
+			sb.WriteByte(c)
+		default:
+			if c < '0' || c > '7' {
+				return "", fmt.Errorf(`invalid escape sequence \%c`, c)
+			}
+			v, n := 0, 0
+			for n < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7' {
+				v = v*8 + int(s[i]-'0')
+				i++
+				n++
+			}
+			i--
+			sb.WriteByte(byte(v))
+		}
+	}
+	return sb.String(), nil
+}
+
+func (c *descriptorConverter) enum(scope string, ep *descriptorpb.EnumDescriptorProto, path []int32) (*Enum, error) {
+	name := joinName(scope, ep.GetName())
+	e := &Enum{
+		Name:     ep.GetName(),
+		Comments: c.commentsAt(path),
+	}
+
+	var err error
+	if e.Options, e.Features, err = c.options(name, ep.GetOptions(), false); err != nil {
+		return nil, fmt.Errorf(`invalid options for enum %q: %w`, name, err)
+	}
+
+	for i, vp := range ep.GetValue() {
+		ee := &EnumElement{
+			Name:     vp.GetName(),
+			Value:    int(vp.GetNumber()),
+			Comments: c.commentsAt(appendPath(path, pathEnumValues, int32(i))),
+		}
+		if ee.Options, ee.Features, err = c.options(name, vp.GetOptions(), true); err != nil {
+			return nil, fmt.Errorf(`invalid options for enum value %q in %q: %w`, vp.GetName(), name, err)
+		}
+		e.Elements = append(e.Elements, ee)
+	}
+
+	for _, r := range ep.GetReservedRange() {
+		// unlike messages, enum reserved ranges are inclusive
+		rng := &Range{Start: int(r.GetStart()), End: int(r.GetEnd())}
+		if rng.End == MaxEnumValue {
+			rng = &Range{Start: rng.Start, ToMax: true}
+		}
+		e.ReservedRanges = append(e.ReservedRanges, rng)
+	}
+	e.ReservedNames = append(e.ReservedNames, ep.GetReservedName()...)
+	return e, nil
+}
+
+// extensions converts extension fields, grouping consecutive fields that
+// extend the same message into a single `extend` block
+func (c *descriptorConverter) extensions(scope string, list []*descriptorpb.FieldDescriptorProto, path []int32) ([]*Extension, error) {
+	var ret []*Extension
+	var prev string
+	for i, fp := range list {
+		field, err := c.field(scope, fp, nil, appendPath(path, int32(i)))
+		if err != nil {
+			return nil, err
+		}
+		if len(ret) > 0 && fp.GetExtendee() == prev {
+			last := ret[len(ret)-1]
+			last.Fields = append(last.Fields, field)
+			continue
+		}
+		ret = append(ret, &Extension{
+			Name:   c.typeName(scope, fp.GetExtendee()),
+			Fields: []*Field{field},
+		})
+		prev = fp.GetExtendee()
+	}
+	return ret, nil
+}
+
+func (c *descriptorConverter) service(scope string, sp *descriptorpb.ServiceDescriptorProto, path []int32) (*Service, error) {
+	name := joinName(scope, sp.GetName())
+	s := &Service{
+		Name:     sp.GetName(),
+		Comments: c.commentsAt(path),
+	}
+
+	options, features, err := c.options(name, sp.GetOptions(), false)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid options for service %q: %w`, name, err)
+	}
+	if features != nil {
+		return nil, fmt.Errorf(`features are not supported in service %q`, name)
+	}
+	s.Options = options
+
+	for i, mp := range sp.GetMethod() {
+		m := &Method{
+			Name:            mp.GetName(),
+			Comments:        c.commentsAt(appendPath(path, pathServiceMethods, int32(i))),
+			Input:           MessageRef(c.typeName(scope, mp.GetInputType())),
+			Output:          MessageRef(c.typeName(scope, mp.GetOutputType())),
+			ClientStreaming: mp.GetClientStreaming(),
+			ServerStreaming: mp.GetServerStreaming(),
+		}
+		options, features, err := c.options(name, mp.GetOptions(), false)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid options for method %q in %q: %w`, mp.GetName(), name, err)
+		}
+		if features != nil {
+			return nil, fmt.Errorf(`features are not supported in method %q in %q`, mp.GetName(), name)
+		}
+		m.Options = options
+		s.Methods = append(s.Methods, m)
+	}
+	return s, nil
+}
+
+// featureSet converts the features in an options message
+func featureSet(fs *descriptorpb.FeatureSet) *FeatureSet {
+	v := &FeatureSet{}
+	switch fs.GetFieldPresence() {
+	case descriptorpb.FeatureSet_EXPLICIT:
+		v.FieldPresence = FieldPresenceExplicit
+	case descriptorpb.FeatureSet_IMPLICIT:
+		v.FieldPresence = FieldPresenceImplicit
+	case descriptorpb.FeatureSet_LEGACY_REQUIRED:
+		v.FieldPresence = FieldPresenceLegacyRequired
+	}
+	switch fs.GetEnumType() {
+	case descriptorpb.FeatureSet_OPEN:
+		v.EnumType = EnumTypeOpen
+	case descriptorpb.FeatureSet_CLOSED:
+		v.EnumType = EnumTypeClosed
+	}
+	switch fs.GetRepeatedFieldEncoding() {
+	case descriptorpb.FeatureSet_PACKED:
+		v.RepeatedFieldEncoding = RepeatedFieldEncodingPacked
+	case descriptorpb.FeatureSet_EXPANDED:
+		v.RepeatedFieldEncoding = RepeatedFieldEncodingExpanded
+	}
+	switch fs.GetUtf8Validation() {
+	case descriptorpb.FeatureSet_VERIFY:
+		v.UTF8Validation = UTF8ValidationVerify
+	case descriptorpb.FeatureSet_NONE:
+		v.UTF8Validation = UTF8ValidationNone
+	}
+	switch fs.GetMessageEncoding() {
+	case descriptorpb.FeatureSet_LENGTH_PREFIXED:
+		v.MessageEncoding = MessageEncodingLengthPrefixed
+	case descriptorpb.FeatureSet_DELIMITED:
+		v.MessageEncoding = MessageEncodingDelimited
+	}
+	switch fs.GetJsonFormat() {
+	case descriptorpb.FeatureSet_ALLOW:
+		v.JSONFormat = JSONFormatAllow
+	case descriptorpb.FeatureSet_LEGACY_BEST_EFFORT:
+		v.JSONFormat = JSONFormatLegacyBestEffort
+	}
+	return v
+}
+
+// options converts an options message into a list of options and the
+// features it contains. Fields listed in skip are ignored, as they are
+// represented elsewhere in the File.
+func (c *descriptorConverter) options(scope string, msg proto.Message, compact bool, skip ...string) ([]*Option, *FeatureSet, error) {
+	if msg == nil || !msg.ProtoReflect().IsValid() {
+		return nil, nil, nil
+	}
+
+	m := msg.ProtoReflect()
+	if len(m.GetUnknown()) > 0 {
+		// custom options that were not known when the descriptor was
+		// decoded are left as unknown fields. Decode them again, now that
+		// we know about the extensions declared in the file and its imports
+		buf, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, nil, fmt.Errorf(`failed to marshal options: %w`, err)
+		}
+		m = m.Type().New()
+		if err := (proto.UnmarshalOptions{Resolver: c}).Unmarshal(buf, m.Interface()); err != nil {
+			return nil, nil, fmt.Errorf(`failed to decode custom options: %w`, err)
+		}
+		if len(m.GetUnknown()) > 0 {
+			return nil, nil, fmt.Errorf(`options contain unknown fields, which may be custom options whose declarations are not available`)
+		}
+	}
+
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Number() < fields[j].Number()
+	})
+
+	var options []*Option
+	var features *FeatureSet
+FIELDS:
+	for _, fd := range fields {
+		if !fd.IsExtension() {
+			if fd.Name() == "features" {
+				fs, ok := m.Get(fd).Message().Interface().(*descriptorpb.FeatureSet)
+				if !ok {
+					return nil, nil, fmt.Errorf(`unexpected type %T for features`, m.Get(fd).Message().Interface())
+				}
+				features = featureSet(fs)
+				continue
+			}
+			for _, s := range skip {
+				if string(fd.Name()) == s {
+					continue FIELDS
+				}
+			}
+		}
+
+		name := string(fd.Name())
+		if fd.IsExtension() {
+			name = "(" + c.symbols.shortestName(scope, string(fd.FullName()), false) + ")"
+		}
+
+		v := m.Get(fd)
+		switch {
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				options = append(options, &Option{Name: name, Value: c.optionValue(scope, fd, list.Get(i)), Compact: compact})
+			}
+		case fd.IsMap():
+			for _, entry := range c.mapEntries(scope, fd, v.Map()) {
+				options = append(options, &Option{Name: name, Value: entry, Compact: compact})
+			}
+		default:
+			options = append(options, &Option{Name: name, Value: c.optionValue(scope, fd, v), Compact: compact})
+		}
+	}
+	return options, features, nil
+}
+
+// optionValue converts a single (non-list) value into an option value
+func (c *descriptorConverter) optionValue(scope string, fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return Identifier(ev.Name())
+		}
+		return int(v.Enum())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return int(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if n := v.Uint(); n > math.MaxInt64 {
+			return n
+		}
+		return int(v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f := v.Float()
+		switch {
+		case math.IsInf(f, 1):
+			return Identifier("inf")
+		case math.IsInf(f, -1):
+			return Identifier("-inf")
+		case math.IsNaN(f):
+			return Identifier("nan")
+		}
+		return f
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return string(v.Bytes())
+	default:
+		return c.messageLiteral(scope, v.Message())
+	}
+}
+
+func (c *descriptorConverter) messageLiteral(scope string, m protoreflect.Message) *MessageLiteral {
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Number() < fields[j].Number()
+	})
+
+	ml := &MessageLiteral{}
+	for _, fd := range fields {
+		name := fd.TextName()
+		if fd.IsExtension() {
+			name = "[" + string(fd.FullName()) + "]"
+		}
+
+		v := m.Get(fd)
+		switch {
+		case fd.IsList():
+			var values []interface{}
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				values = append(values, c.optionValue(scope, fd, list.Get(i)))
+			}
+			ml.Fields = append(ml.Fields, &MessageLiteralField{Name: name, Value: values})
+		case fd.IsMap():
+			for _, entry := range c.mapEntries(scope, fd, v.Map()) {
+				ml.Fields = append(ml.Fields, &MessageLiteralField{Name: name, Value: entry})
+			}
+		default:
+			ml.Fields = append(ml.Fields, &MessageLiteralField{Name: name, Value: c.optionValue(scope, fd, v)})
+		}
+	}
+	return ml
+}
+
+// mapEntries converts the entries of a map into message literals,
+// sorted by key
+func (c *descriptorConverter) mapEntries(scope string, fd protoreflect.FieldDescriptor, m protoreflect.Map) []*MessageLiteral {
+	type entry struct {
+		key   protoreflect.MapKey
+		value protoreflect.Value
+	}
+	var entries []entry
+	m.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		entries = append(entries, entry{key: k, value: v})
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].key.Value(), entries[j].key.Value()
+		switch fd.MapKey().Kind() {
+		case protoreflect.StringKind:
+			return a.String() < b.String()
+		case protoreflect.BoolKind:
+			return !a.Bool() && b.Bool()
+		case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			return a.Uint() < b.Uint()
+		default:
+			return a.Int() < b.Int()
+		}
+	})
+
+	var ret []*MessageLiteral
+	for _, e := range entries {
+		ret = append(ret, &MessageLiteral{
+			Fields: []*MessageLiteralField{
+				{Name: "key", Value: c.optionValue(scope, fd.MapKey(), e.key.Value())},
+				{Name: "value", Value: c.optionValue(scope, fd.MapValue(), e.value)},
+			},
+		})
+	}
+	return ret
+}
+
+// FindExtensionByName implements protoregistry.ExtensionTypeResolver
+func (c *descriptorConverter) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	if xt, err := c.types.FindExtensionByName(field); err == nil {
+		return xt, nil
+	}
+	return protoregistry.GlobalTypes.FindExtensionByName(field)
+}
+
+// FindExtensionByNumber implements protoregistry.ExtensionTypeResolver
+func (c *descriptorConverter) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	if xt, err := c.types.FindExtensionByNumber(message, field); err == nil {
+		return xt, nil
+	}
+	return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+}
@@ -1,8 +1,11 @@
 module github.com/lestrrat-go/protowrite
 
-go 1.19
+go 1.23
 
-require github.com/stretchr/testify v1.8.1
+require (
+	github.com/stretchr/testify v1.8.1
+	google.golang.org/protobuf v1.36.11
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
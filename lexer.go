@@ -0,0 +1,388 @@
+package protowrite
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenInt
+	tokenFloat
+	tokenString
+	tokenPunct
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of input"
+	case tokenIdent:
+		return "identifier"
+	case tokenInt:
+		return "integer"
+	case tokenFloat:
+		return "float"
+	case tokenString:
+		return "string"
+	default:
+		return "punctuation"
+	}
+}
+
+// position describes a location in the source, 1-based
+type position struct {
+	Line   int
+	Column int
+}
+
+type sourceComment struct {
+	// Text is the content of the comment, without the comment markers
+	Text  string
+	Block bool
+	Start position
+	End   position
+	// Trailing is true if the comment starts on the same line as the
+	// end of the preceding token
+	Trailing bool
+}
+
+type token struct {
+	Kind tokenKind
+	// Text is the raw text of the token. For strings, this is the
+	// decoded value
+	Text string
+	Pos  position
+	// EndLine is the line where the token ends
+	EndLine int
+	// Comments lists the comments between the previous token and this one
+	Comments []*sourceComment
+}
+
+func (t *token) is(kind tokenKind, text string) bool {
+	return t.Kind == kind && t.Text == text
+}
+
+func (t *token) String() string {
+	switch t.Kind {
+	case tokenEOF:
+		return t.Kind.String()
+	case tokenString:
+		return fmt.Sprintf("string %q", t.Text)
+	default:
+		return fmt.Sprintf("%q", t.Text)
+	}
+}
+
+type lexer struct {
+	src  []byte
+	off  int
+	line int
+	col  int
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func (l *lexer) pos() position {
+	return position{Line: l.line, Column: l.col}
+}
+
+func (l *lexer) peekByte(n int) byte {
+	if l.off+n >= len(l.src) {
+		return 0
+	}
+	return l.src[l.off+n]
+}
+
+func (l *lexer) advance() byte {
+	c := l.src[l.off]
+	l.off++
+	if c == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return c
+}
+
+func (l *lexer) errorf(pos position, format string, args ...interface{}) error {
+	return &ParseError{Line: pos.Line, Column: pos.Column, Err: fmt.Errorf(format, args...)}
+}
+
+// tokenize splits src into tokens. The last token is always of kind tokenEOF
+func tokenize(src []byte) ([]*token, error) {
+	l := &lexer{src: src, line: 1, col: 1}
+	var tokens []*token
+	var comments []*sourceComment
+	prevLine := 0
+	for {
+		if l.off >= len(l.src) {
+			tokens = append(tokens, &token{Kind: tokenEOF, Pos: l.pos(), EndLine: l.line, Comments: comments})
+			return tokens, nil
+		}
+
+		c := l.peekByte(0)
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f':
+			l.advance()
+			continue
+		case c == '/' && (l.peekByte(1) == '/' || l.peekByte(1) == '*'):
+			comment, err := l.comment()
+			if err != nil {
+				return nil, err
+			}
+			comment.Trailing = len(tokens) > 0 && len(comments) == 0 && comment.Start.Line == prevLine
+			comments = append(comments, comment)
+			continue
+		}
+
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tok.EndLine = l.line
+		tok.Comments = comments
+		comments = nil
+		prevLine = tok.EndLine
+		tokens = append(tokens, tok)
+	}
+}
+
+func (l *lexer) comment() (*sourceComment, error) {
+	start := l.pos()
+	l.advance()
+	if l.advance() == '/' {
+		begin := l.off
+		for l.off < len(l.src) && l.src[l.off] != '\n' {
+			l.advance()
+		}
+		text := strings.TrimSuffix(string(l.src[begin:l.off]), "\r")
+		return &sourceComment{
+			Text:  strings.TrimPrefix(text, " "),
+			Start: start,
+			End:   l.pos(),
+		}, nil
+	}
+
+	begin := l.off
+	for {
+		if l.off+1 >= len(l.src) {
+			return nil, l.errorf(start, `unterminated block comment`)
+		}
+		if l.src[l.off] == '*' && l.src[l.off+1] == '/' {
+			break
+		}
+		l.advance()
+	}
+	text := string(l.src[begin:l.off])
+	l.advance()
+	l.advance()
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if i > 0 {
+			line = strings.TrimPrefix(line, "*")
+			line = strings.TrimPrefix(line, " ")
+		}
+		lines[i] = line
+	}
+	// drop leading and trailing empty lines, such as those produced by
+	// the "/*\n * ...\n */" style
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return &sourceComment{
+		Text:  strings.Join(lines, "\n"),
+		Block: true,
+		Start: start,
+		End:   l.pos(),
+	}, nil
+}
+
+func (l *lexer) next() (*token, error) {
+	start := l.pos()
+	begin := l.off
+	c := l.peekByte(0)
+
+	switch {
+	case isLetter(c):
+		for l.off < len(l.src) && (isLetter(l.src[l.off]) || isDigit(l.src[l.off])) {
+			l.advance()
+		}
+		return &token{Kind: tokenIdent, Text: string(l.src[begin:l.off]), Pos: start}, nil
+	case isDigit(c) || (c == '.' && isDigit(l.peekByte(1))):
+		return l.number()
+	case c == '"' || c == '\'':
+		s, err := l.str()
+		if err != nil {
+			return nil, err
+		}
+		return &token{Kind: tokenString, Text: s, Pos: start}, nil
+	}
+
+	switch c {
+	case ';', ',', '=', '{', '}', '[', ']', '(', ')', '<', '>', '.', ':', '/', '-', '+':
+		l.advance()
+		return &token{Kind: tokenPunct, Text: string(c), Pos: start}, nil
+	}
+
+	r, _ := utf8.DecodeRune(l.src[l.off:])
+	return nil, l.errorf(start, `unexpected character %q`, r)
+}
+
+func (l *lexer) number() (*token, error) {
+	start := l.pos()
+	begin := l.off
+	kind := tokenInt
+
+	if l.peekByte(0) == '0' && (l.peekByte(1) == 'x' || l.peekByte(1) == 'X') {
+		l.advance()
+		l.advance()
+		if !isHexDigit(l.peekByte(0)) {
+			return nil, l.errorf(start, `invalid hexadecimal literal`)
+		}
+		for isHexDigit(l.peekByte(0)) {
+			l.advance()
+		}
+	} else {
+		for isDigit(l.peekByte(0)) {
+			l.advance()
+		}
+		if l.peekByte(0) == '.' {
+			kind = tokenFloat
+			l.advance()
+			for isDigit(l.peekByte(0)) {
+				l.advance()
+			}
+		}
+		if c := l.peekByte(0); c == 'e' || c == 'E' {
+			kind = tokenFloat
+			l.advance()
+			if c := l.peekByte(0); c == '+' || c == '-' {
+				l.advance()
+			}
+			if !isDigit(l.peekByte(0)) {
+				return nil, l.errorf(start, `invalid exponent in float literal`)
+			}
+			for isDigit(l.peekByte(0)) {
+				l.advance()
+			}
+		}
+	}
+
+	if c := l.peekByte(0); isLetter(c) || (c == '.' && kind == tokenInt) {
+		return nil, l.errorf(start, `invalid numeric literal`)
+	}
+	return &token{Kind: kind, Text: string(l.src[begin:l.off]), Pos: start}, nil
+}
+
+func (l *lexer) str() (string, error) {
+	start := l.pos()
+	quote := l.advance()
+	var sb strings.Builder
+	for {
+		if l.off >= len(l.src) || l.peekByte(0) == '\n' {
+			return "", l.errorf(start, `unterminated string literal`)
+		}
+		c := l.advance()
+		if c == quote {
+			return sb.String(), nil
+		}
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+
+		escPos := l.pos()
+		if l.off >= len(l.src) {
+			return "", l.errorf(start, `unterminated string literal`)
+		}
+		c = l.advance()
+		switch c {
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			sb.WriteByte(c)
+		case 'x', 'X':
+			v, n := 0, 0
+			for n < 2 && isHexDigit(l.peekByte(0)) {
+				v = v*16 + hexValue(l.advance())
+				n++
+			}
+			if n == 0 {
+				return "", l.errorf(escPos, `invalid hexadecimal escape`)
+			}
+			sb.WriteByte(byte(v))
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			v, n := int(c-'0'), 1
+			for n < 3 && l.peekByte(0) >= '0' && l.peekByte(0) <= '7' {
+				v = v*8 + int(l.advance()-'0')
+				n++
+			}
+			if v > 0xff {
+				return "", l.errorf(escPos, `octal escape out of range`)
+			}
+			sb.WriteByte(byte(v))
+		case 'u', 'U':
+			size := 4
+			if c == 'U' {
+				size = 8
+			}
+			v := 0
+			for i := 0; i < size; i++ {
+				if !isHexDigit(l.peekByte(0)) {
+					return "", l.errorf(escPos, `invalid unicode escape`)
+				}
+				v = v*16 + hexValue(l.advance())
+			}
+			if v > utf8.MaxRune {
+				return "", l.errorf(escPos, `unicode escape out of range`)
+			}
+			sb.WriteRune(rune(v))
+		default:
+			return "", l.errorf(escPos, `invalid escape sequence \%c`, c)
+		}
+	}
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	default:
+		return int(c-'A') + 10
+	}
+}
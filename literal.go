@@ -0,0 +1,210 @@
+package protowrite
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Hex is an integer value that is written in hexadecimal, such as `0x1F`
+type Hex uint64
+
+func (v Hex) encode(e *encodeState) error {
+	e.scratch = append(e.scratch[:0], "0x"...)
+	e.scratch = appendHex(e.scratch, uint64(v), 0, true)
+	e.Write(e.scratch)
+	return nil
+}
+
+// Octal is an integer value that is written in octal, such as `017`
+type Octal uint64
+
+func (v Octal) encode(e *encodeState) error {
+	if v == 0 {
+		e.WriteByte('0')
+		return nil
+	}
+	e.scratch = append(e.scratch[:0], '0')
+	e.scratch = strconv.AppendUint(e.scratch, uint64(v), 8)
+	e.Write(e.scratch)
+	return nil
+}
+
+// writeValue writes v as a protobuf literal. Values that know how to
+// encode themselves, such as Identifier and *MessageLiteral, are asked to
+// do so. Strings and byte slices are quoted, integers and floats are
+// written in their shortest form, and enums generated by protoc-gen-go are
+// written as identifiers.
+//
+// Lists are only allowed as the values of message literal fields, and
+// are accepted only when allowList is true
+func writeValue(e *encodeState, v interface{}, allowList bool) error {
+	switch v := v.(type) {
+	case nil:
+		return fmt.Errorf(`value must not be nil`)
+	case encoder:
+		return v.encode(e)
+	case protoreflect.Enum:
+		if ev := v.Descriptor().Values().ByNumber(v.Number()); ev != nil {
+			e.WriteString(string(ev.Name()))
+			return nil
+		}
+		e.writeInt(int(v.Number()))
+		return nil
+	case []byte:
+		e.scratch = appendQuotedBytes(e.scratch[:0], v, e.options.quote.char())
+		e.Write(e.scratch)
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		e.writeQuoted(rv.String())
+	case reflect.Bool:
+		e.scratch = strconv.AppendBool(e.scratch[:0], rv.Bool())
+		e.Write(e.scratch)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.scratch = strconv.AppendInt(e.scratch[:0], rv.Int(), 10)
+		e.Write(e.scratch)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.scratch = strconv.AppendUint(e.scratch[:0], rv.Uint(), 10)
+		e.Write(e.scratch)
+	case reflect.Float32:
+		e.WriteString(formatFloat(rv.Float(), 32))
+	case reflect.Float64:
+		e.WriteString(formatFloat(rv.Float(), 64))
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			buf := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(buf), rv)
+			e.scratch = appendQuotedBytes(e.scratch[:0], buf, e.options.quote.char())
+			e.Write(e.scratch)
+			return nil
+		}
+		if !allowList {
+			return fmt.Errorf(`list values are only allowed in message literals (got %T)`, v)
+		}
+		e.WriteByte('[')
+		for i := 0; i < rv.Len(); i++ {
+			if i > 0 {
+				e.WriteString(", ")
+			}
+			// lists may not be nested
+			if err := writeValue(e, rv.Index(i).Interface(), false); err != nil {
+				return fmt.Errorf(`invalid list element %d: %w`, i, err)
+			}
+		}
+		e.WriteByte(']')
+	default:
+		return fmt.Errorf(`unsupported value of type %T`, v)
+	}
+	return nil
+}
+
+// formatFloat writes f in its shortest form, using the identifiers
+// `inf` and `nan` for the special values
+func formatFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bitSize)
+}
+
+// appendQuoted appends s as a protobuf string literal enclosed in q,
+// which is either a double or a single quotation mark. Printable
+// characters are written as they are, and everything else is escaped.
+// Bytes that are not valid UTF-8 are written as octal escapes
+func appendQuoted(b []byte, s string, q byte) []byte {
+	b = append(b, q)
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = appendOctalEscape(b, s[i])
+		case r < utf8.RuneSelf:
+			b = appendEscapedByte(b, byte(r), q)
+		case unicode.IsPrint(r):
+			b = append(b, s[i:i+size]...)
+		case r <= 0xffff:
+			b = append(b, `\u`...)
+			b = appendHex(b, uint64(r), 4, false)
+		default:
+			b = append(b, `\U`...)
+			b = appendHex(b, uint64(r), 8, false)
+		}
+		i += size
+	}
+	return append(b, q)
+}
+
+// appendQuotedBytes appends v as a protobuf string literal enclosed in q,
+// escaping every byte that is not printable ASCII
+func appendQuotedBytes(b, v []byte, q byte) []byte {
+	b = append(b, q)
+	for _, c := range v {
+		b = appendEscapedByte(b, c, q)
+	}
+	return append(b, q)
+}
+
+// appendEscapedByte appends c, escaping it if it is not printable ASCII
+// or if it is the quotation mark q
+func appendEscapedByte(b []byte, c, q byte) []byte {
+	switch c {
+	case '\a':
+		return append(b, `\a`...)
+	case '\b':
+		return append(b, `\b`...)
+	case '\f':
+		return append(b, `\f`...)
+	case '\n':
+		return append(b, `\n`...)
+	case '\r':
+		return append(b, `\r`...)
+	case '\t':
+		return append(b, `\t`...)
+	case '\v':
+		return append(b, `\v`...)
+	case '\\':
+		return append(b, `\\`...)
+	case q:
+		return append(b, '\\', c)
+	default:
+		if c < 0x20 || c >= 0x7f {
+			return appendOctalEscape(b, c)
+		}
+		return append(b, c)
+	}
+}
+
+// appendOctalEscape appends c as a three digit octal escape, such as `\377`
+func appendOctalEscape(b []byte, c byte) []byte {
+	return append(b, '\\', '0'+c>>6, '0'+c>>3&7, '0'+c&7)
+}
+
+// appendHex appends v in hexadecimal, padded with zeros to width digits
+func appendHex(b []byte, v uint64, width int, upper bool) []byte {
+	digits := "0123456789abcdef"
+	if upper {
+		digits = "0123456789ABCDEF"
+	}
+	var buf [16]byte
+	i := len(buf)
+	for v > 0 || i == len(buf) || len(buf)-i < width {
+		i--
+		buf[i] = digits[v&0xf]
+		v >>= 4
+	}
+	return append(b, buf[i:]...)
+}
@@ -0,0 +1,112 @@
+package protowrite_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/protowrite"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestLiteralValues(t *testing.T) {
+	var b protowrite.Builder
+
+	type myInt int32
+	type myString string
+
+	marshal := func(t *testing.T, value interface{}) (string, error) {
+		t.Helper()
+		file, err := b.File().
+			Package(`foo.bar`).
+			Option("(value)", value).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+		buf, err := protowrite.Marshal(file)
+		if err != nil {
+			return "", err
+		}
+		s := string(buf)
+		start := strings.Index(s, "option (value) = ")
+		require.NotEqual(t, -1, start, `option should be written`)
+		s = s[start+len("option (value) = "):]
+		return s[:strings.LastIndex(s, ";")], nil
+	}
+
+	testcases := []struct {
+		Name     string
+		Value    interface{}
+		Expected string
+	}{
+		{Name: "string", Value: "hello", Expected: `"hello"`},
+		{Name: "string escapes", Value: "a\"b\\c\n\t\x00", Expected: `"a\"b\\c\n\t\000"`},
+		{Name: "unicode string", Value: "héllo, 世界", Expected: `"héllo, 世界"`},
+		{Name: "invalid utf-8", Value: "a\xffb", Expected: `"a\377b"`},
+		{Name: "non-printable rune", Value: "a\u200bb", Expected: `"a\u200bb"`},
+		{Name: "named string", Value: myString("x"), Expected: `"x"`},
+		{Name: "bytes", Value: []byte{0, 'a', 0xff, '"'}, Expected: `"\000a\377\""`},
+		{Name: "true", Value: true, Expected: `true`},
+		{Name: "false", Value: false, Expected: `false`},
+		{Name: "identifier", Value: protowrite.Identifier("SPEED"), Expected: `SPEED`},
+		{Name: "generated enum", Value: descriptorpb.FileOptions_CODE_SIZE, Expected: `CODE_SIZE`},
+		{Name: "negative int", Value: -42, Expected: `-42`},
+		{Name: "int64", Value: int64(math.MinInt64), Expected: `-9223372036854775808`},
+		{Name: "uint64", Value: uint64(math.MaxUint64), Expected: `18446744073709551615`},
+		{Name: "named int", Value: myInt(7), Expected: `7`},
+		{Name: "hex", Value: protowrite.Hex(0xFF), Expected: `0xFF`},
+		{Name: "octal", Value: protowrite.Octal(8), Expected: `010`},
+		{Name: "octal zero", Value: protowrite.Octal(0), Expected: `0`},
+		{Name: "float", Value: 1.5, Expected: `1.5`},
+		{Name: "float32", Value: float32(0.1), Expected: `0.1`},
+		{Name: "large float", Value: 1e100, Expected: `1e+100`},
+		{Name: "inf", Value: math.Inf(1), Expected: `inf`},
+		{Name: "negative inf", Value: math.Inf(-1), Expected: `-inf`},
+		{Name: "nan", Value: math.NaN(), Expected: `nan`},
+		{
+			Name: "message literal with lists",
+			Value: b.MessageLiteral().
+				Field("names", []string{"a", "b"}).
+				Field("ids", []interface{}{1, protowrite.Hex(2)}).
+				Field("empty", []int{}).
+				MustBuild(),
+			Expected: "{\n    names: [\"a\", \"b\"]\n    ids: [1, 0x2]\n    empty: []\n}",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			s, err := marshal(t, tc.Value)
+			require.NoError(t, err, `protowrite.Marshal should succeed`)
+			require.Equal(t, tc.Expected, s)
+		})
+	}
+
+	t.Run("Unsupported values", func(t *testing.T) {
+		for _, value := range []interface{}{
+			nil,
+			map[string]int{"a": 1},
+			struct{ A int }{A: 1},
+			[]string{"list", "outside", "message", "literal"},
+			b.MessageLiteral().Field("nested", [][]int{{1}}).MustBuild(),
+			b.MessageLiteral().Field("channel", make(chan int)).MustBuild(),
+		} {
+			_, err := marshal(t, value)
+			require.Error(t, err, `protowrite.Marshal should fail for %T`, value)
+		}
+	})
+	t.Run("Round trip", func(t *testing.T) {
+		values := []interface{}{"a\"b\\c\n\t\x00\xff", "héllo", true, -42, 1.5}
+		for _, value := range values {
+			file, err := b.File().Package(`foo.bar`).Option("(value)", value).Build()
+			require.NoError(t, err, `builder.Build should succeed`)
+			buf, err := protowrite.Marshal(file)
+			require.NoError(t, err, `protowrite.Marshal should succeed`)
+
+			parsed, err := protowrite.Unmarshal(buf)
+			require.NoError(t, err, `protowrite.Unmarshal should succeed`)
+			require.Equal(t, value, parsed.Options[0].Value)
+		}
+	})
+}
@@ -0,0 +1,293 @@
+package protowrite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultIndent is the indentation used when WithIndent is not specified
+const DefaultIndent = "    "
+
+// LineEnding specifies the characters that end each line
+type LineEnding int
+
+const (
+	// LineEndingLF ends lines with "\n". This is the default
+	LineEndingLF LineEnding = iota
+	// LineEndingCRLF ends lines with "\r\n"
+	LineEndingCRLF
+)
+
+// QuoteStyle specifies the quotation mark used for string literals
+type QuoteStyle int
+
+const (
+	// QuoteDouble writes string literals as "foo". This is the default
+	QuoteDouble QuoteStyle = iota
+	// QuoteSingle writes string literals as 'foo'
+	QuoteSingle
+)
+
+func (q QuoteStyle) char() byte {
+	if q == QuoteSingle {
+		return '\''
+	}
+	return '"'
+}
+
+type marshalOptions struct {
+	fullyQualifiedNames bool
+	indent              string
+	lineEnding          LineEnding
+	blankLines          int
+	trailingNewline     bool
+	quote               QuoteStyle
+	ordering            Ordering
+	sort                SortMode
+	syntax              Syntax
+	edition             Edition
+}
+
+func newMarshalOptions(options []MarshalOption) (*marshalOptions, error) {
+	mo := &marshalOptions{
+		indent:     DefaultIndent,
+		blankLines: 1,
+	}
+	for _, option := range options {
+		option(mo)
+	}
+	if strings.Trim(mo.indent, " \t") != "" {
+		return nil, fmt.Errorf(`invalid indent %q: only spaces and tabs are allowed`, mo.indent)
+	}
+	if mo.blankLines < 0 {
+		return nil, fmt.Errorf(`invalid number of blank lines %d`, mo.blankLines)
+	}
+	switch mo.lineEnding {
+	case LineEndingLF, LineEndingCRLF:
+	default:
+		return nil, fmt.Errorf(`unknown line ending %d`, mo.lineEnding)
+	}
+	switch mo.quote {
+	case QuoteDouble, QuoteSingle:
+	default:
+		return nil, fmt.Errorf(`unknown quote style %d`, mo.quote)
+	}
+	switch mo.ordering {
+	case OrderGrouped, OrderSource:
+	default:
+		return nil, fmt.Errorf(`unknown ordering %d`, mo.ordering)
+	}
+	switch mo.syntax {
+	case SyntaxProto2, SyntaxProto3:
+		if mo.edition != "" {
+			return nil, fmt.Errorf(`edition %q specified with %s syntax`, mo.edition, mo.syntax)
+		}
+	case SyntaxEditions:
+	default:
+		return nil, fmt.Errorf(`unknown syntax %s`, mo.syntax)
+	}
+	if mo.sort&^SortAll != 0 {
+		return nil, fmt.Errorf(`unknown sort mode %d`, mo.sort)
+	}
+	if mo.sort != 0 && mo.ordering != OrderGrouped {
+		return nil, fmt.Errorf(`sorting is only supported with OrderGrouped`)
+	}
+	return mo, nil
+}
+
+// MarshalOption configures how Marshal and Encoder write a File. The
+// options only apply to the call they are passed to, so Files may be
+// written with different settings concurrently
+type MarshalOption func(*marshalOptions)
+
+// WithFullyQualifiedNames specifies that references to messages, enums and
+// external types should be written as fully-qualified names with a leading
+// dot, instead of the shortest name that refers to them
+func WithFullyQualifiedNames(v bool) MarshalOption {
+	return func(o *marshalOptions) {
+		o.fullyQualifiedNames = v
+	}
+}
+
+// WithIndent specifies the string used for each level of indentation.
+// It may only contain spaces and tabs. The default is DefaultIndent
+func WithIndent(s string) MarshalOption {
+	return func(o *marshalOptions) {
+		o.indent = s
+	}
+}
+
+// WithLineEnding specifies the characters that end each line
+func WithLineEnding(v LineEnding) MarshalOption {
+	return func(o *marshalOptions) {
+		o.lineEnding = v
+	}
+}
+
+// WithBlankLines specifies the number of blank lines written between
+// top-level declarations. The default is 1
+func WithBlankLines(n int) MarshalOption {
+	return func(o *marshalOptions) {
+		o.blankLines = n
+	}
+}
+
+// WithTrailingNewline specifies that the output should end with a newline
+func WithTrailingNewline(v bool) MarshalOption {
+	return func(o *marshalOptions) {
+		o.trailingNewline = v
+	}
+}
+
+// WithQuoteStyle specifies the quotation mark used for string literals
+func WithQuoteStyle(v QuoteStyle) MarshalOption {
+	return func(o *marshalOptions) {
+		o.quote = v
+	}
+}
+
+// WithSyntax specifies the syntax used by MarshalNode to write a
+// declaration, which decides how field labels, reserved names and
+// features are written. The default is SyntaxProto3. Files are always
+// written using their own syntax
+func WithSyntax(v Syntax) MarshalOption {
+	return func(o *marshalOptions) {
+		o.syntax = v
+	}
+}
+
+// WithEdition specifies that MarshalNode writes declarations using the
+// given edition. It implies WithSyntax(SyntaxEditions)
+func WithEdition(v Edition) MarshalOption {
+	return func(o *marshalOptions) {
+		o.syntax = SyntaxEditions
+		o.edition = v
+	}
+}
+
+// WriteError is returned when writing to the destination of an Encoder fails
+type WriteError struct {
+	// Path is the fully-qualified name of the declaration that was being
+	// written, such as `foo.bar.Message.kind`. The output is buffered, so
+	// the write that failed may have been flushed while writing this
+	// declaration, and may have included the end of the ones before it
+	Path string
+	Err  error
+}
+
+func (e *WriteError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// Marshal returns the protobuf source for f
+func Marshal(f *File, options ...MarshalOption) ([]byte, error) {
+	var dst bytes.Buffer
+	if err := NewEncoder(&dst, options...).Encode(f); err != nil {
+		return nil, err
+	}
+	return dst.Bytes(), nil
+}
+
+// Node is a declaration that can be written on its own using MarshalNode.
+// It is implemented by *Message, *Enum and *Service
+type Node interface {
+	encoder
+	isNode()
+}
+
+func (*Message) isNode() {}
+func (*Enum) isNode()    {}
+func (*Service) isNode() {}
+
+// MarshalNode returns the protobuf source for a single declaration, such
+// as a message, without the surrounding file. Messages and enums nested
+// in node may be referred to by its fields, and are written relative to
+// node. Other messages and enums that node refers to are treated as
+// top-level declarations. The declaration is written using proto3 syntax,
+// unless WithSyntax or WithEdition is given
+func MarshalNode(node Node, options ...MarshalOption) ([]byte, error) {
+	mo, err := newMarshalOptions(options)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to write protobuf: %w`, err)
+	}
+
+	f := File{Syntax: mo.syntax, Edition: mo.edition}
+	switch node := node.(type) {
+	case *Message:
+		f.Messages = []*Message{node}
+	case *Enum:
+		f.Enums = []*Enum{node}
+	}
+
+	var dst bytes.Buffer
+	e := newEncodeState(&dst, mo)
+	e.syntax = f.Syntax
+	if f.Syntax == SyntaxEditions {
+		defaults, err := editionDefaults(f.edition())
+		if err != nil {
+			return nil, fmt.Errorf(`failed to write protobuf: %w`, err)
+		}
+		e.features = defaults
+	}
+	e.typeNames = newTypeNames(&f)
+	e.typeNames.addReferences(node)
+	e.typeNames.fullyQualified = mo.fullyQualifiedNames
+	if err := node.encode(e); err != nil {
+		return nil, fmt.Errorf(`failed to write protobuf: %w`, err)
+	}
+	if mo.trailingNewline {
+		e.WriteByte('\n')
+	}
+	if err := e.flush(); err != nil {
+		return nil, fmt.Errorf(`failed to write protobuf: %w`, err)
+	}
+	// the declaration starts on a new line of its own
+	buf := dst.Bytes()
+	if mo.lineEnding == LineEndingCRLF {
+		return bytes.TrimPrefix(buf, []byte("\r\n")), nil
+	}
+	return bytes.TrimPrefix(buf, []byte("\n")), nil
+}
+
+// Encoder writes Files to an io.Writer
+type Encoder struct {
+	dst     io.Writer
+	options []MarshalOption
+}
+
+// NewEncoder creates an Encoder that writes to dst using the given options
+func NewEncoder(dst io.Writer, options ...MarshalOption) *Encoder {
+	return &Encoder{dst: dst, options: options}
+}
+
+// Encode writes f to the Encoder's destination as it is being generated.
+// The output is buffered, and writing stops at the first error returned
+// by the destination. The error is reported as a *WriteError holding the
+// path of the declaration that was being written when it occurred
+func (e *Encoder) Encode(f *File) error {
+	mo, err := newMarshalOptions(e.options)
+	if err != nil {
+		return fmt.Errorf(`failed to write protobuf: %w`, err)
+	}
+
+	state := newEncodeState(e.dst, mo)
+	if err := f.encode(state); err != nil {
+		return fmt.Errorf(`failed to write protobuf: %w`, err)
+	}
+	if mo.trailingNewline {
+		state.WriteByte('\n')
+	}
+	if err := state.flush(); err != nil {
+		return fmt.Errorf(`failed to write protobuf: %w`, err)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package protowrite_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lestrrat-go/protowrite"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalOptions(t *testing.T) {
+	var b protowrite.Builder
+
+	file := b.File().
+		Package(`foo.bar`).
+		Import("other.proto", protowrite.ImportDefault).
+		Option("java_package", "it's \"quoted\"").
+		Messages(
+			b.Message("Message").
+				LeadingComment("Message is\na message").
+				StringField("name", 1).
+				MustBuild(),
+			b.Message("Empty").MustBuild(),
+		).
+		MustBuild()
+
+	t.Run("Defaults", func(t *testing.T) {
+		buf, err := protowrite.Marshal(file)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Equal(t, `syntax = "proto3";
+
+package foo.bar;
+
+import "other.proto";
+
+option java_package = "it's \"quoted\"";
+
+// Message is
+// a message
+message Message {
+    string name = 1;
+}
+
+message Empty {
+}`, string(buf))
+	})
+	t.Run("Custom", func(t *testing.T) {
+		buf, err := protowrite.Marshal(file,
+			protowrite.WithIndent("\t"),
+			protowrite.WithLineEnding(protowrite.LineEndingCRLF),
+			protowrite.WithBlankLines(2),
+			protowrite.WithTrailingNewline(true),
+			protowrite.WithQuoteStyle(protowrite.QuoteSingle),
+		)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		expected := strings.ReplaceAll(`syntax = 'proto3';
+
+
+package foo.bar;
+
+
+import 'other.proto';
+
+
+option java_package = 'it\'s "quoted"';
+
+
+// Message is
+// a message
+message Message {
+	string name = 1;
+}
+
+
+message Empty {
+}
+`, "\n", "\r\n")
+		require.Equal(t, expected, string(buf))
+
+		parsed, err := protowrite.Unmarshal(buf)
+		require.NoError(t, err, `protowrite.Unmarshal should succeed`)
+		require.Equal(t, "it's \"quoted\"", parsed.Options[0].Value)
+	})
+	t.Run("No blank lines", func(t *testing.T) {
+		buf, err := protowrite.Marshal(b.File().Package(`foo.bar`).Messages(b.Message("Empty").MustBuild()).MustBuild(),
+			protowrite.WithBlankLines(0),
+		)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Equal(t, "syntax = \"proto3\";\npackage foo.bar;\nmessage Empty {\n}", string(buf))
+	})
+	t.Run("Invalid options", func(t *testing.T) {
+		for _, option := range []protowrite.MarshalOption{
+			protowrite.WithIndent("--"),
+			protowrite.WithBlankLines(-1),
+			protowrite.WithLineEnding(protowrite.LineEnding(42)),
+			protowrite.WithQuoteStyle(protowrite.QuoteStyle(42)),
+		} {
+			_, err := protowrite.Marshal(file, option)
+			require.Error(t, err, `protowrite.Marshal should fail`)
+		}
+	})
+	t.Run("Encoder", func(t *testing.T) {
+		expected, err := protowrite.Marshal(file, protowrite.WithIndent("  "))
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+
+		var buf bytes.Buffer
+		require.NoError(t, protowrite.NewEncoder(&buf, protowrite.WithIndent("  ")).Encode(file), `Encode should succeed`)
+		require.Equal(t, string(expected), buf.String())
+	})
+	t.Run("Concurrent", func(t *testing.T) {
+		indents := []string{"\t", "  ", "    "}
+		var wg sync.WaitGroup
+		for i := 0; i < 30; i++ {
+			indent := indents[i%len(indents)]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf, err := protowrite.Marshal(file, protowrite.WithIndent(indent))
+				require.NoError(t, err, `protowrite.Marshal should succeed`)
+				require.Contains(t, string(buf), "\n"+indent+"string name = 1;\n")
+			}()
+		}
+		wg.Wait()
+	})
+	t.Run("Write error", func(t *testing.T) {
+		// the output is buffered, so the file must be large enough for
+		// the buffer to be flushed while its fields are being written
+		large := largeFile(1, 500)
+		expected, err := protowrite.Marshal(large)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+
+		const limit = 100
+		w := &limitWriter{limit: limit}
+		err = protowrite.NewEncoder(w).Encode(large)
+		require.Error(t, err, `Encode should fail`)
+
+		var werr *protowrite.WriteError
+		require.True(t, errors.As(err, &werr), `error should be a *protowrite.WriteError`)
+		require.Regexp(t, `^foo\.bar\.Message0\.field_\d+$`, werr.Path)
+		require.True(t, errors.Is(err, errLimit), `error should wrap the writer's error`)
+		require.Equal(t, string(expected[:limit]), w.buf.String(), `nothing should be written after the failure`)
+		require.Equal(t, 1, w.failures, `writing should stop at the first failure`)
+	})
+}
+
+var errLimit = errors.New("limit reached")
+
+// limitWriter accepts up to limit bytes, and fails afterwards
+type limitWriter struct {
+	buf      bytes.Buffer
+	limit    int
+	failures int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.limit {
+		w.failures++
+		n := w.limit - w.buf.Len()
+		w.buf.Write(p[:n])
+		return n, errLimit
+	}
+	return w.buf.Write(p)
+}
+
+func TestMarshalNode(t *testing.T) {
+	var b protowrite.Builder
+
+	inner := b.Message("Inner").StringField("name", 1).MustBuild()
+	kind := b.Enum("Kind").Element("KIND_UNSPECIFIED", 0).MustBuild()
+	msg := b.Message("Outer").
+		LeadingComment("Outer is a message").
+		Messages(inner).
+		Enums(kind).
+		TypedField(inner, "inner", 1).
+		TypedField(kind, "kind", 2).
+		MustBuild()
+
+	t.Run("Message", func(t *testing.T) {
+		buf, err := protowrite.MarshalNode(msg)
+		require.NoError(t, err, `protowrite.MarshalNode should succeed`)
+		require.Equal(t, `// Outer is a message
+message Outer {
+    enum Kind {
+        KIND_UNSPECIFIED = 0;
+    }
+    message Inner {
+        string name = 1;
+    }
+    Inner inner = 1;
+    Kind kind = 2;
+}`, string(buf))
+	})
+	t.Run("Enum", func(t *testing.T) {
+		buf, err := protowrite.MarshalNode(kind, protowrite.WithIndent("\t"), protowrite.WithTrailingNewline(true))
+		require.NoError(t, err, `protowrite.MarshalNode should succeed`)
+		require.Equal(t, "enum Kind {\n\tKIND_UNSPECIFIED = 0;\n}\n", string(buf))
+	})
+	t.Run("Service", func(t *testing.T) {
+		svc := b.Service("FooService").Method("Bar", "Outer", "Outer").MustBuild()
+		buf, err := protowrite.MarshalNode(svc)
+		require.NoError(t, err, `protowrite.MarshalNode should succeed`)
+		require.Equal(t, "service FooService {\n    rpc Bar(Outer) returns (Outer);\n}", string(buf))
+	})
+	t.Run("Syntax", func(t *testing.T) {
+		proto2 := b.Message("Legacy").
+			Fields(b.Field("string", "id", 1).Cardinality(protowrite.CardinalityRequired).MustBuild()).
+			ExtensionRange(100, 199).
+			MustBuild()
+		_, err := protowrite.MarshalNode(proto2)
+		require.Error(t, err, `protowrite.MarshalNode should fail with proto3 syntax`)
+
+		buf, err := protowrite.MarshalNode(proto2, protowrite.WithSyntax(protowrite.SyntaxProto2))
+		require.NoError(t, err, `protowrite.MarshalNode should succeed`)
+		require.Equal(t, "message Legacy {\n    extensions 100 to 199;\n    required string id = 1;\n}", string(buf))
+
+		buf, err = protowrite.MarshalNode(b.Message("Reserved").ReserveNames("old").MustBuild(), protowrite.WithEdition(protowrite.Edition2023))
+		require.NoError(t, err, `protowrite.MarshalNode should succeed`)
+		require.Equal(t, "message Reserved {\n    reserved old;\n}", string(buf))
+
+		_, err = protowrite.MarshalNode(proto2, protowrite.WithSyntax(protowrite.Syntax(42)))
+		require.Error(t, err, `protowrite.MarshalNode should fail with an unknown syntax`)
+	})
+	t.Run("Service with node references", func(t *testing.T) {
+		req := b.Message("Req").MustBuild()
+		svc := b.Service("FooService").
+			TypedMethod("Bar", req, msg).
+			MustBuild()
+		buf, err := protowrite.MarshalNode(svc)
+		require.NoError(t, err, `protowrite.MarshalNode should succeed`)
+		require.Equal(t, "service FooService {\n    rpc Bar(Req) returns (Outer);\n}", string(buf))
+
+		buf, err = protowrite.MarshalNode(svc, protowrite.WithFullyQualifiedNames(true))
+		require.NoError(t, err, `protowrite.MarshalNode should succeed`)
+		require.Equal(t, "service FooService {\n    rpc Bar(.Req) returns (.Outer);\n}", string(buf))
+	})
+	t.Run("Message with node references", func(t *testing.T) {
+		wrapper := b.Message("Wrapper").TypedField(inner, "inner", 1).MustBuild()
+		buf, err := protowrite.MarshalNode(wrapper)
+		require.NoError(t, err, `protowrite.MarshalNode should succeed`)
+		require.Equal(t, "message Wrapper {\n    Inner inner = 1;\n}", string(buf))
+	})
+}
+
+// largeFile builds a synthetic file with messages*fields fields, along
+// with nested enums, comments and options
+func largeFile(messages, fields int) *protowrite.File {
+	var b protowrite.Builder
+
+	fb := b.File().Package(`foo.bar`)
+	for i := 0; i < messages; i++ {
+		kind := b.Enum("Kind").
+			Element("KIND_UNSPECIFIED", 0).
+			Element("KIND_PRIMARY", 1).
+			MustBuild()
+		mb := b.Message(fmt.Sprintf("Message%d", i)).
+			LeadingComment(fmt.Sprintf("Message%d is a generated message", i)).
+			Enums(kind)
+		for j := 1; j <= fields; j++ {
+			name := fmt.Sprintf("field_%d", j)
+			switch j % 4 {
+			case 0:
+				mb.StringField(name, j)
+			case 1:
+				mb.TypedField(kind, name, j)
+			case 2:
+				mb.Fields(b.Field("uint64", name, j).TrailingComment("a trailing comment").MustBuild())
+			case 3:
+				mb.Fields(b.Field("string", name, j).Deprecated(true).JSONName(fmt.Sprintf("f%d", j)).MustBuild())
+			}
+		}
+		fb.Messages(mb.MustBuild())
+	}
+	return fb.MustBuild()
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	file := largeFile(500, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := protowrite.Marshal(file); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	file := largeFile(500, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := protowrite.NewEncoder(io.Discard).Encode(file); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
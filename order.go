@@ -0,0 +1,376 @@
+package protowrite
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// Ordering specifies the order in which the declarations in the body of
+// a File or a Message are written
+type Ordering int
+
+const (
+	// OrderGrouped writes declarations grouped by kind. Messages start
+	// with their oneofs, followed by extensions, options, reserved
+	// statements, extension ranges, enums, nested messages and fields.
+	// Files start with their imports, followed by options, extensions,
+	// messages, enums and services. This is the default
+	OrderGrouped Ordering = iota
+	// OrderSource writes declarations in the order they are listed in the
+	// Body of their File or Message, which is the order in which they were
+	// added using the builders, or the order in which they appear in a
+	// parsed file. Declarations that are not listed in Body are written
+	// after those that are, grouped by kind
+	OrderSource
+)
+
+// WithOrdering specifies the order in which declarations are written.
+// The default is OrderGrouped
+func WithOrdering(v Ordering) MarshalOption {
+	return func(o *marshalOptions) {
+		o.ordering = v
+	}
+}
+
+// SortMode specifies which declarations are sorted when they are
+// written, so that the output does not depend on the order in which
+// they were added. Sorting is stable, and only applies to OrderGrouped
+type SortMode int
+
+const (
+	// SortDeclarations sorts messages, enums, services, oneofs and
+	// methods by name, and extensions by the name of the extended message
+	SortDeclarations SortMode = 1 << iota
+	// SortFields sorts fields by number
+	SortFields
+	// SortEnumValues sorts enum values by value
+	SortEnumValues
+	// SortImports sorts imports by path, writing regular imports first,
+	// followed by public and weak imports
+	SortImports
+
+	// SortAll enables every sort mode, which writes a File in a
+	// canonical order
+	SortAll = SortDeclarations | SortFields | SortEnumValues | SortImports
+)
+
+// WithSort specifies which declarations are sorted when they are written.
+// By default, nothing is sorted
+func WithSort(v SortMode) MarshalOption {
+	return func(o *marshalOptions) {
+		o.sort = v
+	}
+}
+
+// sorts returns true if any of the declarations in mode are sorted
+func (e *encodeState) sorts(mode SortMode) bool {
+	return e.options.sort&mode != 0
+}
+
+// sorted returns a sorted copy of list. list itself is never modified,
+// as it belongs to the caller of Marshal
+func sorted[T any](list []T, compare func(a, b T) int) []T {
+	if len(list) < 2 {
+		return list
+	}
+	list = slices.Clone(list)
+	slices.SortStableFunc(list, compare)
+	return list
+}
+
+func (e *encodeState) sortMessages(list []*Message) []*Message {
+	if !e.sorts(SortDeclarations) {
+		return list
+	}
+	return sorted(list, func(a, b *Message) int { return strings.Compare(a.Name, b.Name) })
+}
+
+func (e *encodeState) sortEnums(list []*Enum) []*Enum {
+	if !e.sorts(SortDeclarations) {
+		return list
+	}
+	return sorted(list, func(a, b *Enum) int { return strings.Compare(a.Name, b.Name) })
+}
+
+func (e *encodeState) sortServices(list []*Service) []*Service {
+	if !e.sorts(SortDeclarations) {
+		return list
+	}
+	return sorted(list, func(a, b *Service) int { return strings.Compare(a.Name, b.Name) })
+}
+
+func (e *encodeState) sortOneOfs(list []*OneOf) []*OneOf {
+	if !e.sorts(SortDeclarations) {
+		return list
+	}
+	return sorted(list, func(a, b *OneOf) int { return strings.Compare(a.Name, b.Name) })
+}
+
+func (e *encodeState) sortMethods(list []*Method) []*Method {
+	if !e.sorts(SortDeclarations) {
+		return list
+	}
+	return sorted(list, func(a, b *Method) int { return strings.Compare(a.Name, b.Name) })
+}
+
+func (e *encodeState) sortExtensions(list []*Extension) []*Extension {
+	if !e.sorts(SortDeclarations) {
+		return list
+	}
+	name := func(ext *Extension) string {
+		if ext.Target == nil {
+			return strings.TrimPrefix(ext.Name, ".")
+		}
+		if full, err := e.typeNames.fullName(ext.Target); err == nil {
+			return full
+		}
+		return strings.TrimPrefix(typeString(ext.Target), ".")
+	}
+	return sorted(list, func(a, b *Extension) int { return strings.Compare(name(a), name(b)) })
+}
+
+func (e *encodeState) sortFields(list []*Field) []*Field {
+	if !e.sorts(SortFields) {
+		return list
+	}
+	return sorted(list, func(a, b *Field) int { return cmp.Compare(a.ID, b.ID) })
+}
+
+func (e *encodeState) sortEnumValues(list []*EnumElement) []*EnumElement {
+	if !e.sorts(SortEnumValues) {
+		return list
+	}
+	return sorted(list, func(a, b *EnumElement) int { return cmp.Compare(a.Value, b.Value) })
+}
+
+func (e *encodeState) sortImports(list []*Import) []*Import {
+	if !e.sorts(SortImports) {
+		return list
+	}
+	return sorted(list, func(a, b *Import) int {
+		if c := cmp.Compare(a.Type, b.Type); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+}
+
+// BodyElement is a declaration that appears in the body of a File or a
+// Message. It is implemented by *Field, *OneOf, *Message, *Enum,
+// *Extension, *ExtensionRange, *Option, *Reserved, *Import and *Service
+type BodyElement interface {
+	encoder
+	isBodyElement()
+}
+
+func (*Field) isBodyElement()          {}
+func (*OneOf) isBodyElement()          {}
+func (*Message) isBodyElement()        {}
+func (*Enum) isBodyElement()           {}
+func (*Extension) isBodyElement()      {}
+func (*ExtensionRange) isBodyElement() {}
+func (*Option) isBodyElement()         {}
+func (*Import) isBodyElement()         {}
+func (*Service) isBodyElement()        {}
+func (*Reserved) isBodyElement()       {}
+
+// declarationKind describes v for use in error messages
+func declarationKind(v encoder) string {
+	switch v.(type) {
+	case *Field:
+		return "field declaration"
+	case *OneOf:
+		return "oneof declaration"
+	case *Message:
+		return "message declaration"
+	case *Enum:
+		return "enum declaration"
+	case *Extension:
+		return "extension declaration"
+	case *ExtensionRange:
+		return "extension range"
+	case *Option:
+		return "option declaration"
+	case *Import:
+		return "import statement"
+	case *Service:
+		return "service declaration"
+	case *Reserved:
+		return "reserved statement"
+	default:
+		return "declaration"
+	}
+}
+
+// appendSourceOrder appends the declarations listed in grouped to list,
+// starting with those listed in body in the same order. Elements of body
+// that are not in grouped have been removed from their File or Message,
+// and are not written
+func appendSourceOrder(list []encoder, body []BodyElement, grouped []encoder) []encoder {
+	declared := make(map[encoder]bool, len(grouped))
+	for _, v := range grouped {
+		declared[v] = false
+	}
+	for _, v := range body {
+		if written, ok := declared[v]; ok && !written {
+			list = append(list, v)
+			declared[v] = true
+		}
+	}
+	for _, v := range grouped {
+		if !declared[v] {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// body returns the declarations in the body of the message in the order
+// they are written, including the options needed to express its features
+func (m *Message) body(e *encodeState, features []*Option) []encoder {
+	if e.options.ordering != OrderSource || len(m.Body) == 0 {
+		var reserved encoder
+		if len(m.ReservedRanges) > 0 || len(m.ReservedNames) > 0 {
+			reserved = &Reserved{Ranges: m.ReservedRanges, Names: m.ReservedNames}
+		}
+		return m.grouped(e, features, reserved)
+	}
+
+	// reserved statements listed in Body are written where they appear,
+	// with the ranges and names that the message still reserves. Those
+	// that are left over are written with the other declarations that
+	// are not listed in Body
+	ranges := slices.Clone(m.ReservedRanges)
+	names := slices.Clone(m.ReservedNames)
+	body := make([]BodyElement, 0, len(m.Body))
+	var statements []encoder
+	for _, v := range m.Body {
+		r, ok := v.(*Reserved)
+		if !ok {
+			body = append(body, v)
+			continue
+		}
+		statement := &Reserved{}
+		for _, rng := range r.Ranges {
+			if i := slices.Index(ranges, rng); i >= 0 {
+				statement.Ranges = append(statement.Ranges, rng)
+				ranges = slices.Delete(ranges, i, i+1)
+			}
+		}
+		for _, name := range r.Names {
+			if i := slices.Index(names, name); i >= 0 {
+				statement.Names = append(statement.Names, name)
+				names = slices.Delete(names, i, i+1)
+			}
+		}
+		if len(statement.Ranges) > 0 || len(statement.Names) > 0 {
+			body = append(body, statement)
+			statements = append(statements, statement)
+		}
+	}
+	var reserved encoder
+	if len(ranges) > 0 || len(names) > 0 {
+		reserved = &Reserved{Ranges: ranges, Names: names}
+	}
+
+	// features are not listed in Body, and are written first
+	list := make([]encoder, 0, len(features)+len(m.Body)+1)
+	for _, v := range features {
+		list = append(list, v)
+	}
+	return appendSourceOrder(list, body, append(statements, m.grouped(e, nil, reserved)...))
+}
+
+// grouped returns the declarations in the body of the message grouped
+// by kind, sorting them if requested
+func (m *Message) grouped(e *encodeState, features []*Option, reserved encoder) []encoder {
+	list := make([]encoder, 0, len(m.OneOfs)+len(m.Extensions)+len(features)+len(m.Options)+1+len(m.ExtensionRanges)+len(m.Enums)+len(m.Messages)+len(m.Fields))
+	for _, v := range e.sortOneOfs(m.OneOfs) {
+		list = append(list, v)
+	}
+	for _, v := range e.sortExtensions(m.Extensions) {
+		list = append(list, v)
+	}
+	for _, v := range features {
+		list = append(list, v)
+	}
+	for _, v := range m.Options {
+		list = append(list, v)
+	}
+	if reserved != nil {
+		list = append(list, reserved)
+	}
+	for _, v := range m.ExtensionRanges {
+		list = append(list, v)
+	}
+	for _, v := range e.sortEnums(m.Enums) {
+		list = append(list, v)
+	}
+	for _, v := range e.sortMessages(m.Messages) {
+		list = append(list, v)
+	}
+	for _, v := range e.sortFields(m.Fields) {
+		list = append(list, v)
+	}
+	return list
+}
+
+// body returns the declarations in the file in the order they are
+// written, including the options needed to express its features
+func (f *File) body(e *encodeState, features []*Option) []encoder {
+	if e.options.ordering != OrderSource || len(f.Body) == 0 {
+		return f.grouped(e, features)
+	}
+
+	// features are not listed in Body, and are written first
+	list := make([]encoder, 0, len(features)+len(f.Body))
+	for _, v := range features {
+		list = append(list, v)
+	}
+	return appendSourceOrder(list, f.Body, f.grouped(e, nil))
+}
+
+// grouped returns the declarations in the file grouped by kind, sorting
+// them if requested
+func (f *File) grouped(e *encodeState, features []*Option) []encoder {
+	list := make([]encoder, 0, len(f.Imports)+len(features)+len(f.Options)+len(f.Extensions)+len(f.Messages)+len(f.Enums)+len(f.Services))
+	for _, v := range e.sortImports(f.Imports) {
+		list = append(list, v)
+	}
+	for _, v := range features {
+		list = append(list, v)
+	}
+	for _, v := range f.Options {
+		list = append(list, v)
+	}
+	for _, v := range e.sortExtensions(f.Extensions) {
+		list = append(list, v)
+	}
+	for _, v := range e.sortMessages(f.Messages) {
+		list = append(list, v)
+	}
+	for _, v := range e.sortEnums(f.Enums) {
+		list = append(list, v)
+	}
+	for _, v := range e.sortServices(f.Services) {
+		list = append(list, v)
+	}
+	return list
+}
+
+// separated returns true if a blank line is written between the
+// top-level declarations prev and v. Consecutive imports and options
+// are written as a single block
+func separated(prev, v encoder) bool {
+	switch v.(type) {
+	case *Import:
+		_, ok := prev.(*Import)
+		return !ok
+	case *Option:
+		_, ok := prev.(*Option)
+		return !ok
+	default:
+		return true
+	}
+}
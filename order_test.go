@@ -0,0 +1,336 @@
+package protowrite_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/protowrite"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrdering(t *testing.T) {
+	var b protowrite.Builder
+
+	newFile := func() *protowrite.File {
+		return b.File().
+			Syntax(protowrite.SyntaxProto2).
+			Package(`foo.bar`).
+			Messages(
+				b.Message("Message").
+					StringField("name", 1).
+					OneOfs(
+						b.OneOf("id").
+							StringField("key", 2).
+							Uint64Field("num", 3).
+							MustBuild(),
+					).
+					Option("deprecated", true).
+					Messages(b.Message("Nested").MustBuild()).
+					Uint64Field("count", 4).
+					ReserveNumbers(10).
+					ExtensionRange(100, 200).
+					MustBuild(),
+			).
+			Import("a.proto", protowrite.ImportDefault).
+			Option("java_package", "com.example").
+			Enums(
+				b.Enum("Kind").
+					Element("KIND_UNSPECIFIED", 0).
+					MustBuild(),
+			).
+			Option("go_package", "example.com/foo").
+			Import("b.proto", protowrite.ImportPublic).
+			MustBuild()
+	}
+
+	const grouped = `syntax = "proto2";
+
+package foo.bar;
+
+import "a.proto";
+import public "b.proto";
+
+option java_package = "com.example";
+option go_package = "example.com/foo";
+
+message Message {
+    oneof id {
+        string key = 2;
+        uint64 num = 3;
+    }
+    option deprecated = true;
+    reserved 10;
+    extensions 100 to 200;
+    message Nested {
+    }
+    optional string name = 1;
+    optional uint64 count = 4;
+}
+
+enum Kind {
+    KIND_UNSPECIFIED = 0;
+}`
+
+	const source = `syntax = "proto2";
+
+package foo.bar;
+
+message Message {
+    optional string name = 1;
+    oneof id {
+        string key = 2;
+        uint64 num = 3;
+    }
+    option deprecated = true;
+    message Nested {
+    }
+    optional uint64 count = 4;
+    reserved 10;
+    extensions 100 to 200;
+}
+
+import "a.proto";
+
+option java_package = "com.example";
+
+enum Kind {
+    KIND_UNSPECIFIED = 0;
+}
+
+option go_package = "example.com/foo";
+
+import public "b.proto";`
+
+	t.Run("Grouped", func(t *testing.T) {
+		buf, err := protowrite.Marshal(newFile())
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Equal(t, grouped, string(buf))
+
+		buf, err = protowrite.Marshal(newFile(), protowrite.WithOrdering(protowrite.OrderGrouped))
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Equal(t, grouped, string(buf))
+	})
+	t.Run("Source", func(t *testing.T) {
+		buf, err := protowrite.Marshal(newFile(), protowrite.WithOrdering(protowrite.OrderSource))
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Equal(t, source, string(buf))
+	})
+	t.Run("Parsed source", func(t *testing.T) {
+		file, err := protowrite.Unmarshal([]byte(source))
+		require.NoError(t, err, `protowrite.Unmarshal should succeed`)
+
+		buf, err := protowrite.Marshal(file, protowrite.WithOrdering(protowrite.OrderSource))
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Equal(t, source, string(buf))
+
+		buf, err = protowrite.Marshal(file)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Equal(t, grouped, string(buf))
+	})
+	t.Run("Declarations missing from Body", func(t *testing.T) {
+		file := newFile()
+		msg := file.Messages[0]
+		// remove "name", and add a field and a reserved name without
+		// recording them in Body
+		msg.Fields = append(msg.Fields[1:], protowrite.BoolField("extra", 5))
+		msg.ReservedNames = append(msg.ReservedNames, "old")
+
+		buf, err := protowrite.Marshal(file, protowrite.WithOrdering(protowrite.OrderSource))
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Contains(t, string(buf), `message Message {
+    oneof id {
+        string key = 2;
+        uint64 num = 3;
+    }
+    option deprecated = true;
+    message Nested {
+    }
+    optional uint64 count = 4;
+    reserved 10;
+    extensions 100 to 200;
+    reserved "old";
+    optional bool extra = 5;
+}`)
+
+		// reserved ranges removed from the message are not written
+		msg.ReservedRanges = nil
+		buf, err = protowrite.Marshal(file, protowrite.WithOrdering(protowrite.OrderSource))
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.NotContains(t, string(buf), `reserved 10;`)
+	})
+	t.Run("Parsed reserved statements", func(t *testing.T) {
+		for _, src := range []string{
+			`syntax = "proto3";
+
+message Message {
+    string name = 1;
+    reserved 2 to 5, 9 to max;
+    string label = 6;
+    reserved "old";
+}`,
+			`edition = "2023";
+
+message Message {
+    string name = 1;
+    reserved foo;
+}`,
+		} {
+			file, err := protowrite.Unmarshal([]byte(src))
+			require.NoError(t, err, `protowrite.Unmarshal should succeed`)
+
+			buf, err := protowrite.Marshal(file, protowrite.WithOrdering(protowrite.OrderSource))
+			require.NoError(t, err, `protowrite.Marshal should succeed`)
+			require.Equal(t, src, string(buf))
+		}
+	})
+	t.Run("Invalid ordering", func(t *testing.T) {
+		_, err := protowrite.Marshal(newFile(), protowrite.WithOrdering(protowrite.Ordering(42)))
+		require.Error(t, err, `protowrite.Marshal should fail`)
+	})
+}
+
+func TestSort(t *testing.T) {
+	var b protowrite.Builder
+
+	// newFile builds the same file, adding declarations in the given order
+	newFile := func(reverse bool) *protowrite.File {
+		order := func(n int) []int {
+			list := make([]int, n)
+			for i := range list {
+				list[i] = i
+				if reverse {
+					list[i] = n - 1 - i
+				}
+			}
+			return list
+		}
+
+		// proto2 allows enums whose first value is not zero
+		fb := b.File().Syntax(protowrite.SyntaxProto2).Package(`foo.bar`)
+		imports := []*protowrite.Import{
+			{Path: "z.proto"},
+			{Path: "a.proto", Type: protowrite.ImportWeak},
+			{Path: "b.proto", Type: protowrite.ImportPublic},
+			{Path: "m.proto"},
+		}
+		for _, i := range order(len(imports)) {
+			fb.Imports(imports[i])
+		}
+
+		kind := b.Enum("Kind")
+		values := []string{"KIND_UNSPECIFIED", "KIND_A", "KIND_B"}
+		for _, i := range order(len(values)) {
+			kind.Element(values[i], i)
+		}
+
+		user := b.Message("User")
+		fields := []string{"id", "name", "email"}
+		for _, i := range order(len(fields)) {
+			user.StringField(fields[i], i+1)
+		}
+		id := b.OneOf("contact")
+		for _, i := range order(2) {
+			id.StringField(fmt.Sprintf("contact_%d", i), i+10)
+		}
+		user.OneOfs(id.MustBuild(), b.OneOf("avatar").BytesField("image", 20).MustBuild())
+
+		declarations := []func(){
+			func() { fb.Messages(user.MustBuild()) },
+			func() { fb.Enums(kind.MustBuild()) },
+			func() { fb.Messages(b.Message("Address").StringField("city", 1).MustBuild()) },
+			func() {
+				fb.Services(b.Service("UserService").
+					Method("Get", "User", "User").
+					Method("Delete", "User", "User").
+					MustBuild())
+			},
+			func() { fb.Services(b.Service("AddressService").MustBuild()) },
+			func() { fb.Enums(b.Enum("Color").Element("COLOR_UNSPECIFIED", 0).MustBuild()) },
+		}
+		for _, i := range order(len(declarations)) {
+			declarations[i]()
+		}
+		return fb.MustBuild()
+	}
+
+	forward, backward := newFile(false), newFile(true)
+	expected, err := protowrite.Marshal(forward, protowrite.WithSort(protowrite.SortAll))
+	require.NoError(t, err, `protowrite.Marshal should succeed`)
+	require.Equal(t, `syntax = "proto2";
+
+package foo.bar;
+
+import "m.proto";
+import "z.proto";
+import public "b.proto";
+import weak "a.proto";
+
+message Address {
+    optional string city = 1;
+}
+
+message User {
+    oneof avatar {
+        bytes image = 20;
+    }
+    oneof contact {
+        string contact_0 = 10;
+        string contact_1 = 11;
+    }
+    optional string id = 1;
+    optional string name = 2;
+    optional string email = 3;
+}
+
+enum Color {
+    COLOR_UNSPECIFIED = 0;
+}
+
+enum Kind {
+    KIND_UNSPECIFIED = 0;
+    KIND_A = 1;
+    KIND_B = 2;
+}
+
+service AddressService {
+}
+
+service UserService {
+    rpc Delete(User) returns (User);
+    rpc Get(User) returns (User);
+}`, string(expected))
+
+	buf, err := protowrite.Marshal(backward, protowrite.WithSort(protowrite.SortAll))
+	require.NoError(t, err, `protowrite.Marshal should succeed`)
+	require.Equal(t, string(expected), string(buf), `output should not depend on the order of the builder calls`)
+
+	t.Run("Files are not modified", func(t *testing.T) {
+		require.Equal(t, "KIND_B", backward.Enums[1].Elements[0].Name)
+		require.Equal(t, "z.proto", forward.Imports[0].Path)
+	})
+	t.Run("Individual modes", func(t *testing.T) {
+		option := protowrite.WithSort(protowrite.SortFields | protowrite.SortEnumValues)
+		buf, err := protowrite.Marshal(forward, option)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		s := string(buf)
+		// declarations and imports keep the order in which they were added
+		require.Less(t, strings.Index(s, "message User"), strings.Index(s, "message Address"))
+		require.Less(t, strings.Index(s, `"z.proto"`), strings.Index(s, `"a.proto"`))
+
+		buf, err = protowrite.Marshal(backward, option)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		s = string(buf)
+		require.Less(t, strings.Index(s, "KIND_UNSPECIFIED"), strings.Index(s, "KIND_A"))
+		require.Less(t, strings.Index(s, "string id"), strings.Index(s, "string name"))
+	})
+	t.Run("Invalid modes", func(t *testing.T) {
+		for _, options := range [][]protowrite.MarshalOption{
+			{protowrite.WithSort(protowrite.SortMode(1 << 10))},
+			{protowrite.WithSort(protowrite.SortAll), protowrite.WithOrdering(protowrite.OrderSource)},
+		} {
+			_, err := protowrite.Marshal(forward, options...)
+			require.Error(t, err, `protowrite.Marshal should fail`)
+		}
+	})
+}
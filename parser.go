@@ -0,0 +1,1243 @@
+package protowrite
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseError is returned by Parse and Unmarshal when the input is not
+// a valid protobuf source file. Line and Column are 1-based, and
+// point to the location in the input where the problem was found
+type ParseError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf(`line %d, column %d: %s`, e.Line, e.Column, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parse reads a protobuf source file from src, and builds a File from it.
+func Parse(src io.Reader) (*File, error) {
+	buf, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read protobuf source: %w`, err)
+	}
+	return Unmarshal(buf)
+}
+
+// Unmarshal parses the protobuf source file in src, and builds a File from it.
+//
+// Constructs that cannot be represented by this package, such as groups,
+// result in an error. Comments are only preserved for the declarations
+// that can hold them.
+func Unmarshal(src []byte) (*File, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	f, err := p.parseFile()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type parser struct {
+	tokens []*token
+	pos    int
+	syntax Syntax
+}
+
+func (p *parser) peek() *token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekN(n int) *token {
+	if i := p.pos + n; i < len(p.tokens) {
+		return p.tokens[i]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *parser) next() *token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(tok *token, format string, args ...interface{}) error {
+	return &ParseError{Line: tok.Pos.Line, Column: tok.Pos.Column, Err: fmt.Errorf(format, args...)}
+}
+
+// accept consumes the next token if it is the given punctuation
+func (p *parser) accept(punct string) bool {
+	if p.peek().is(tokenPunct, punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) (*token, error) {
+	tok := p.next()
+	if !tok.is(tokenPunct, punct) {
+		return nil, p.errorf(tok, `expected %q, got %s`, punct, tok)
+	}
+	return tok, nil
+}
+
+func (p *parser) expectKeyword(kw string) (*token, error) {
+	tok := p.next()
+	if !tok.is(tokenIdent, kw) {
+		return nil, p.errorf(tok, `expected %q, got %s`, kw, tok)
+	}
+	return tok, nil
+}
+
+func (p *parser) ident() (string, error) {
+	tok := p.next()
+	if tok.Kind != tokenIdent {
+		return "", p.errorf(tok, `expected identifier, got %s`, tok)
+	}
+	return tok.Text, nil
+}
+
+func (p *parser) stringLiteral() (string, error) {
+	tok := p.next()
+	if tok.Kind != tokenString {
+		return "", p.errorf(tok, `expected string, got %s`, tok)
+	}
+	s := tok.Text
+	// adjacent string literals are concatenated
+	for p.peek().Kind == tokenString {
+		s += p.next().Text
+	}
+	return s, nil
+}
+
+// leadingComments returns the comments preceding tok. Consecutive line
+// comments are grouped into a single block. The block immediately
+// preceding tok is its leading comment, and the blocks separated from
+// it by a blank line are detached comments
+func leadingComments(tok *token) Comments {
+	var blocks []*sourceComment
+	for _, c := range tok.Comments {
+		if c.Trailing {
+			continue
+		}
+		if n := len(blocks); n > 0 && !c.Block && !blocks[n-1].Block && blocks[n-1].End.Line+1 == c.Start.Line {
+			last := blocks[n-1]
+			blocks[n-1] = &sourceComment{Text: last.Text + "\n" + c.Text, Start: last.Start, End: c.End}
+			continue
+		}
+		blocks = append(blocks, c)
+	}
+
+	var ret Comments
+	if n := len(blocks); n > 0 && blocks[n-1].End.Line+1 >= tok.Pos.Line {
+		ret.Leading = blocks[n-1].Text
+		if blocks[n-1].Block {
+			ret.Style = CommentStyleBlock
+		}
+		blocks = blocks[:n-1]
+	} else if n > 0 && blocks[0].Block {
+		ret.Style = CommentStyleBlock
+	}
+	for _, c := range blocks {
+		ret.Detached = append(ret.Detached, c.Text)
+	}
+	return ret
+}
+
+// trailingComment sets the trailing comment of c to the comment that
+// follows the last consumed token on the same line, if any
+func (p *parser) trailingComment(c *Comments) {
+	comments := p.peek().Comments
+	if len(comments) == 0 || !comments[0].Trailing {
+		return
+	}
+	c.Trailing = comments[0].Text
+	if c.Leading == "" && len(c.Detached) == 0 && comments[0].Block {
+		c.Style = CommentStyleBlock
+	}
+}
+
+func (p *parser) int() (int, error) {
+	tok := p.peek()
+	neg := p.accept("-")
+	numTok := p.next()
+	if numTok.Kind != tokenInt {
+		return 0, p.errorf(numTok, `expected integer, got %s`, numTok)
+	}
+	text := numTok.Text
+	if neg {
+		text = "-" + text
+	}
+	v, err := strconv.ParseInt(text, 0, 64)
+	if err != nil || v > math.MaxInt32 || v < math.MinInt32 {
+		return 0, p.errorf(tok, `integer %s is out of range`, text)
+	}
+	return int(v), nil
+}
+
+// typeName parses a possibly fully-qualified type name
+func (p *parser) typeName() (string, error) {
+	var sb strings.Builder
+	if p.accept(".") {
+		sb.WriteByte('.')
+	}
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(name)
+		if !p.accept(".") {
+			return sb.String(), nil
+		}
+		sb.WriteByte('.')
+	}
+}
+
+func (p *parser) parseFile() (*File, error) {
+	// files without a syntax statement are proto2
+	f := &File{Syntax: SyntaxProto2}
+
+	if tok := p.peek(); tok.is(tokenIdent, "syntax") || tok.is(tokenIdent, "edition") {
+		p.next()
+		// detached comments before the syntax statement, such as
+		// license banners, make up the file header
+		f.Comments = leadingComments(tok)
+		f.Header, f.Comments.Detached = f.Comments.Detached, nil
+		if _, err := p.expect("="); err != nil {
+			return nil, err
+		}
+		valTok := p.peek()
+		v, err := p.stringLiteral()
+		if err != nil {
+			return nil, err
+		}
+		if tok.Text == "edition" {
+			f.Syntax = SyntaxEditions
+			f.Edition = Edition(v)
+		} else {
+			switch v {
+			case "proto2":
+				f.Syntax = SyntaxProto2
+			case "proto3":
+				f.Syntax = SyntaxProto3
+			default:
+				return nil, p.errorf(valTok, `unknown syntax %q`, v)
+			}
+		}
+		if _, err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		p.trailingComment(&f.Comments)
+	}
+	p.syntax = f.Syntax
+
+	for {
+		tok := p.peek()
+		switch {
+		case tok.Kind == tokenEOF:
+			return f, nil
+		case tok.is(tokenPunct, ";"):
+			p.next()
+		case tok.is(tokenIdent, "package"):
+			p.next()
+			name, err := p.typeName()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(";"); err != nil {
+				return nil, err
+			}
+			f.Package = name
+		case tok.is(tokenIdent, "import"):
+			imp, err := p.parseImport()
+			if err != nil {
+				return nil, err
+			}
+			f.Imports = append(f.Imports, imp)
+			f.Body = append(f.Body, imp)
+		case tok.is(tokenIdent, "option"):
+			option, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			n := len(f.Options)
+			if f.Options, err = p.addOption(tok, &f.Features, f.Options, option); err != nil {
+				return nil, err
+			}
+			// features are not written as declarations
+			if len(f.Options) > n {
+				f.Body = append(f.Body, option)
+			}
+		case tok.is(tokenIdent, "message"):
+			m, err := p.parseMessage()
+			if err != nil {
+				return nil, err
+			}
+			f.Messages = append(f.Messages, m)
+			f.Body = append(f.Body, m)
+		case tok.is(tokenIdent, "enum"):
+			e, err := p.parseEnum()
+			if err != nil {
+				return nil, err
+			}
+			f.Enums = append(f.Enums, e)
+			f.Body = append(f.Body, e)
+		case tok.is(tokenIdent, "service"):
+			s, err := p.parseService()
+			if err != nil {
+				return nil, err
+			}
+			f.Services = append(f.Services, s)
+			f.Body = append(f.Body, s)
+		case tok.is(tokenIdent, "extend"):
+			ext, err := p.parseExtend()
+			if err != nil {
+				return nil, err
+			}
+			f.Extensions = append(f.Extensions, ext)
+			f.Body = append(f.Body, ext)
+		default:
+			return nil, p.errorf(tok, `unexpected %s`, tok)
+		}
+	}
+}
+
+func (p *parser) parseImport() (*Import, error) {
+	tok, err := p.expectKeyword("import")
+	if err != nil {
+		return nil, err
+	}
+	imp := &Import{Comments: leadingComments(tok)}
+	if tok := p.peek(); tok.is(tokenIdent, "public") {
+		p.next()
+		imp.Type = ImportPublic
+	} else if tok.is(tokenIdent, "weak") {
+		p.next()
+		imp.Type = ImportWeak
+	}
+	path, err := p.stringLiteral()
+	if err != nil {
+		return nil, err
+	}
+	imp.Path = path
+	if _, err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	p.trailingComment(&imp.Comments)
+	return imp, nil
+}
+
+// optionName parses names such as `deprecated`, `(foo.bar)` and `(foo).bar.baz`
+func (p *parser) optionName() (string, error) {
+	var sb strings.Builder
+	for {
+		if p.accept("(") {
+			name, err := p.typeName()
+			if err != nil {
+				return "", err
+			}
+			if _, err := p.expect(")"); err != nil {
+				return "", err
+			}
+			sb.WriteString("(" + name + ")")
+		} else {
+			name, err := p.ident()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(name)
+		}
+		if !p.accept(".") {
+			return sb.String(), nil
+		}
+		sb.WriteByte('.')
+	}
+}
+
+func (p *parser) parseOptionStatement() (*Option, error) {
+	tok, err := p.expectKeyword("option")
+	if err != nil {
+		return nil, err
+	}
+	option, err := p.parseOption()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	option.Comments = leadingComments(tok)
+	p.trailingComment(&option.Comments)
+	return option, nil
+}
+
+func (p *parser) parseOption() (*Option, error) {
+	name, err := p.optionName()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	value, err := p.parseOptionValue()
+	if err != nil {
+		return nil, err
+	}
+	return &Option{Name: name, Value: value}, nil
+}
+
+// parseCompactOptions parses a list of options enclosed in '[' and ']'
+func (p *parser) parseCompactOptions() ([]*Option, error) {
+	if _, err := p.expect("["); err != nil {
+		return nil, err
+	}
+	var options []*Option
+	for {
+		option, err := p.parseOption()
+		if err != nil {
+			return nil, err
+		}
+		option.Compact = true
+		options = append(options, option)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if _, err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+var featureValues = map[string]func(*FeatureSet, string) bool{
+	"field_presence": func(fs *FeatureSet, s string) bool {
+		for _, v := range []FieldPresence{FieldPresenceExplicit, FieldPresenceImplicit, FieldPresenceLegacyRequired} {
+			if v.String() == s {
+				fs.FieldPresence = v
+				return true
+			}
+		}
+		return false
+	},
+	"enum_type": func(fs *FeatureSet, s string) bool {
+		for _, v := range []EnumType{EnumTypeOpen, EnumTypeClosed} {
+			if v.String() == s {
+				fs.EnumType = v
+				return true
+			}
+		}
+		return false
+	},
+	"repeated_field_encoding": func(fs *FeatureSet, s string) bool {
+		for _, v := range []RepeatedFieldEncoding{RepeatedFieldEncodingPacked, RepeatedFieldEncodingExpanded} {
+			if v.String() == s {
+				fs.RepeatedFieldEncoding = v
+				return true
+			}
+		}
+		return false
+	},
+	"utf8_validation": func(fs *FeatureSet, s string) bool {
+		for _, v := range []UTF8Validation{UTF8ValidationVerify, UTF8ValidationNone} {
+			if v.String() == s {
+				fs.UTF8Validation = v
+				return true
+			}
+		}
+		return false
+	},
+	"message_encoding": func(fs *FeatureSet, s string) bool {
+		for _, v := range []MessageEncoding{MessageEncodingLengthPrefixed, MessageEncodingDelimited} {
+			if v.String() == s {
+				fs.MessageEncoding = v
+				return true
+			}
+		}
+		return false
+	},
+	"json_format": func(fs *FeatureSet, s string) bool {
+		for _, v := range []JSONFormat{JSONFormatAllow, JSONFormatLegacyBestEffort} {
+			if v.String() == s {
+				fs.JSONFormat = v
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// addOption appends option to options, unless it is one of the features
+// known to this package, in which case it is stored in features instead
+func (p *parser) addOption(tok *token, features **FeatureSet, options []*Option, option *Option) ([]*Option, error) {
+	name := strings.TrimPrefix(option.Name, "features.")
+	set, ok := featureValues[name]
+	if !ok || name == option.Name {
+		return append(options, option), nil
+	}
+
+	v, ok := option.Value.(Identifier)
+	if !ok {
+		return nil, p.errorf(tok, `invalid value for feature %q`, name)
+	}
+	if *features == nil {
+		*features = &FeatureSet{}
+	}
+	if !set(*features, string(v)) {
+		return nil, p.errorf(tok, `invalid value %q for feature %q`, v, name)
+	}
+	return options, nil
+}
+
+func (p *parser) parseOptionValue() (interface{}, error) {
+	if tok := p.peek(); tok.is(tokenPunct, "{") {
+		return p.parseMessageLiteral()
+	}
+	return p.parseScalar()
+}
+
+// parseScalar parses a scalar value. Strings are returned as string,
+// integers as int (or uint64 if they do not fit in an int), floats as
+// float64, `true` and `false` as bool, and all other identifiers as
+// Identifier
+func (p *parser) parseScalar() (interface{}, error) {
+	tok := p.peek()
+	if tok.Kind == tokenString {
+		return p.stringLiteral()
+	}
+
+	var sign string
+	if p.accept("-") {
+		sign = "-"
+	} else if p.accept("+") {
+		sign = "+"
+	}
+
+	valTok := p.next()
+	switch valTok.Kind {
+	case tokenInt:
+		text := valTok.Text
+		if v, err := strconv.ParseInt(sign+text, 0, 64); err == nil && int64(int(v)) == v {
+			return int(v), nil
+		}
+		if sign != "-" {
+			if v, err := strconv.ParseUint(text, 0, 64); err == nil {
+				return v, nil
+			}
+		}
+		return nil, p.errorf(tok, `integer %s%s is out of range`, sign, text)
+	case tokenFloat:
+		v, err := strconv.ParseFloat(sign+valTok.Text, 64)
+		if err != nil {
+			return nil, p.errorf(tok, `invalid float %s%s`, sign, valTok.Text)
+		}
+		return v, nil
+	case tokenIdent:
+		if sign == "" {
+			switch valTok.Text {
+			case "true":
+				return true, nil
+			case "false":
+				return false, nil
+			}
+			return Identifier(valTok.Text), nil
+		}
+		switch strings.ToLower(valTok.Text) {
+		case "inf", "infinity", "nan":
+			return Identifier(sign + valTok.Text), nil
+		}
+	}
+	return nil, p.errorf(valTok, `expected value, got %s`, valTok)
+}
+
+func (p *parser) parseMessageLiteral() (*MessageLiteral, error) {
+	open := p.next()
+	var closing string
+	switch {
+	case open.is(tokenPunct, "{"):
+		closing = "}"
+	case open.is(tokenPunct, "<"):
+		closing = ">"
+	default:
+		return nil, p.errorf(open, `expected message literal, got %s`, open)
+	}
+
+	ml := &MessageLiteral{}
+	for {
+		tok := p.peek()
+		if tok.is(tokenPunct, closing) {
+			p.next()
+			ml.SingleLine = tok.Pos.Line == open.Pos.Line
+			return ml, nil
+		}
+
+		field, err := p.parseMessageLiteralField()
+		if err != nil {
+			return nil, err
+		}
+		ml.Fields = append(ml.Fields, field)
+		if !p.accept(",") {
+			p.accept(";")
+		}
+	}
+}
+
+func (p *parser) parseMessageLiteralField() (*MessageLiteralField, error) {
+	var name string
+	if tok := p.peek(); tok.is(tokenPunct, "[") {
+		// extension names and type URLs such as [foo.bar] or [type.googleapis.com/foo.Bar]
+		p.next()
+		var sb strings.Builder
+		sb.WriteByte('[')
+		for {
+			tok := p.next()
+			if tok.is(tokenPunct, "]") {
+				break
+			}
+			if tok.Kind != tokenIdent && !tok.is(tokenPunct, ".") && !tok.is(tokenPunct, "/") {
+				return nil, p.errorf(tok, `unexpected %s in extension name`, tok)
+			}
+			sb.WriteString(tok.Text)
+		}
+		sb.WriteByte(']')
+		name = sb.String()
+	} else {
+		v, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		name = v
+	}
+
+	hasColon := p.accept(":")
+	var value interface{}
+	var err error
+	switch tok := p.peek(); {
+	case tok.is(tokenPunct, "{") || tok.is(tokenPunct, "<"):
+		value, err = p.parseMessageLiteral()
+	case tok.is(tokenPunct, "["):
+		if !hasColon {
+			return nil, p.errorf(tok, `expected ":" after field name %q`, name)
+		}
+		value, err = p.parseListValue()
+	default:
+		if !hasColon {
+			return nil, p.errorf(tok, `expected ":" after field name %q`, name)
+		}
+		value, err = p.parseScalar()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &MessageLiteralField{Name: name, Value: value}, nil
+}
+
+func (p *parser) parseListValue() ([]interface{}, error) {
+	if _, err := p.expect("["); err != nil {
+		return nil, err
+	}
+	list := []interface{}{}
+	if p.accept("]") {
+		return list, nil
+	}
+	for {
+		var value interface{}
+		var err error
+		if tok := p.peek(); tok.is(tokenPunct, "{") || tok.is(tokenPunct, "<") {
+			value, err = p.parseMessageLiteral()
+		} else {
+			value, err = p.parseScalar()
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if _, err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (p *parser) parseMessage() (*Message, error) {
+	tok, err := p.expectKeyword("message")
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{Name: name, Comments: leadingComments(tok)}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	p.trailingComment(&m.Comments)
+
+	for {
+		tok := p.peek()
+		switch {
+		case tok.is(tokenPunct, "}"):
+			p.next()
+			return m, nil
+		case tok.is(tokenPunct, ";"):
+			p.next()
+		case tok.is(tokenIdent, "option"):
+			option, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			n := len(m.Options)
+			if m.Options, err = p.addOption(tok, &m.Features, m.Options, option); err != nil {
+				return nil, err
+			}
+			// features are not written as declarations
+			if len(m.Options) > n {
+				m.Body = append(m.Body, option)
+			}
+		case tok.is(tokenIdent, "message") && p.peekN(2).is(tokenPunct, "{"):
+			v, err := p.parseMessage()
+			if err != nil {
+				return nil, err
+			}
+			m.Messages = append(m.Messages, v)
+			m.Body = append(m.Body, v)
+		case tok.is(tokenIdent, "enum") && p.peekN(2).is(tokenPunct, "{"):
+			v, err := p.parseEnum()
+			if err != nil {
+				return nil, err
+			}
+			m.Enums = append(m.Enums, v)
+			m.Body = append(m.Body, v)
+		case tok.is(tokenIdent, "extend") && !p.peekN(2).is(tokenPunct, "="):
+			v, err := p.parseExtend()
+			if err != nil {
+				return nil, err
+			}
+			m.Extensions = append(m.Extensions, v)
+			m.Body = append(m.Body, v)
+		case tok.is(tokenIdent, "oneof") && p.peekN(2).is(tokenPunct, "{"):
+			v, err := p.parseOneOf()
+			if err != nil {
+				return nil, err
+			}
+			m.OneOfs = append(m.OneOfs, v)
+			m.Body = append(m.Body, v)
+		case tok.is(tokenIdent, "reserved") && !p.peekN(2).is(tokenPunct, "="):
+			v := &Reserved{}
+			if err := p.parseReserved(&v.Ranges, &v.Names); err != nil {
+				return nil, err
+			}
+			m.ReservedRanges = append(m.ReservedRanges, v.Ranges...)
+			m.ReservedNames = append(m.ReservedNames, v.Names...)
+			m.Body = append(m.Body, v)
+		case tok.is(tokenIdent, "extensions") && !p.peekN(2).is(tokenPunct, "="):
+			v, err := p.parseExtensionRange()
+			if err != nil {
+				return nil, err
+			}
+			m.ExtensionRanges = append(m.ExtensionRanges, v)
+			m.Body = append(m.Body, v)
+		default:
+			field, err := p.parseField(true)
+			if err != nil {
+				return nil, err
+			}
+			m.Fields = append(m.Fields, field)
+			m.Body = append(m.Body, field)
+		}
+	}
+}
+
+func (p *parser) parseField(allowLabel bool) (*Field, error) {
+	field := &Field{Comments: leadingComments(p.peek())}
+
+	if tok := p.peek(); allowLabel && tok.Kind == tokenIdent && !p.peekN(2).is(tokenPunct, "=") {
+		switch tok.Text {
+		case "required":
+			field.Cardinality = CardinalityRequired
+		case "optional":
+			field.Cardinality = CardinalityOptional
+		case "repeated":
+			field.Cardinality = CardinalityRepeated
+		}
+		if field.Cardinality != CardinalityDefault {
+			p.next()
+		}
+	}
+
+	// group names must start with an uppercase letter, which is how they
+	// are distinguished from fields of a type named "group"
+	if tok := p.peek(); tok.is(tokenIdent, "group") && p.peekN(1).Kind == tokenIdent && p.peekN(1).Text[0] >= 'A' && p.peekN(1).Text[0] <= 'Z' {
+		return nil, p.errorf(tok, `groups are not supported`)
+	}
+
+	if tok := p.peek(); tok.is(tokenIdent, "map") && p.peekN(1).is(tokenPunct, "<") {
+		p.next()
+		p.next()
+		key, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(","); err != nil {
+			return nil, err
+		}
+		value, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(">"); err != nil {
+			return nil, err
+		}
+		field.Type = &MapType{Key: typeOf(key), Value: typeOf(value)}
+	} else {
+		typ, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		field.Type = typeOf(typ)
+	}
+
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	field.Name = name
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	if field.ID, err = p.int(); err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.is(tokenPunct, "[") {
+		options, err := p.parseCompactOptions()
+		if err != nil {
+			return nil, err
+		}
+		for _, option := range options {
+			if field.Options, err = p.addOption(tok, &field.Features, field.Options, option); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	p.trailingComment(&field.Comments)
+	return field, nil
+}
+
+func (p *parser) parseOneOf() (*OneOf, error) {
+	tok, err := p.expectKeyword("oneof")
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	oneof := &OneOf{Name: name, Comments: leadingComments(tok)}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	p.trailingComment(&oneof.Comments)
+	for {
+		tok := p.peek()
+		switch {
+		case tok.is(tokenPunct, "}"):
+			p.next()
+			return oneof, nil
+		case tok.is(tokenPunct, ";"):
+			p.next()
+		case tok.is(tokenIdent, "option"):
+			option, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			options, err := p.addOption(tok, &oneof.Features, nil, option)
+			if err != nil {
+				return nil, err
+			}
+			if len(options) > 0 {
+				return nil, p.errorf(tok, `options other than features are not supported in oneofs`)
+			}
+		default:
+			field, err := p.parseField(false)
+			if err != nil {
+				return nil, err
+			}
+			oneof.Fields = append(oneof.Fields, field)
+		}
+	}
+}
+
+func (p *parser) parseExtend() (*Extension, error) {
+	tok, err := p.expectKeyword("extend")
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.typeName()
+	if err != nil {
+		return nil, err
+	}
+	ext := &Extension{Name: name, Comments: leadingComments(tok)}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	p.trailingComment(&ext.Comments)
+	for {
+		tok := p.peek()
+		switch {
+		case tok.is(tokenPunct, "}"):
+			p.next()
+			return ext, nil
+		case tok.is(tokenPunct, ";"):
+			p.next()
+		default:
+			field, err := p.parseField(true)
+			if err != nil {
+				return nil, err
+			}
+			ext.Fields = append(ext.Fields, field)
+		}
+	}
+}
+
+// parseRanges parses a comma separated list of ranges, such as `1, 5 to 10, 100 to max`
+func (p *parser) parseRanges() ([]*Range, error) {
+	var ranges []*Range
+	for {
+		start, err := p.int()
+		if err != nil {
+			return nil, err
+		}
+		r := &Range{Start: start, End: start}
+		if p.peek().is(tokenIdent, "to") {
+			p.next()
+			if p.peek().is(tokenIdent, "max") {
+				p.next()
+				r.ToMax = true
+				r.End = 0
+			} else if r.End, err = p.int(); err != nil {
+				return nil, err
+			}
+		}
+		ranges = append(ranges, r)
+		if !p.accept(",") {
+			return ranges, nil
+		}
+	}
+}
+
+func (p *parser) parseReserved(ranges *[]*Range, names *[]string) error {
+	if _, err := p.expectKeyword("reserved"); err != nil {
+		return err
+	}
+
+	if tok := p.peek(); tok.Kind == tokenString || tok.Kind == tokenIdent {
+		for {
+			tok := p.next()
+			if tok.Kind != tokenString && tok.Kind != tokenIdent {
+				return p.errorf(tok, `expected reserved name, got %s`, tok)
+			}
+			*names = append(*names, tok.Text)
+			if !p.accept(",") {
+				break
+			}
+		}
+	} else {
+		list, err := p.parseRanges()
+		if err != nil {
+			return err
+		}
+		*ranges = append(*ranges, list...)
+	}
+	_, err := p.expect(";")
+	return err
+}
+
+func (p *parser) parseExtensionRange() (*ExtensionRange, error) {
+	tok, err := p.expectKeyword("extensions")
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := p.parseRanges()
+	if err != nil {
+		return nil, err
+	}
+	er := &ExtensionRange{Ranges: ranges, Comments: leadingComments(tok)}
+
+	if tok := p.peek(); tok.is(tokenPunct, "[") {
+		options, err := p.parseCompactOptions()
+		if err != nil {
+			return nil, err
+		}
+		for _, option := range options {
+			switch option.Name {
+			case "declaration":
+				d, err := extensionDeclarationFromLiteral(option.Value)
+				if err != nil {
+					return nil, p.errorf(tok, `invalid extension declaration: %w`, err)
+				}
+				er.Declarations = append(er.Declarations, d)
+			case "verification":
+				switch option.Value {
+				case Identifier(VerificationDeclaration.String()):
+					er.Verification = VerificationDeclaration
+				case Identifier(VerificationUnverified.String()):
+					er.Verification = VerificationUnverified
+				default:
+					return nil, p.errorf(tok, `invalid verification state %v`, option.Value)
+				}
+			default:
+				er.Options = append(er.Options, option)
+			}
+		}
+	}
+	if _, err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	p.trailingComment(&er.Comments)
+	return er, nil
+}
+
+func extensionDeclarationFromLiteral(v interface{}) (*ExtensionDeclaration, error) {
+	ml, ok := v.(*MessageLiteral)
+	if !ok {
+		return nil, fmt.Errorf(`expected message literal`)
+	}
+	var d ExtensionDeclaration
+	for _, field := range ml.Fields {
+		var ok bool
+		switch field.Name {
+		case "number":
+			d.Number, ok = field.Value.(int)
+		case "full_name":
+			d.FullName, ok = field.Value.(string)
+		case "type":
+			d.Type, ok = field.Value.(string)
+		case "reserved":
+			d.Reserved, ok = field.Value.(bool)
+		case "repeated":
+			d.Repeated, ok = field.Value.(bool)
+		default:
+			return nil, fmt.Errorf(`unknown field %q`, field.Name)
+		}
+		if !ok {
+			return nil, fmt.Errorf(`invalid value for field %q`, field.Name)
+		}
+	}
+	return &d, nil
+}
+
+func (p *parser) parseEnum() (*Enum, error) {
+	tok, err := p.expectKeyword("enum")
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	e := &Enum{Name: name, Comments: leadingComments(tok)}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	p.trailingComment(&e.Comments)
+
+	for {
+		tok := p.peek()
+		switch {
+		case tok.is(tokenPunct, "}"):
+			p.next()
+			return e, nil
+		case tok.is(tokenPunct, ";"):
+			p.next()
+		case tok.is(tokenIdent, "option") && !p.peekN(1).is(tokenPunct, "="):
+			option, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			if e.Options, err = p.addOption(tok, &e.Features, e.Options, option); err != nil {
+				return nil, err
+			}
+		case tok.is(tokenIdent, "reserved") && !p.peekN(1).is(tokenPunct, "="):
+			if err := p.parseReserved(&e.ReservedRanges, &e.ReservedNames); err != nil {
+				return nil, err
+			}
+		default:
+			v, err := p.parseEnumElement()
+			if err != nil {
+				return nil, err
+			}
+			e.Elements = append(e.Elements, v)
+		}
+	}
+}
+
+func (p *parser) parseEnumElement() (*EnumElement, error) {
+	comments := leadingComments(p.peek())
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	value, err := p.int()
+	if err != nil {
+		return nil, err
+	}
+	ee := &EnumElement{Name: name, Value: value, Comments: comments}
+
+	if tok := p.peek(); tok.is(tokenPunct, "[") {
+		options, err := p.parseCompactOptions()
+		if err != nil {
+			return nil, err
+		}
+		for _, option := range options {
+			if ee.Options, err = p.addOption(tok, &ee.Features, ee.Options, option); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	p.trailingComment(&ee.Comments)
+	return ee, nil
+}
+
+func (p *parser) parseService() (*Service, error) {
+	tok, err := p.expectKeyword("service")
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{Name: name, Comments: leadingComments(tok)}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	p.trailingComment(&s.Comments)
+
+	for {
+		tok := p.peek()
+		switch {
+		case tok.is(tokenPunct, "}"):
+			p.next()
+			return s, nil
+		case tok.is(tokenPunct, ";"):
+			p.next()
+		case tok.is(tokenIdent, "option"):
+			option, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			s.Options = append(s.Options, option)
+		case tok.is(tokenIdent, "rpc"):
+			m, err := p.parseMethod()
+			if err != nil {
+				return nil, err
+			}
+			s.Methods = append(s.Methods, m)
+		default:
+			return nil, p.errorf(tok, `unexpected %s in service %q`, tok, s.Name)
+		}
+	}
+}
+
+// methodType parses the input or output type of a method, including
+// the optional `stream` keyword
+func (p *parser) methodType() (Type, bool, error) {
+	if _, err := p.expect("("); err != nil {
+		return nil, false, err
+	}
+	var stream bool
+	if tok := p.peek(); tok.is(tokenIdent, "stream") && (p.peekN(1).Kind == tokenIdent || p.peekN(1).is(tokenPunct, ".")) {
+		p.next()
+		stream = true
+	}
+	typ, err := p.typeName()
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, false, err
+	}
+	return MessageRef(typ), stream, nil
+}
+
+func (p *parser) parseMethod() (*Method, error) {
+	tok, err := p.expectKeyword("rpc")
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	m := &Method{Name: name, Comments: leadingComments(tok)}
+	if m.Input, m.ClientStreaming, err = p.methodType(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expectKeyword("returns"); err != nil {
+		return nil, err
+	}
+	if m.Output, m.ServerStreaming, err = p.methodType(); err != nil {
+		return nil, err
+	}
+
+	if p.accept(";") {
+		p.trailingComment(&m.Comments)
+		return m, nil
+	}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	p.trailingComment(&m.Comments)
+	for {
+		tok := p.peek()
+		switch {
+		case tok.is(tokenPunct, "}"):
+			p.next()
+			// a trailing semicolon after the method body is allowed
+			p.accept(";")
+			return m, nil
+		case tok.is(tokenPunct, ";"):
+			p.next()
+		case tok.is(tokenIdent, "option"):
+			option, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			m.Options = append(m.Options, option)
+		default:
+			return nil, p.errorf(tok, `unexpected %s in method %q`, tok, m.Name)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package protowrite_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/protowrite"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(`testdata/*.golden`)
+	require.NoError(t, err, `filepath.Glob should succeed`)
+	require.NotEmpty(t, files, `there should be golden files`)
+
+	for _, filename := range files {
+		filename := filename
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			src, err := os.ReadFile(filename)
+			require.NoError(t, err, `os.ReadFile should succeed`)
+
+			file, err := protowrite.Unmarshal(src)
+			require.NoError(t, err, `protowrite.Unmarshal should succeed`)
+
+			buf, err := protowrite.Marshal(file)
+			require.NoError(t, err, `protowrite.Marshal should succeed`)
+			require.Equal(t, strings.TrimSpace(string(src)), string(buf))
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	const src = `// comments before the syntax statement belong to the file
+syntax = "proto3";
+package foo.bar;
+
+import public "other.proto";
+import weak "weak.proto";
+
+option java_package = "com.example" ".foo";
+option optimize_for = SPEED;
+
+/* Message is
+ * a message
+ */
+message Message {
+  option (my_option) = { name: "a", values: [1, -2, 0x10], nested < id: 3 > };
+  repeated .foo.bar.Other others = 1 [deprecated = true, (custom).sub = -1.5];
+  map<string, int32> counts = 2;
+  message Other {};
+}
+
+service Svc {
+  rpc Call (stream Message) returns (stream Message) {}
+}
+`
+	file, err := protowrite.Parse(strings.NewReader(src))
+	require.NoError(t, err, `protowrite.Parse should succeed`)
+
+	require.Equal(t, protowrite.SyntaxProto3, file.Syntax)
+	require.Equal(t, "comments before the syntax statement belong to the file", file.Comments.Leading)
+	require.Equal(t, "foo.bar", file.Package)
+	require.Equal(t, []*protowrite.Import{
+		{Path: "other.proto", Type: protowrite.ImportPublic},
+		{Path: "weak.proto", Type: protowrite.ImportWeak},
+	}, file.Imports)
+	require.Equal(t, []*protowrite.Option{
+		{Name: "java_package", Value: "com.example.foo"},
+		{Name: "optimize_for", Value: protowrite.Identifier("SPEED")},
+	}, file.Options)
+
+	require.Len(t, file.Messages, 1)
+	msg := file.Messages[0]
+	require.Equal(t, protowrite.Comments{
+		Leading: "Message is\na message",
+		Style:   protowrite.CommentStyleBlock,
+	}, msg.Comments)
+	require.Equal(t, &protowrite.MessageLiteral{
+		SingleLine: true,
+		Fields: []*protowrite.MessageLiteralField{
+			{Name: "name", Value: "a"},
+			{Name: "values", Value: []interface{}{1, -2, 16}},
+			{Name: "nested", Value: &protowrite.MessageLiteral{
+				SingleLine: true,
+				Fields:     []*protowrite.MessageLiteralField{{Name: "id", Value: 3}},
+			}},
+		},
+	}, msg.Options[0].Value)
+	require.Equal(t, &protowrite.Field{
+		Type:        protowrite.TypeRef{Name: ".foo.bar.Other"},
+		Name:        "others",
+		ID:          1,
+		Cardinality: protowrite.CardinalityRepeated,
+		Options: []*protowrite.Option{
+			{Name: "deprecated", Value: true, Compact: true},
+			{Name: "(custom).sub", Value: -1.5, Compact: true},
+		},
+	}, msg.Fields[0])
+	require.Equal(t, protowrite.MapOf(protowrite.TypeString, protowrite.TypeInt32), msg.Fields[1].Type)
+	require.Len(t, msg.Messages, 1)
+
+	require.Len(t, file.Services, 1)
+	method := file.Services[0].Methods[0]
+	require.True(t, method.ClientStreaming, `method should be client streaming`)
+	require.True(t, method.ServerStreaming, `method should be server streaming`)
+}
+
+func TestParseHeader(t *testing.T) {
+	src, err := os.ReadFile(`testdata/header.golden`)
+	require.NoError(t, err, `os.ReadFile should succeed`)
+
+	file, err := protowrite.Unmarshal(src)
+	require.NoError(t, err, `protowrite.Unmarshal should succeed`)
+	require.Equal(t, []string{
+		"Copyright 2024 Example Inc.\n\nLicensed under the Apache License, Version 2.0",
+		protowrite.GeneratedHeader("protoc-gen-example", "v1.2.3", "example/foo.proto"),
+	}, file.Header)
+	require.Equal(t, "foo.bar holds the foo API", file.Comments.Leading)
+}
+
+func TestParseWithoutPackage(t *testing.T) {
+	const src = `syntax = "proto3";
+
+message A {
+    string name = 1;
+}`
+	file, err := protowrite.Unmarshal([]byte(src))
+	require.NoError(t, err, `protowrite.Unmarshal should succeed`)
+	require.Empty(t, file.Package)
+
+	buf, err := protowrite.Marshal(file)
+	require.NoError(t, err, `protowrite.Marshal should succeed`)
+	require.Equal(t, src, string(buf))
+}
+
+func TestParseErrors(t *testing.T) {
+	testcases := []struct {
+		Name   string
+		Source string
+		Line   int
+		Column int
+	}{
+		{Name: "unknown syntax", Source: `syntax = "proto4";`, Line: 1, Column: 10},
+		{Name: "missing semicolon", Source: "syntax = \"proto3\";\npackage foo\nmessage Foo {}", Line: 3, Column: 1},
+		{Name: "unterminated string", Source: "syntax = \"proto3\";\noption foo = \"bar;\n", Line: 2, Column: 14},
+		{Name: "bad field number", Source: "syntax = \"proto3\";\nmessage Foo {\n  string a = b;\n}", Line: 3, Column: 14},
+		{Name: "unexpected end of input", Source: "syntax = \"proto3\";\nmessage Foo {\n", Line: 3, Column: 1},
+		{Name: "group", Source: "syntax = \"proto2\";\nmessage Foo {\n  optional group Bar = 1 {}\n}", Line: 3, Column: 12},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := protowrite.Unmarshal([]byte(tc.Source))
+			require.Error(t, err, `protowrite.Unmarshal should fail`)
+
+			var perr *protowrite.ParseError
+			require.True(t, errors.As(err, &perr), `error should be a ParseError`)
+			require.Equal(t, tc.Line, perr.Line, `line should match`)
+			require.Equal(t, tc.Column, perr.Column, `column should match`)
+		})
+	}
+}
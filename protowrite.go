@@ -15,67 +15,54 @@
 package protowrite
 
 import (
-	"bufio"
-	"bytes"
-	"context"
 	"fmt"
-	"io"
 	"strings"
 )
 
-type encoder interface {
-	encode(context.Context, io.Writer) error
-}
-
-type encodeIndentKey struct{}
-type encodeIndentOnceKey struct{}
-
-var Indent = "    "
+// Syntax specifies which flavor of the protobuf language a File is written in.
+// The zero value is SyntaxProto3
+type Syntax int
 
-func getIndentOnce(ctx context.Context) string {
-	return ctx.Value(encodeIndentOnceKey{}).(string)
-}
+const (
+	SyntaxProto3 Syntax = iota
+	SyntaxProto2
+	SyntaxEditions
+)
 
-func getIndent(ctx context.Context) string {
-	v := ctx.Value(encodeIndentKey{})
-	if s, ok := v.(string); ok {
-		return s
+func (s Syntax) String() string {
+	switch s {
+	case SyntaxProto3:
+		return "proto3"
+	case SyntaxProto2:
+		return "proto2"
+	case SyntaxEditions:
+		return "editions"
+	default:
+		return fmt.Sprintf("Syntax(%d)", int(s))
 	}
-	return ""
-}
-
-func moreIndent(ctx context.Context) context.Context {
-	indentOnce := getIndentOnce(ctx)
-
-	cur := getIndent(ctx)
-	return context.WithValue(ctx, encodeIndentKey{}, cur+indentOnce)
 }
 
-func lessIndent(ctx context.Context) context.Context {
-	indentOnce := getIndentOnce(ctx)
+// Edition is the name of a protobuf edition, such as "2023".
+// It is only used when the File's Syntax is SyntaxEditions
+type Edition string
 
-	cur := getIndent(ctx)
-	return context.WithValue(ctx, encodeIndentKey{}, strings.TrimSuffix(cur, indentOnce))
-}
-
-func multilineComment(ctx context.Context, dst io.Writer, s string) {
-	indent := getIndent(ctx)
-	scanner := bufio.NewScanner(strings.NewReader(s))
-	for scanner.Scan() {
-		txt := scanner.Text()
-		fmt.Fprintf(dst, "\n%s// %s", indent, txt)
-	}
-}
+const (
+	Edition2023 Edition = "2023"
+	Edition2024 Edition = "2024"
+)
 
-func singlelineComment(dst io.Writer, s string) {
-	// no new lines here
-	s = strings.Replace(s, "\n", " ", -1)
-	fmt.Fprintf(dst, " // %s", s)
-}
+// DefaultEdition is the edition used when a File specifies SyntaxEditions
+// but does not specify an Edition
+const DefaultEdition = Edition2023
 
 // File represents a protobuf file, which is the top-most level resource
 // that this package can generate
 type File struct {
+	// Syntax describes the syntax of the file. Defaults to proto3
+	Syntax Syntax
+	// Edition describes the edition of the file. Only used when
+	// Syntax is SyntaxEditions. Defaults to DefaultEdition
+	Edition Edition
 	// Package describes the package name
 	Package    string
 	Imports    []*Import
@@ -84,58 +71,94 @@ type File struct {
 	Options    []*Option
 	Extensions []*Extension
 	Services   []*Service
-}
-
-func (f *File) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-
-	fmt.Fprintf(dst, "%ssyntax = \"proto3\";", indent)
-	fmt.Fprintf(dst, "\n\n%spackage %s;", indent, f.Package)
-
-	if list := f.Imports; len(list) > 0 {
-		fmt.Fprint(dst, "\n")
-		for i, v := range list {
-			if err := v.encode(ctx, dst); err != nil {
-				return fmt.Errorf(`failed to encode import statement %d: %w`, i, err)
-			}
+	// Features describes the file-wide Editions features
+	Features *FeatureSet
+	// Body lists the declarations of the file in the order they are
+	// written when using OrderSource
+	Body []BodyElement
+	// Header lists comment blocks written at the very top of the file,
+	// such as license banners or the marker created by GeneratedHeader.
+	// Each block is written using `//`, followed by a blank line
+	Header []string
+	// Comments are attached to the syntax or edition declaration
+	// at the top of the file. They are written after the Header
+	Comments Comments
+}
+
+func (f *File) edition() Edition {
+	if f.Edition == "" {
+		return DefaultEdition
+	}
+	return f.Edition
+}
+
+func (f *File) encode(e *encodeState) error {
+	e.syntax = f.Syntax
+	e.scope = f.Package
+	e.path = append(e.path[:0], f.Package)
+	e.typeNames = newTypeNames(f)
+	e.typeNames.fullyQualified = e.options.fullyQualifiedNames
+
+	var leading strings.Builder
+	for _, s := range f.Header {
+		writeComment(&leading, "", s, CommentStyleLine)
+		leading.WriteString("\n")
+	}
+	f.Comments.writeLeading(&leading, "")
+	if leading.Len() > 0 {
+		e.WriteString(strings.TrimPrefix(leading.String(), "\n"))
+		e.WriteByte('\n')
+	}
+	switch f.Syntax {
+	case SyntaxProto2, SyntaxProto3:
+		if f.Edition != "" {
+			return fmt.Errorf(`edition %q specified for a file with %s syntax`, f.Edition, f.Syntax)
 		}
-	}
-
-	if list := f.Options; len(list) > 0 {
-		fmt.Fprint(dst, "\n")
-		for i, v := range list {
-			if err := v.encode(ctx, dst); err != nil {
-				return fmt.Errorf(`failed to encode option declaration %d: %w`, i, err)
-			}
+		e.WriteString("syntax = ")
+		e.writeQuoted(f.Syntax.String())
+	case SyntaxEditions:
+		defaults, err := editionDefaults(f.edition())
+		if err != nil {
+			return err
 		}
+		e.features = defaults
+		e.WriteString("edition = ")
+		e.writeQuoted(string(f.edition()))
+	default:
+		return fmt.Errorf(`unknown syntax %s`, f.Syntax)
+	}
+	e.WriteByte(';')
+	f.Comments.encodeTrailing(e)
+	if err := e.check(); err != nil {
+		return err
 	}
 
-	for i, v := range f.Extensions {
-		fmt.Fprintf(dst, "\n")
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode extension declaration %d: %w`, i, err)
-		}
+	_, features, err := e.pushFeatures(f.Features, false)
+	if err != nil {
+		return fmt.Errorf(`invalid features for file: %w`, err)
 	}
-	for i, v := range f.Messages {
-		fmt.Fprintf(dst, "\n")
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode message declaration %d: %w`, i, err)
-		}
+	if err := f.checkExtendees(e.typeNames); err != nil {
+		return err
 	}
-	for i, v := range f.Enums {
-		fmt.Fprintf(dst, "\n")
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode enum declaration %d: %w`, i, err)
-		}
+	if f.Package != "" {
+		e.WriteByte('\n')
+		e.separate()
+		e.WriteString("package ")
+		e.WriteString(f.Package)
+		e.WriteByte(';')
 	}
-	for i, v := range f.Services {
-		fmt.Fprintf(dst, "\n")
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode service declaration %d: %w`, i, err)
+
+	var prev encoder
+	for i, v := range f.body(e, features) {
+		if separated(prev, v) {
+			e.separate()
 		}
+		if err := v.encode(e); err != nil {
+			return fmt.Errorf(`failed to encode %s %d: %w`, declarationKind(v), i, err)
+		}
+		prev = v
 	}
-
-	return nil
+	return e.check()
 }
 
 type ImportType int
@@ -147,29 +170,52 @@ const (
 )
 
 type Import struct {
-	Path string
-	Type ImportType
+	Path     string
+	Type     ImportType
+	Comments Comments
 }
 
-func (f *Import) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-	fmt.Fprintf(dst, "\n%simport", indent)
+func (f *Import) encode(e *encodeState) error {
+	f.Comments.encodeLeading(e)
+	e.newline()
+	e.WriteString("import")
 	switch f.Type {
 	case ImportPublic:
-		fmt.Fprintf(dst, " public")
+		e.WriteString(" public")
 	case ImportWeak:
-		fmt.Fprintf(dst, " weak")
+		e.WriteString(" weak")
 	}
-	fmt.Fprintf(dst, " %q;", f.Path)
-	return nil
+	e.WriteByte(' ')
+	e.writeQuoted(f.Path)
+	e.WriteByte(';')
+	f.Comments.encodeTrailing(e)
+	return e.check()
 }
 
-// Option represents a protobuf option. No check whatsoever is performed on the syntax of the
-// option. The caller is responsible to quote strings, use correct braces, etc.
+// Option represents a protobuf option. Name is written as given, such as
+// `java_package` or `(my.ext).field`. Value is written as a protobuf
+// literal: strings and []byte are quoted and escaped, booleans, integers
+// and floats are written in their shortest form, and Identifier, Hex,
+// Octal, *MessageLiteral and enums generated by protoc-gen-go are written
+// as such. Values of any other type make Marshal fail
 type Option struct {
-	Name    string
-	Value   interface{} // Scalar or MessageLiteral
+	Name  string
+	Value interface{}
+	// Compact is true if the option is written as part of another
+	// declaration, such as `[deprecated = true]`. The options of fields,
+	// enum values and extension ranges are always written this way
 	Compact bool
+	// Comments are not written for compact options
+	Comments Comments
+}
+
+// Identifier is an option value that is written verbatim, without
+// any quoting. Use it for values such as enum value names
+type Identifier string
+
+func (v Identifier) encode(e *encodeState) error {
+	e.WriteString(string(v))
+	return nil
 }
 
 type MessageLiteral struct {
@@ -177,27 +223,26 @@ type MessageLiteral struct {
 	Fields     []*MessageLiteralField
 }
 
-func (ml *MessageLiteral) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-	fmt.Fprint(dst, "{")
-	ctx = moreIndent(ctx)
+func (ml *MessageLiteral) encode(e *encodeState) error {
+	e.WriteByte('{')
+	e.moreIndent()
 	for i, field := range ml.Fields {
 		if !ml.SingleLine {
-			fmt.Fprintf(dst, "\n%s", getIndent(ctx))
+			e.newline()
 		} else if i > 0 {
-			fmt.Fprintf(dst, " ")
+			e.WriteByte(' ')
 		}
 
-		if err := field.encode(ctx, dst); err != nil {
+		if err := field.encode(e); err != nil {
 			return fmt.Errorf(`failed to encode field %d for message literal: %w`, i, err)
 		}
 
 	}
-	ctx = lessIndent(ctx)
+	e.lessIndent()
 	if !ml.SingleLine {
-		fmt.Fprintf(dst, "\n%s", indent)
+		e.newline()
 	}
-	fmt.Fprint(dst, "}")
+	e.WriteByte('}')
 	return nil
 }
 
@@ -206,124 +251,284 @@ type MessageLiteralField struct {
 	Value interface{}
 }
 
-func (mlf *MessageLiteralField) encode(ctx context.Context, dst io.Writer) error {
-	var val string
-	if e, ok := mlf.Value.(encoder); ok {
-		var buf strings.Builder
-		if err := e.encode(ctx, &buf); err != nil {
-			return fmt.Errorf(`failed to encode option value for message literal %q: %w`, mlf.Name, err)
-		}
-		val = buf.String()
-	} else {
-		val = fmt.Sprintf("%#v", mlf.Value)
+func (mlf *MessageLiteralField) encode(e *encodeState) error {
+	e.WriteString(mlf.Name)
+	e.WriteString(": ")
+	if err := writeValue(e, mlf.Value, true); err != nil {
+		return fmt.Errorf(`failed to encode option value for message literal %q: %w`, mlf.Name, err)
 	}
-	fmt.Fprintf(dst, "%s: %s", mlf.Name, val)
 	return nil
 }
 
-func (o *Option) encode(ctx context.Context, dst io.Writer) error {
+func (o *Option) encode(e *encodeState) error {
 	if o.Compact {
-		// CompactOption is much like Option, but is used as part of other declarations.
-		// These have no newlines, are enclosed within '[' and ']', and are concatenated using commas
-		fmt.Fprintf(dst, "%s = %s", o.Name, o.Value)
-		return nil
+		return o.encodeCompact(e)
+	}
+	o.Comments.encodeLeading(e)
+	e.newline()
+	e.WriteString("option ")
+	e.WriteString(o.Name)
+	e.WriteString(" = ")
+	if err := writeValue(e, o.Value, false); err != nil {
+		return fmt.Errorf(`failed to encode option value for option %q: %w`, o.Name, err)
+	}
+	e.WriteByte(';')
+	o.Comments.encodeTrailing(e)
+	return e.check()
+}
+
+// encodeCompact writes the option as part of another declaration.
+// Compact options have no newlines, and are enclosed within '[' and ']'
+// by encodeCompactOptions
+func (o *Option) encodeCompact(e *encodeState) error {
+	e.WriteString(o.Name)
+	e.WriteString(" = ")
+	if err := writeValue(e, o.Value, false); err != nil {
+		return fmt.Errorf(`failed to encode option value for option %q: %w`, o.Name, err)
 	}
-	indent := getIndent(ctx)
+	return nil
+}
 
-	var val string
-	if e, ok := o.Value.(encoder); ok {
-		var buf strings.Builder
-		if err := e.encode(ctx, &buf); err != nil {
-			return fmt.Errorf(`failed to encode option value for option %q: %w`, o.Name, err)
+// encodeCompactOptions writes a list of options enclosed in
+// '[' and ']', separated by commas
+func encodeCompactOptions(e *encodeState, options []*Option) error {
+	if len(options) == 0 {
+		return nil
+	}
+	e.WriteString(" [")
+	for i, option := range options {
+		if i > 0 {
+			e.WriteString(", ")
+		}
+		if err := option.encodeCompact(e); err != nil {
+			return fmt.Errorf(`failed to encode option %d: %w`, i, err)
 		}
-		val = buf.String()
-	} else {
-		val = fmt.Sprintf("%#v", o.Value)
 	}
-
-	fmt.Fprintf(dst, "\n%soption %s = %s;", indent, o.Name, val)
+	e.WriteByte(']')
 	return nil
 }
 
 type OneOf struct {
-	Name   string
-	Fields []*Field
-}
-
-func (oo *OneOf) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-	fmt.Fprintf(dst, "\n%soneof %s {", indent, oo.Name)
-	for i, v := range oo.Fields {
-		ctx = moreIndent(ctx)
-		if err := v.encode(ctx, dst); err != nil {
+	Name     string
+	Fields   []*Field
+	Features *FeatureSet
+	Comments Comments
+}
+
+func (oo *OneOf) encode(e *encodeState) error {
+	parent, features, err := e.pushFeatures(oo.Features, false)
+	if err != nil {
+		return fmt.Errorf(`invalid features for oneof %q: %w`, oo.Name, err)
+	}
+	defer func() { e.features = parent }()
+
+	oo.Comments.encodeLeading(e)
+	e.newline()
+	e.WriteString("oneof ")
+	e.WriteString(oo.Name)
+	e.WriteString(" {")
+	oo.Comments.encodeTrailing(e)
+	if err := e.check(); err != nil {
+		return err
+	}
+	e.moreIndent()
+	e.inOneOf = true
+	for i, v := range features {
+		if err := v.encode(e); err != nil {
+			return fmt.Errorf(`failed to encode option declaration %d for oneof %q: %w`, i, oo.Name, err)
+		}
+	}
+	for i, v := range e.sortFields(oo.Fields) {
+		if _, ok := v.Type.(*MapType); ok {
+			return fmt.Errorf(`map field %q is not allowed in oneof %q`, v.Name, oo.Name)
+		}
+		if err := v.encode(e); err != nil {
 			return fmt.Errorf(`failed to encode field declaration %d for oneof %q: %w`, i, oo.Name, err)
 		}
-		ctx = lessIndent(ctx)
 	}
-	fmt.Fprintf(dst, "\n%s}", indent)
-	return nil
+	e.inOneOf = false
+	e.lessIndent()
+	e.newline()
+	e.WriteByte('}')
+	return e.check()
 }
 
 type Enum struct {
 	Name     string
 	Elements []*EnumElement
-	Comment  string
+	Comments Comments
+	// Comment is written as the leading comment if Comments.Leading is empty.
+	//
+	// Deprecated: use Comments.Leading instead
+	Comment        string
+	Options        []*Option
+	Features       *FeatureSet
+	ReservedRanges []*Range
+	ReservedNames  []string
+}
+
+func (e *Enum) checkReserved() error {
+	if err := validateReservedRanges(e.ReservedRanges, MinEnumValue, MaxEnumValue); err != nil {
+		return err
+	}
+	for _, v := range e.Elements {
+		if err := checkReserved(e.ReservedRanges, e.ReservedNames, MaxEnumValue, v.Value, v.Name); err != nil {
+			return fmt.Errorf(`enum value %q: %w`, v.Name, err)
+		}
+	}
+	return nil
 }
 
-func (e *Enum) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-
-	if s := e.Comment; s != "" {
-		multilineComment(ctx, dst, s)
+func (e *Enum) encode(state *encodeState) error {
+	state.pushPath(e.Name)
+	defer state.popPath()
+	parent, features, err := state.pushFeatures(e.Features, false)
+	if err != nil {
+		return fmt.Errorf(`invalid features for enum %q: %w`, e.Name, err)
+	}
+	defer func() { state.features = parent }()
+	if err := e.checkReserved(); err != nil {
+		return fmt.Errorf(`invalid enum %q: %w`, e.Name, err)
+	}
+
+	comments := e.Comments.withLeading(e.Comment)
+	comments.encodeLeading(state)
+	state.newline()
+	state.WriteString("enum ")
+	state.WriteString(e.Name)
+	state.WriteString(" {")
+	comments.encodeTrailing(state)
+	if err := state.check(); err != nil {
+		return err
+	}
+	state.moreIndent()
+	for i, v := range append(features, e.Options...) {
+		if err := v.encode(state); err != nil {
+			return fmt.Errorf(`failed to encode option declaration %d for enum %q: %w`, i, e.Name, err)
+		}
 	}
-	fmt.Fprintf(dst, "\n%senum %s {", indent, e.Name)
-	for i, v := range e.Elements {
-		ctx = moreIndent(ctx)
-		if err := v.encode(ctx, dst); err != nil {
+	encodeReserved(state, e.ReservedRanges, e.ReservedNames)
+	for i, v := range state.sortEnumValues(e.Elements) {
+		if err := v.encode(state); err != nil {
 			return fmt.Errorf(`failed to encode enum declaration %d for enum %q: %w`, i, e.Name, err)
 		}
-		ctx = lessIndent(ctx)
 	}
-	fmt.Fprintf(dst, "\n%s}", indent)
-	return nil
+	state.lessIndent()
+	state.newline()
+	state.WriteByte('}')
+	return state.check()
 }
 
 type EnumElement struct {
-	Name    string
-	Value   int
-	Comment string
+	Name     string
+	Value    int
+	Comments Comments
+	// Comment is written as the trailing comment if Comments.Trailing is empty.
+	//
+	// Deprecated: use Comments.Trailing instead
+	Comment  string
+	Options  []*Option
+	Features *FeatureSet
 }
 
-func (ee *EnumElement) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-	fmt.Fprintf(dst, "\n%s%s = %d;", indent, ee.Name, ee.Value)
-	if s := ee.Comment; s != "" {
-		singlelineComment(dst, s)
+func (ee *EnumElement) encode(e *encodeState) error {
+	e.pushPath(ee.Name)
+	defer e.popPath()
+	parent, features, err := e.pushFeatures(ee.Features, true)
+	if err != nil {
+		return fmt.Errorf(`invalid features for enum value %q: %w`, ee.Name, err)
 	}
-	return nil
+	defer func() { e.features = parent }()
+	comments := ee.Comments.withTrailing(ee.Comment)
+	comments.encodeLeading(e)
+	e.newline()
+	e.WriteString(ee.Name)
+	e.WriteString(" = ")
+	e.writeInt(ee.Value)
+	if err := encodeCompactOptions(e, append(features, ee.Options...)); err != nil {
+		return fmt.Errorf(`failed to encode options for enum value %q: %w`, ee.Name, err)
+	}
+	e.WriteByte(';')
+	comments.encodeTrailing(e)
+	return e.check()
 }
 
 type Extension struct {
-	Name   string
-	Fields []*Field
-}
-
-func (e *Extension) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-	fmt.Fprintf(dst, "\n%sextend %s {", indent, e.Name)
-	for i, v := range e.Fields {
-		ctx = moreIndent(ctx)
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode field declaration %d for extension %q: %w`, i, e.Name, err)
+	// Name is the name of the extended message. It is ignored if Target is specified
+	Name string
+	// Target is the extended message, either a *Message declared in the
+	// same File, an ExternalType, or a TypeRef
+	Target   Type
+	Fields   []*Field
+	Comments Comments
+}
+
+// isOptionsExtendee returns true if name refers to one of the
+// google.protobuf.*Options messages, which are the only messages
+// that proto3 files are allowed to extend
+func isOptionsExtendee(name string) bool {
+	name = strings.TrimPrefix(name, ".")
+	return strings.HasPrefix(name, "google.protobuf.") && strings.HasSuffix(name, "Options")
+}
+
+// extendee returns the name of the extended message as it should be
+// written, and its fully-qualified name if it is known
+func (e *Extension) extendee(state *encodeState) (string, string, error) {
+	if e.Target == nil {
+		return e.Name, e.Name, nil
+	}
+	if _, ok := e.Target.(*Enum); ok {
+		return "", "", fmt.Errorf(`extension target %q is not a message`, e.Target)
+	}
+	name, err := state.typeName(e.Target)
+	if err != nil {
+		return "", "", fmt.Errorf(`invalid extension target: %w`, err)
+	}
+	full, err := state.typeNames.fullName(e.Target)
+	if err != nil {
+		full = name
+	}
+	return name, full, nil
+}
+
+func (e *Extension) encode(state *encodeState) error {
+	name, full, err := e.extendee(state)
+	if err != nil {
+		return err
+	}
+	if state.syntax == SyntaxProto3 && !isOptionsExtendee(full) {
+		return fmt.Errorf(`proto3 files may only extend option messages (got %q)`, full)
+	}
+	e.Comments.encodeLeading(state)
+	state.newline()
+	state.WriteString("extend ")
+	state.WriteString(name)
+	state.WriteString(" {")
+	e.Comments.encodeTrailing(state)
+	if err := state.check(); err != nil {
+		return err
+	}
+	state.moreIndent()
+	for i, v := range state.sortFields(e.Fields) {
+		if _, ok := v.Type.(*MapType); ok {
+			return fmt.Errorf(`map field %q is not allowed in extension %q`, v.Name, name)
+		}
+		if err := v.encode(state); err != nil {
+			return fmt.Errorf(`failed to encode field declaration %d for extension %q: %w`, i, name, err)
 		}
-		ctx = lessIndent(ctx)
 	}
-	fmt.Fprintf(dst, "\n%s}", indent)
-	return nil
+	state.lessIndent()
+	state.newline()
+	state.WriteByte('}')
+	return state.check()
 }
 
 type Message struct {
-	Name       string
+	Name     string
+	Comments Comments
+	// Comment is written as the leading comment if Comments.Leading is empty.
+	//
+	// Deprecated: use Comments.Leading instead
 	Comment    string
 	Fields     []*Field
 	OneOfs     []*OneOf
@@ -331,60 +536,83 @@ type Message struct {
 	Enums      []*Enum
 	Extensions []*Extension
 	Options    []*Option
-}
-
-func (m *Message) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-
-	if c := m.Comment; c != "" {
-		multilineComment(ctx, dst, c)
-	}
-	fmt.Fprintf(dst, "\n%smessage %s {", indent, m.Name)
-	for i, v := range m.OneOfs {
-		ctx = moreIndent(ctx)
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode nested oneof declaration %d for message %q: %w`, i, m.Name, err)
-		}
-		ctx = lessIndent(ctx)
-	}
-	for i, v := range m.Extensions {
-		ctx = moreIndent(ctx)
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode nested extension declaration %d for message %q: %w`, i, m.Name, err)
-		}
-		ctx = lessIndent(ctx)
-	}
-	for i, v := range m.Options {
-		ctx = moreIndent(ctx)
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode nested option declaration %d for message %q: %w`, i, m.Name, err)
+	Features   *FeatureSet
+	// ReservedRanges lists field numbers that may not be used by fields
+	ReservedRanges []*Range
+	// ReservedNames lists names that may not be used by fields
+	ReservedNames []string
+	// ExtensionRanges lists field numbers that are available for extensions
+	ExtensionRanges []*ExtensionRange
+	// Body lists the declarations of the message in the order they are
+	// written when using OrderSource
+	Body []BodyElement
+}
+
+func (m *Message) checkReserved() error {
+	if err := validateReservedRanges(m.ReservedRanges, 1, MaxFieldNumber); err != nil {
+		return err
+	}
+	check := func(list []*Field) error {
+		for _, v := range list {
+			if err := checkReserved(m.ReservedRanges, m.ReservedNames, MaxFieldNumber, v.ID, v.Name); err != nil {
+				return fmt.Errorf(`field %q: %w`, v.Name, err)
+			}
+			for _, er := range m.ExtensionRanges {
+				if er.contains(v.ID) {
+					return fmt.Errorf(`field %q: number %d is within an extension range`, v.Name, v.ID)
+				}
+			}
 		}
-		ctx = lessIndent(ctx)
+		return nil
 	}
-	for i, v := range m.Enums {
-		ctx = moreIndent(ctx)
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode nested enum declaration %d for message %q: %w`, i, m.Name, err)
-		}
-		ctx = lessIndent(ctx)
+	if err := check(m.Fields); err != nil {
+		return err
 	}
-	for i, v := range m.Messages {
-		ctx = moreIndent(ctx)
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode nested message declaration %d for message %q: %w`, i, m.Name, err)
+	for _, oneof := range m.OneOfs {
+		if err := check(oneof.Fields); err != nil {
+			return err
 		}
-		fmt.Fprint(dst, "\n")
-		ctx = lessIndent(ctx)
 	}
-	for i, v := range m.Fields {
-		ctx = moreIndent(ctx)
-		if err := v.encode(ctx, dst); err != nil {
-			return fmt.Errorf(`failed to encode field declaration %d for message %q: %w`, i, m.Name, err)
+	return nil
+}
+
+func (m *Message) encode(e *encodeState) error {
+	e.pushPath(m.Name)
+	defer e.popPath()
+	parent, features, err := e.pushFeatures(m.Features, false)
+	if err != nil {
+		return fmt.Errorf(`invalid features for message %q: %w`, m.Name, err)
+	}
+	defer func() { e.features = parent }()
+	if err := m.checkReserved(); err != nil {
+		return fmt.Errorf(`invalid message %q: %w`, m.Name, err)
+	}
+	if len(m.ExtensionRanges) > 0 && e.syntax == SyntaxProto3 {
+		return fmt.Errorf(`invalid message %q: extension ranges are not allowed in proto3`, m.Name)
+	}
+
+	comments := m.Comments.withLeading(m.Comment)
+	comments.encodeLeading(e)
+	e.newline()
+	e.WriteString("message ")
+	e.WriteString(m.Name)
+	e.WriteString(" {")
+	comments.encodeTrailing(e)
+	if err := e.check(); err != nil {
+		return err
+	}
+	scope := e.withScope(m.Name)
+	defer func() { e.scope = scope }()
+	e.moreIndent()
+	for i, v := range m.body(e, features) {
+		if err := v.encode(e); err != nil {
+			return fmt.Errorf(`failed to encode %s %d for message %q: %w`, declarationKind(v), i, m.Name, err)
 		}
-		ctx = lessIndent(ctx)
 	}
-	fmt.Fprintf(dst, "\n%s}", indent)
-	return nil
+	e.lessIndent()
+	e.newline()
+	e.WriteByte('}')
+	return e.check()
 }
 
 type FieldCardinality int
@@ -397,95 +625,297 @@ const (
 )
 
 type Field struct {
-	Type        string
+	// Type is the type of the field: a ScalarType such as TypeString,
+	// a TypeRef to a message or an enum, or a *MapType
+	Type        Type
 	Name        string
 	ID          int
 	Cardinality FieldCardinality
 	Options     []*Option
-	Comment     string
+	Comments    Comments
+	// Comment is written as the trailing comment if Comments.Trailing is empty.
+	//
+	// Deprecated: use Comments.Trailing instead
+	Comment  string
+	Features *FeatureSet
 }
 
-func (f *Field) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-	fmt.Fprintf(dst, "\n%s", indent)
+// label returns the label that should be written before the field,
+// or an error if the field's cardinality cannot be expressed in the
+// given syntax
+func (f *Field) label(e *encodeState) (string, error) {
+	syntax := e.syntax
+	if e.inOneOf {
+		if f.Cardinality != CardinalityDefault {
+			return "", fmt.Errorf(`fields in a oneof may not have a label`)
+		}
+		return "", nil
+	}
+
+	if _, ok := f.Type.(*MapType); ok {
+		if f.Cardinality != CardinalityDefault {
+			return "", fmt.Errorf(`map fields may not have a label`)
+		}
+		return "", nil
+	}
+
 	switch f.Cardinality {
+	case CardinalityDefault:
+		// proto2 requires all fields outside of oneofs to be labeled
+		if syntax == SyntaxProto2 {
+			return "optional", nil
+		}
+		return "", nil
 	case CardinalityRequired:
-		fmt.Fprintf(dst, "required ")
+		if syntax != SyntaxProto2 {
+			return "", fmt.Errorf(`required fields are not allowed in %s (use proto2)`, syntax)
+		}
+		return "required", nil
 	case CardinalityOptional:
-		fmt.Fprintf(dst, "optional ")
+		if syntax == SyntaxEditions {
+			return "", fmt.Errorf(`optional label is not allowed in editions (use features.field_presence)`)
+		}
+		return "optional", nil
 	case CardinalityRepeated:
-		fmt.Fprintf(dst, "repeated ")
-	}
-	fmt.Fprintf(dst, "%s %s = %d", f.Type, f.Name, f.ID)
-
-	if options := f.Options; len(options) > 0 {
-		fmt.Fprintf(dst, " [")
-		for i, option := range options {
-			if err := option.encode(ctx, dst); err != nil {
-				return fmt.Errorf(`failed to encode option %d for field %q: %w`, i, f.Name, err)
-			}
+		return "repeated", nil
+	default:
+		return "", fmt.Errorf(`unknown field cardinality %d`, f.Cardinality)
+	}
+}
+
+func (f *Field) encode(e *encodeState) error {
+	e.pushPath(f.Name)
+	defer e.popPath()
+	label, err := f.label(e)
+	if err != nil {
+		return fmt.Errorf(`invalid field %q: %w`, f.Name, err)
+	}
+	parent, features, err := e.pushFeatures(f.Features, true)
+	if err != nil {
+		return fmt.Errorf(`invalid features for field %q: %w`, f.Name, err)
+	}
+	defer func() { e.features = parent }()
+	comments := f.Comments.withTrailing(f.Comment)
+	comments.encodeLeading(e)
+	e.newline()
+	if label != "" {
+		e.WriteString(label)
+		e.WriteByte(' ')
+	}
+	switch typ := f.Type.(type) {
+	case nil:
+		return fmt.Errorf(`field %q has no type`, f.Name)
+	case ScalarType:
+		if !typ.valid() {
+			return fmt.Errorf(`field %q has unknown scalar type %q`, f.Name, typ)
+		}
+	case *MapType:
+		if err := typ.validate(); err != nil {
+			return fmt.Errorf(`invalid map field %q: %w`, f.Name, err)
 		}
-		fmt.Fprintf(dst, "]")
 	}
-	fmt.Fprintf(dst, ";")
-
-	if c := f.Comment; c != "" {
-		singlelineComment(dst, c)
+	typ, err := e.typeName(f.Type)
+	if err != nil {
+		return fmt.Errorf(`invalid type for field %q: %w`, f.Name, err)
 	}
+	e.WriteString(typ)
+	e.WriteByte(' ')
+	e.WriteString(f.Name)
+	e.WriteString(" = ")
+	e.writeInt(f.ID)
 
-	return nil
+	if err := encodeCompactOptions(e, append(features, f.Options...)); err != nil {
+		return fmt.Errorf(`failed to encode options for field %q: %w`, f.Name, err)
+	}
+	e.WriteByte(';')
+	comments.encodeTrailing(e)
+	return e.check()
 }
 
 type Service struct {
-	Name    string
-	Methods []*Method
-}
-
-func (s *Service) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-	fmt.Fprintf(dst, "\n%sservice %s {", indent, s.Name)
-	for i, v := range s.Methods {
-		ctx = moreIndent(ctx)
-		if err := v.encode(ctx, dst); err != nil {
+	Name     string
+	Comments Comments
+	Methods  []*Method
+	Options  []*Option
+}
+
+func (s *Service) encode(e *encodeState) error {
+	e.pushPath(s.Name)
+	defer e.popPath()
+	s.Comments.encodeLeading(e)
+	e.newline()
+	e.WriteString("service ")
+	e.WriteString(s.Name)
+	e.WriteString(" {")
+	s.Comments.encodeTrailing(e)
+	if err := e.check(); err != nil {
+		return err
+	}
+	e.moreIndent()
+	for i, v := range s.Options {
+		if err := v.encode(e); err != nil {
+			return fmt.Errorf(`failed to encode option declaration %d for service %q: %w`, i, s.Name, err)
+		}
+	}
+	for i, v := range e.sortMethods(s.Methods) {
+		if err := v.encode(e); err != nil {
 			return fmt.Errorf(`failed to encode method %d for service %q: %w`, i, s.Name, err)
 		}
-		ctx = lessIndent(ctx)
 	}
-	fmt.Fprint(dst, "\n}", indent)
-	return nil
+	e.lessIndent()
+	e.newline()
+	e.WriteByte('}')
+	return e.check()
 }
 
-type Method struct {
-	Name    string
-	Input   string
-	Output  string
-	Options []*Option
+// IdempotencyLevel corresponds to the `idempotency_level` method option
+type IdempotencyLevel int
+
+const (
+	IdempotencyUnknown IdempotencyLevel = iota
+	IdempotencyNoSideEffects
+	IdempotencyIdempotent
+)
+
+func (v IdempotencyLevel) String() string {
+	switch v {
+	case IdempotencyNoSideEffects:
+		return "NO_SIDE_EFFECTS"
+	case IdempotencyIdempotent:
+		return "IDEMPOTENT"
+	default:
+		return "IDEMPOTENCY_UNKNOWN"
+	}
 }
 
-func (m *Method) encode(ctx context.Context, dst io.Writer) error {
-	indent := getIndent(ctx)
-	fmt.Fprintf(dst, "\n%srpc %s(%s) returns (%s)", indent, m.Name, m.Input, m.Output)
-	if options := m.Options; len(options) > 0 {
-		fmt.Fprintf(dst, " {")
-		ctx = moreIndent(ctx)
-		for i, option := range options {
-			if err := option.encode(ctx, dst); err != nil {
-				return fmt.Errorf(`failed to encode option %d for method %q: %w`, i, m.Name, err)
-			}
-		}
-		ctx = lessIndent(ctx)
-		fmt.Fprintf(dst, "\n%s}", indent)
+// OptionRetention corresponds to the `retention` field option
+type OptionRetention int
+
+const (
+	RetentionUnknown OptionRetention = iota
+	RetentionRuntime
+	RetentionSource
+)
+
+func (v OptionRetention) String() string {
+	switch v {
+	case RetentionRuntime:
+		return "RETENTION_RUNTIME"
+	case RetentionSource:
+		return "RETENTION_SOURCE"
+	default:
+		return "RETENTION_UNKNOWN"
 	}
-	fmt.Fprintf(dst, ";")
-	return nil
 }
 
-func Marshal(f *File) ([]byte, error) {
-	ctx := context.WithValue(context.Background(), encodeIndentOnceKey{}, Indent)
+// OptionTargetType corresponds to the `targets` field option
+type OptionTargetType int
 
-	var dst bytes.Buffer
-	if err := f.encode(ctx, &dst); err != nil {
-		return nil, fmt.Errorf(`failed to write protobuf: %w`, err)
+const (
+	TargetTypeUnknown OptionTargetType = iota
+	TargetTypeFile
+	TargetTypeExtensionRange
+	TargetTypeMessage
+	TargetTypeField
+	TargetTypeOneOf
+	TargetTypeEnum
+	TargetTypeEnumEntry
+	TargetTypeService
+	TargetTypeMethod
+)
+
+func (v OptionTargetType) String() string {
+	switch v {
+	case TargetTypeFile:
+		return "TARGET_TYPE_FILE"
+	case TargetTypeExtensionRange:
+		return "TARGET_TYPE_EXTENSION_RANGE"
+	case TargetTypeMessage:
+		return "TARGET_TYPE_MESSAGE"
+	case TargetTypeField:
+		return "TARGET_TYPE_FIELD"
+	case TargetTypeOneOf:
+		return "TARGET_TYPE_ONEOF"
+	case TargetTypeEnum:
+		return "TARGET_TYPE_ENUM"
+	case TargetTypeEnumEntry:
+		return "TARGET_TYPE_ENUM_ENTRY"
+	case TargetTypeService:
+		return "TARGET_TYPE_SERVICE"
+	case TargetTypeMethod:
+		return "TARGET_TYPE_METHOD"
+	default:
+		return "TARGET_TYPE_UNKNOWN"
 	}
-	return dst.Bytes(), nil
+}
+
+type Method struct {
+	Name     string
+	Comments Comments
+	// Input and Output are the request and response messages. They
+	// may be a *Message declared in the same File, an ExternalType,
+	// or a TypeRef
+	Input  Type
+	Output Type
+	// ClientStreaming is true if the client sends a stream of Input messages
+	ClientStreaming bool
+	// ServerStreaming is true if the server returns a stream of Output messages
+	ServerStreaming bool
+	Options         []*Option
+}
+
+func streamType(e *encodeState, typ Type, stream bool) (string, error) {
+	if _, ok := typ.(*Enum); ok {
+		return "", fmt.Errorf(`%q is not a message`, typ)
+	}
+	name, err := e.typeName(typ)
+	if err != nil {
+		return "", err
+	}
+	if stream {
+		return "stream " + name, nil
+	}
+	return name, nil
+}
+
+func (m *Method) encode(e *encodeState) error {
+	e.pushPath(m.Name)
+	defer e.popPath()
+	input, err := streamType(e, m.Input, m.ClientStreaming)
+	if err != nil {
+		return fmt.Errorf(`invalid input type for method %q: %w`, m.Name, err)
+	}
+	output, err := streamType(e, m.Output, m.ServerStreaming)
+	if err != nil {
+		return fmt.Errorf(`invalid output type for method %q: %w`, m.Name, err)
+	}
+	m.Comments.encodeLeading(e)
+	e.newline()
+	e.WriteString("rpc ")
+	e.WriteString(m.Name)
+	e.WriteByte('(')
+	e.WriteString(input)
+	e.WriteString(") returns (")
+	e.WriteString(output)
+	e.WriteByte(')')
+	if options := m.Options; len(options) > 0 {
+		e.WriteString(" {")
+		m.Comments.encodeTrailing(e)
+		if err := e.check(); err != nil {
+			return err
+		}
+		e.moreIndent()
+		for i, option := range options {
+			if err := option.encode(e); err != nil {
+				return fmt.Errorf(`failed to encode option %d for method %q: %w`, i, m.Name, err)
+			}
+		}
+		e.lessIndent()
+		e.newline()
+		e.WriteByte('}')
+		return e.check()
+	}
+	e.WriteByte(';')
+	m.Comments.encodeTrailing(e)
+	return e.check()
 }
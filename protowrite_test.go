@@ -7,12 +7,14 @@ import (
 
 	"github.com/lestrrat-go/protowrite"
 	"github.com/stretchr/testify/require"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestWrite(t *testing.T) {
 	var b protowrite.Builder
 
-	cmpProtobuf := func(file *protowrite.File, filename string) {
+	cmpProtobuf := func(t *testing.T, file *protowrite.File, filename string) {
+		t.Helper()
 		buf, err := protowrite.Marshal(file)
 		require.NoError(t, err, `protowrite.Marshal should succeed`)
 
@@ -67,7 +69,7 @@ func TestWrite(t *testing.T) {
 			Build()
 		require.NoError(t, err, `builder.Build should succeed`)
 
-		cmpProtobuf(file, `testdata/sanity.golden`)
+		cmpProtobuf(t, file, `testdata/sanity.golden`)
 	})
 	t.Run("Any", func(t *testing.T) {
 		file, err := b.File().
@@ -97,6 +99,749 @@ func TestWrite(t *testing.T) {
 			Build()
 		require.NoError(t, err, `builder.Build should succeed`)
 
-		cmpProtobuf(file, `testdata/any.golden`)
+		cmpProtobuf(t, file, `testdata/any.golden`)
+	})
+	t.Run("Proto2", func(t *testing.T) {
+		file, err := b.File().
+			Syntax(protowrite.SyntaxProto2).
+			Package(`foo.bar`).
+			Messages(
+				b.Message("Legacy").
+					Fields(
+						&protowrite.Field{Type: protowrite.TypeString, Name: "name", ID: 1, Cardinality: protowrite.CardinalityRequired},
+						&protowrite.Field{Type: protowrite.TypeUint64, Name: "ids", ID: 3, Cardinality: protowrite.CardinalityRepeated},
+					).
+					Uint64Field("id", 2).
+					OneOfs(
+						b.OneOf("choice").
+							StringField("a", 4).
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/proto2.golden`)
+	})
+	t.Run("Editions", func(t *testing.T) {
+		file, err := b.File().
+			Edition(protowrite.Edition2023).
+			Package(`foo.bar`).
+			Messages(
+				b.Message("Message").
+					StringField("name", 1).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/editions.golden`)
+	})
+	t.Run("Map", func(t *testing.T) {
+		file, err := b.File().
+			Syntax(protowrite.SyntaxProto2).
+			Package(`foo.bar`).
+			Messages(
+				b.Message("Message").
+					MapField("string", "Message", "children", 1).
+					Fields(
+						b.MapField("int32", "string", "names", 2).
+							Comment("keyed by id").
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/map.golden`)
+	})
+	t.Run("Scalar types", func(t *testing.T) {
+		file, err := b.File().
+			Package(`foo.bar`).
+			Import("google/protobuf/any.proto", protowrite.ImportDefault).
+			Messages(
+				b.Message("Scalars").
+					DoubleField("double_value", 1).
+					FloatField("float_value", 2).
+					Int32Field("int32_value", 3).
+					Int64Field("int64_value", 4).
+					Uint32Field("uint32_value", 5).
+					Uint64Field("uint64_value", 6).
+					Sint32Field("sint32_value", 7).
+					Sint64Field("sint64_value", 8).
+					Fixed32Field("fixed32_value", 9).
+					Fixed64Field("fixed64_value", 10).
+					Sfixed32Field("sfixed32_value", 11).
+					Sfixed64Field("sfixed64_value", 12).
+					BoolField("bool_value", 13).
+					StringField("string_value", 14).
+					BytesField("bytes_value", 15).
+					EnumField("Kind", "kind", 16).
+					Fields(protowrite.MapField("string", "Scalars", "children", 17)).
+					OneOfs(
+						b.OneOf("choice").
+							BoolField("flag", 18).
+							MessageField("google.protobuf.Any", "any", 19).
+							MustBuild(),
+					).
+					Enums(b.Enum("Kind").Element("KIND_UNSPECIFIED", 0).MustBuild()).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/scalars.golden`)
+	})
+	t.Run("Reserved", func(t *testing.T) {
+		file, err := b.File().
+			Package(`foo.bar`).
+			Enums(
+				b.Enum("Kind").
+					ReserveNumbers(2).
+					ReserveRangeToMax(10).
+					ReserveNames("SECONDARY").
+					Element("NULL", 0).
+					Element("PRIMARY", 1).
+					MustBuild(),
+			).
+			Messages(
+				b.Message("Message").
+					ReserveNumbers(2, 15).
+					ReserveRange(9, 11).
+					ReserveRangeToMax(40).
+					ReserveNames("foo", "bar").
+					StringField("name", 1).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/reserved.golden`)
+	})
+	t.Run("ExtensionRanges", func(t *testing.T) {
+		file, err := b.File().
+			Syntax(protowrite.SyntaxProto2).
+			Package(`foo.bar`).
+			Messages(
+				b.Message("Base").
+					ExtensionRange(100, 199).
+					ExtensionRanges(
+						b.ExtensionRangeToMax(1000).
+							Declaration(1000, ".foo.bar.ext", "string").
+							Verification(protowrite.VerificationDeclaration).
+							MustBuild(),
+					).
+					Field("string", "name", 1).
+					MustBuild(),
+			).
+			Extensions(
+				b.Extension("Base").
+					StringField("ext", 1000).
+					Field("uint64", "other", 100).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/extension_ranges.golden`)
+	})
+	t.Run("Streaming", func(t *testing.T) {
+		file, err := b.File().
+			Package(`foo.bar`).
+			Services(
+				b.Service("StreamService").
+					Method("Unary", "Request", "Response").
+					StreamingMethod("Upload", "Request", "Response", true, false).
+					StreamingMethod("Download", "Request", "Response", false, true).
+					StreamingMethod("Chat", "Request", "Response", true, true).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/streaming.golden`)
+	})
+	t.Run("ServiceOptions", func(t *testing.T) {
+		file, err := b.File().
+			Package(`foo.bar`).
+			Services(
+				b.Service("FooService").
+					Comment("FooService does foo").
+					Option("deprecated", true).
+					Option("(my_service_option)", "value").
+					Method("Plain", "Request", "Response").
+					Methods(
+						b.Method("Get", "Request", "Response").
+							Comment("Get returns a response").
+							IdempotencyLevel(protowrite.IdempotencyNoSideEffects).
+							MustBuild(),
+						b.Method("Watch", "Request", "Response").
+							Stream(false, true).
+							Deprecated(true).
+							Option("(my_method_option)", 42).
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/service_options.golden`)
+	})
+	t.Run("Comments", func(t *testing.T) {
+		file, err := b.File().
+			Syntax(protowrite.SyntaxProto2).
+			LeadingComment("File comment").
+			TrailingComment("syntax").
+			Package(`foo.bar`).
+			Imports(
+				b.Import("google/protobuf/descriptor.proto", protowrite.ImportDefault).
+					LeadingComment("Options are declared here").
+					MustBuild(),
+			).
+			Options(
+				b.Option("java_package", "com.example.foo").
+					DetachedComment("Options").
+					TrailingComment("Java only").
+					MustBuild(),
+			).
+			Extensions(
+				b.Extension("google.protobuf.FieldOptions").
+					LeadingComment("Custom options").
+					StringField("label", 50000).
+					MustBuild(),
+			).
+			Messages(
+				b.Message("Message").
+					DetachedComment("Messages").
+					LeadingComment("Message is a message.\nIt has fields.").
+					TrailingComment("body").
+					OneOfs(
+						b.OneOf("choice").
+							LeadingComment("Only one of these").
+							StringField("a", 4).
+							MustBuild(),
+					).
+					ExtensionRanges(
+						b.ExtensionRange(100, 200).
+							TrailingComment("for extensions").
+							MustBuild(),
+					).
+					Fields(
+						b.Field("string", "name", 1).
+							LeadingComment("The name").
+							TrailingComment("required").
+							MustBuild(),
+						b.Field("int32", "id", 2).
+							CommentStyle(protowrite.CommentStyleBlock).
+							LeadingComment("The id.\nIt is unique.").
+							TrailingComment("positive").
+							MustBuild(),
+						b.Field("string", "note", 3).
+							TrailingComment("spans\nlines").
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Enums(
+				b.Enum("Kind").
+					CommentStyle(protowrite.CommentStyleBlock).
+					LeadingComment("Kind of thing").
+					EnumElements(
+						b.EnumElement("KIND_UNKNOWN", 0).
+							Comment("unknown").
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Services(
+				b.Service("Service").
+					LeadingComment("Service does things").
+					Methods(
+						b.Method("Get", "Message", "Message").
+							LeadingComment("Get gets a message").
+							TrailingComment("cheap").
+							MustBuild(),
+						b.Method("Watch", "Message", "Message").
+							LeadingComment("Watch watches a message").
+							TrailingComment("expensive").
+							Deprecated(true).
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/comments.golden`)
+	})
+	t.Run("Deprecated comments", func(t *testing.T) {
+		file := &protowrite.File{
+			Package: `foo.bar`,
+			Enums: []*protowrite.Enum{{
+				Name:     "Kind",
+				Comment:  "Kind is the kind of a message",
+				Elements: []*protowrite.EnumElement{{Name: "KIND_UNKNOWN", Comment: "default"}},
+			}},
+			Messages: []*protowrite.Message{{
+				Name:    "Message",
+				Comment: "Message is a message",
+				Fields: []*protowrite.Field{
+					{Type: protowrite.TypeString, Name: "name", ID: 1, Comment: "the name"},
+					// Comments takes precedence
+					{Type: protowrite.TypeString, Name: "label", ID: 2, Comment: "ignored", Comments: protowrite.Comments{Trailing: "the label"}},
+				},
+			}},
+		}
+		buf, err := protowrite.Marshal(file)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Contains(t, string(buf), `
+// Message is a message
+message Message {
+    string name = 1; // the name
+    string label = 2; // the label
+}
+
+// Kind is the kind of a message
+enum Kind {
+    KIND_UNKNOWN = 0; // default
+}`)
+	})
+	t.Run("Header", func(t *testing.T) {
+		file, err := b.File().
+			Header("Copyright 2024 Example Inc.\n\nLicensed under the Apache License, Version 2.0").
+			GeneratedBy("protoc-gen-example", "v1.2.3", "example/foo.proto").
+			LeadingComment("foo.bar holds the foo API").
+			Package(`foo.bar`).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/header.golden`)
+	})
+	t.Run("CompactOptions", func(t *testing.T) {
+		file, err := b.File().
+			Syntax(protowrite.SyntaxProto2).
+			Package(`foo.bar`).
+			Enums(
+				b.Enum("Status").
+					Element("STATUS_UNKNOWN", 0).
+					EnumElements(
+						b.EnumElement("STATUS_OLD", 1).
+							Deprecated(true).
+							Option("(display_name)", "Old").
+							MustBuild(),
+						b.EnumElement("STATUS_SECRET", 2).
+							DebugRedact(true).
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Messages(
+				b.Message("Message").
+					Fields(
+						b.Field("int32", "count", 1).
+							Default(-5).
+							JSONName("cnt").
+							MustBuild(),
+						b.Field("int32", "ids", 2).
+							Cardinality(protowrite.CardinalityRepeated).
+							Packed(true).
+							Deprecated(true).
+							MustBuild(),
+						b.Field("Status", "status", 3).
+							Default(protowrite.Identifier("STATUS_UNKNOWN")).
+							DebugRedact(true).
+							MustBuild(),
+						b.Field("string", "label", 4).
+							Retention(protowrite.RetentionSource).
+							Targets(protowrite.TargetTypeMessage, protowrite.TargetTypeField).
+							Option("(rules)", b.MessageLiteral().
+								Field("min_len", 1).
+								Field("max_len", 10).
+								MustBuild()).
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/compact_options.golden`)
+	})
+	t.Run("Features", func(t *testing.T) {
+		file, err := b.File().
+			Edition(protowrite.Edition2023).
+			Package(`foo.bar`).
+			Features(&protowrite.FeatureSet{
+				FieldPresence: protowrite.FieldPresenceImplicit,
+				// same as the edition default, should be dropped
+				EnumType: protowrite.EnumTypeOpen,
+			}).
+			Enums(
+				b.Enum("Kind").
+					Features(&protowrite.FeatureSet{EnumType: protowrite.EnumTypeClosed}).
+					EnumElements(
+						b.EnumElement("KIND_UNKNOWN", 0).
+							Features(&protowrite.FeatureSet{JSONFormat: protowrite.JSONFormatLegacyBestEffort}).
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Messages(
+				b.Message("Message").
+					Features(&protowrite.FeatureSet{JSONFormat: protowrite.JSONFormatLegacyBestEffort}).
+					OneOfs(
+						b.OneOf("choice").
+							Features(&protowrite.FeatureSet{UTF8Validation: protowrite.UTF8ValidationNone}).
+							Fields(
+								b.Field("string", "a", 3).
+									// inherited from the oneof, should be dropped
+									Features(&protowrite.FeatureSet{UTF8Validation: protowrite.UTF8ValidationNone}).
+									MustBuild(),
+							).
+							MustBuild(),
+					).
+					Fields(
+						b.Field("string", "name", 1).
+							Features(&protowrite.FeatureSet{FieldPresence: protowrite.FieldPresenceExplicit}).
+							MustBuild(),
+						b.Field("uint64", "ids", 2).
+							Cardinality(protowrite.CardinalityRepeated).
+							Features(&protowrite.FeatureSet{
+								FieldPresence:         protowrite.FieldPresenceImplicit,
+								RepeatedFieldEncoding: protowrite.RepeatedFieldEncodingExpanded,
+							}).
+							Option("deprecated", true).
+							MustBuild(),
+					).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		cmpProtobuf(t, file, `testdata/features.golden`)
+	})
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	var b protowrite.Builder
+
+	testcases := []struct {
+		Name   string
+		Syntax protowrite.Syntax
+		Field  *protowrite.Field
+		Error  bool
+	}{
+		{Name: "required in proto2", Syntax: protowrite.SyntaxProto2, Field: &protowrite.Field{Type: protowrite.TypeString, Name: "a", ID: 1, Cardinality: protowrite.CardinalityRequired}},
+		{Name: "required in proto3", Syntax: protowrite.SyntaxProto3, Field: &protowrite.Field{Type: protowrite.TypeString, Name: "a", ID: 1, Cardinality: protowrite.CardinalityRequired}, Error: true},
+		{Name: "required in editions", Syntax: protowrite.SyntaxEditions, Field: &protowrite.Field{Type: protowrite.TypeString, Name: "a", ID: 1, Cardinality: protowrite.CardinalityRequired}, Error: true},
+		{Name: "optional in proto3", Syntax: protowrite.SyntaxProto3, Field: &protowrite.Field{Type: protowrite.TypeString, Name: "a", ID: 1, Cardinality: protowrite.CardinalityOptional}},
+		{Name: "optional in editions", Syntax: protowrite.SyntaxEditions, Field: &protowrite.Field{Type: protowrite.TypeString, Name: "a", ID: 1, Cardinality: protowrite.CardinalityOptional}, Error: true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			file, err := b.File().
+				Syntax(tc.Syntax).
+				Package(`foo.bar`).
+				Messages(b.Message("Message").Fields(tc.Field).MustBuild()).
+				Build()
+			require.NoError(t, err, `builder.Build should succeed`)
+
+			_, err = protowrite.Marshal(file)
+			if tc.Error {
+				require.Error(t, err, `protowrite.Marshal should fail`)
+			} else {
+				require.NoError(t, err, `protowrite.Marshal should succeed`)
+			}
+		})
+	}
+
+	t.Run("labeled field in oneof", func(t *testing.T) {
+		oneof := b.OneOf("choice").MustBuild()
+		oneof.Fields = append(oneof.Fields, &protowrite.Field{Type: protowrite.TypeString, Name: "a", ID: 1, Cardinality: protowrite.CardinalityOptional})
+		file, err := b.File().
+			Package(`foo.bar`).
+			Messages(b.Message("Message").OneOfs(oneof).MustBuild()).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		_, err = protowrite.Marshal(file)
+		require.Error(t, err, `protowrite.Marshal should fail`)
+	})
+	t.Run("features in proto3", func(t *testing.T) {
+		file, err := b.File().
+			Package(`foo.bar`).
+			Features(&protowrite.FeatureSet{FieldPresence: protowrite.FieldPresenceImplicit}).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		_, err = protowrite.Marshal(file)
+		require.Error(t, err, `protowrite.Marshal should fail`)
+	})
+	t.Run("extend non-option message in proto3", func(t *testing.T) {
+		file, err := b.File().
+			Package(`foo.bar`).
+			Extensions(b.Extension("foo.bar.Message").StringField("a", 100).MustBuild()).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+
+		_, err = protowrite.Marshal(file)
+		require.Error(t, err, `protowrite.Marshal should fail`)
+	})
+}
+
+func TestMapFieldErrors(t *testing.T) {
+	var b protowrite.Builder
+
+	marshal := func(t *testing.T, msg *protowrite.Message) error {
+		t.Helper()
+		file, err := b.File().
+			Package(`foo.bar`).
+			Messages(msg).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+		_, err = protowrite.Marshal(file)
+		return err
+	}
+
+	t.Run("invalid key type", func(t *testing.T) {
+		for _, typ := range []string{"double", "float", "bytes", "Message"} {
+			err := marshal(t, b.Message("Message").MapField(typ, "string", "a", 1).MustBuild())
+			require.Error(t, err, `protowrite.Marshal should fail for key type %q`, typ)
+		}
+	})
+	t.Run("map value", func(t *testing.T) {
+		err := marshal(t, b.Message("Message").MapField("string", "map<string, string>", "a", 1).MustBuild())
+		require.Error(t, err, `protowrite.Marshal should fail`)
+	})
+	t.Run("repeated", func(t *testing.T) {
+		err := marshal(t, b.Message("Message").
+			Fields(
+				b.MapField("string", "string", "a", 1).
+					Cardinality(protowrite.CardinalityRepeated).
+					MustBuild(),
+			).
+			MustBuild())
+		require.Error(t, err, `protowrite.Marshal should fail`)
+	})
+	t.Run("in oneof", func(t *testing.T) {
+		err := marshal(t, b.Message("Message").
+			OneOfs(
+				b.OneOf("choice").
+					Fields(protowrite.MapField("string", "string", "a", 1)).
+					MustBuild(),
+			).
+			MustBuild())
+		require.Error(t, err, `protowrite.Marshal should fail`)
+	})
+}
+
+func TestReservedErrors(t *testing.T) {
+	var b protowrite.Builder
+
+	testcases := []struct {
+		Name    string
+		Message *protowrite.Message
+		Enum    *protowrite.Enum
+	}{
+		{
+			Name:    "field number in reserved range",
+			Message: b.Message("Message").ReserveRange(9, 11).StringField("a", 10).MustBuild(),
+		},
+		{
+			Name:    "field number in range to max",
+			Message: b.Message("Message").ReserveRangeToMax(100).StringField("a", 10000).MustBuild(),
+		},
+		{
+			Name:    "oneof field with reserved name",
+			Message: b.Message("Message").ReserveNames("a").OneOfs(b.OneOf("choice").StringField("a", 1).MustBuild()).MustBuild(),
+		},
+		{
+			Name:    "invalid range",
+			Message: b.Message("Message").ReserveRange(11, 9).MustBuild(),
+		},
+		{
+			Name: "enum value in reserved range",
+			Enum: b.Enum("Kind").ReserveNumbers(1).Element("ZERO", 0).Element("ONE", 1).MustBuild(),
+		},
+		{
+			Name: "enum value with reserved name",
+			Enum: b.Enum("Kind").ReserveNames("ZERO").Element("ZERO", 0).MustBuild(),
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			fb := b.File().Package(`foo.bar`)
+			if tc.Message != nil {
+				fb.Messages(tc.Message)
+			}
+			if tc.Enum != nil {
+				fb.Enums(tc.Enum)
+			}
+			file, err := fb.Build()
+			require.NoError(t, err, `builder.Build should succeed`)
+
+			_, err = protowrite.Marshal(file)
+			require.Error(t, err, `protowrite.Marshal should fail`)
+		})
+	}
+}
+
+func TestExtensionRangeErrors(t *testing.T) {
+	var b protowrite.Builder
+
+	base := func() *protowrite.Message {
+		return b.Message("Base").
+			ExtensionRange(100, 199).
+			ExtensionRanges(
+				b.ExtensionRange(1000, 1999).
+					Declaration(1000, ".foo.bar.ext", "string").
+					MustBuild(),
+			).
+			MustBuild()
+	}
+	node := base()
+
+	testcases := []struct {
+		Name      string
+		Proto3    bool
+		Message   *protowrite.Message
+		Extension *protowrite.Extension
+	}{
+		{
+			Name:      "extension of a message node outside of ranges",
+			Message:   node,
+			Extension: b.TypedExtension(node).StringField("a", 200).MustBuild(),
+		},
+		{
+			Name:      "extension of an external type declared in the file",
+			Message:   base(),
+			Extension: b.TypedExtension(protowrite.ExternalMessage("foo.bar.Base")).StringField("a", 200).MustBuild(),
+		},
+		{
+			Name:      "extension number outside of ranges",
+			Message:   base(),
+			Extension: b.Extension("Base").StringField("a", 200).MustBuild(),
+		},
+		{
+			Name:      "undeclared extension number",
+			Message:   base(),
+			Extension: b.Extension(".foo.bar.Base").StringField("a", 1001).MustBuild(),
+		},
+		{
+			Name:    "field within extension range",
+			Message: b.Message("Base").ExtensionRange(100, 199).StringField("a", 150).MustBuild(),
+		},
+		{
+			Name:    "declaration outside of range",
+			Message: b.Message("Base").ExtensionRanges(b.ExtensionRange(100, 199).Declaration(200, ".foo.bar.a", "string").MustBuild()).MustBuild(),
+		},
+		{
+			Name:    "extension ranges in proto3",
+			Proto3:  true,
+			Message: b.Message("Base").ExtensionRange(100, 199).MustBuild(),
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			syntax := protowrite.SyntaxProto2
+			if tc.Proto3 {
+				syntax = protowrite.SyntaxProto3
+			}
+			fb := b.File().Syntax(syntax).Package(`foo.bar`).Messages(tc.Message)
+			if tc.Extension != nil {
+				fb.Extensions(tc.Extension)
+			}
+			file, err := fb.Build()
+			require.NoError(t, err, `builder.Build should succeed`)
+
+			_, err = protowrite.Marshal(file)
+			require.Error(t, err, `protowrite.Marshal should fail`)
+		})
+	}
+}
+
+func TestTypeReferences(t *testing.T) {
+	var b protowrite.Builder
+
+	kind := b.Enum("Kind").Element("KIND_UNSPECIFIED", 0).MustBuild()
+	inner := b.Message("Inner").StringField("name", 1).MustBuild()
+	timestamp := protowrite.ExternalMessage("google.protobuf.Timestamp")
+	outer := b.Message("Outer").
+		Messages(inner, b.Message("google").MustBuild()).
+		Enums(kind).
+		TypedField(inner, "inner", 1).
+		TypedField(kind, "kind", 2).
+		TypedField(timestamp, "created_at", 3).
+		ExtensionRange(100, 199).
+		MustBuild()
+	other := b.Message("Other").
+		TypedField(inner, "inner", 1).
+		TypedField(protowrite.MapOf(protowrite.TypeString, kind), "kinds", 2).
+		TypedField(timestamp, "updated_at", 3).
+		MustBuild()
+
+	file, err := b.File().
+		Syntax(protowrite.SyntaxProto2).
+		Package(`foo.bar`).
+		Import("google/protobuf/timestamp.proto", protowrite.ImportDefault).
+		Extensions(b.TypedExtension(outer).StringField("note", 100).MustBuild()).
+		Messages(outer, other).
+		Services(
+			b.Service("Service").
+				TypedMethod("Get", other, inner).
+				MustBuild(),
+		).
+		Build()
+	require.NoError(t, err, `builder.Build should succeed`)
+
+	t.Run("Shortest names", func(t *testing.T) {
+		buf, err := protowrite.Marshal(file)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+
+		expected, err := os.ReadFile(`testdata/type_references.golden`)
+		require.NoError(t, err, `io.ReadFile should succeed`)
+		require.Equal(t, strings.TrimSpace(string(expected)), string(buf))
+	})
+	t.Run("Fully-qualified names", func(t *testing.T) {
+		buf, err := protowrite.Marshal(file, protowrite.WithFullyQualifiedNames(true))
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Contains(t, string(buf), "optional .foo.bar.Outer.Inner inner = 1;")
+		require.Contains(t, string(buf), "map<string, .foo.bar.Outer.Kind> kinds = 2;")
+		require.Contains(t, string(buf), "rpc Get(.foo.bar.Other) returns (.foo.bar.Outer.Inner);")
+		require.Contains(t, string(buf), "extend .foo.bar.Outer {")
+	})
+	t.Run("Renamed message", func(t *testing.T) {
+		inner.Name = "Renamed"
+		defer func() { inner.Name = "Inner" }()
+
+		buf, err := protowrite.Marshal(file)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Contains(t, string(buf), "optional Outer.Renamed inner = 1;")
+		require.Contains(t, string(buf), "rpc Get(Other) returns (Outer.Renamed);")
+	})
+	t.Run("Undeclared message", func(t *testing.T) {
+		_, err := protowrite.Marshal(&protowrite.File{
+			Package: `foo.bar`,
+			Messages: []*protowrite.Message{
+				b.Message("Message").TypedField(b.Message("Missing").MustBuild(), "missing", 1).MustBuild(),
+			},
+		})
+		require.Error(t, err, `protowrite.Marshal should fail for messages that are not declared in the file`)
+	})
+	t.Run("Descriptor", func(t *testing.T) {
+		fdp, err := protowrite.ToDescriptorProto(file, nil)
+		require.NoError(t, err, `protowrite.ToDescriptorProto should succeed`)
+		require.Equal(t, `.foo.bar.Outer.Inner`, fdp.GetMessageType()[1].GetField()[0].GetTypeName())
+		require.Equal(t, `.google.protobuf.Timestamp`, fdp.GetMessageType()[1].GetField()[2].GetTypeName())
+		require.Equal(t, `.foo.bar.Outer`, fdp.GetExtension()[0].GetExtendee())
 	})
 }
@@ -0,0 +1,366 @@
+package protowrite
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// TypeOptions controls the conversion of Go types into messages
+type TypeOptions struct {
+	// Name is the name of the message created for the type itself.
+	// Defaults to the name of the Go type, and must be set for anonymous
+	// struct types
+	Name string
+	// Syntax is the syntax of the file the messages are added to. Fields
+	// are made optional using the optional label, except in editions
+	// where they are given explicit presence using features instead
+	Syntax Syntax
+}
+
+func (o *TypeOptions) syntax() Syntax {
+	if o == nil {
+		return SyntaxProto3
+	}
+	return o.Syntax
+}
+
+func (o *TypeOptions) name(t reflect.Type) string {
+	if o != nil && o.Name != "" {
+		return o.Name
+	}
+	return t.Name()
+}
+
+var (
+	timeType         = reflect.TypeFor[time.Time]()
+	durationType     = reflect.TypeFor[time.Duration]()
+	protoMessageType = reflect.TypeFor[proto.Message]()
+)
+
+// MessageOf creates a message from the fields of the struct type T,
+// see MessageFromType
+func MessageOf[T any](options *TypeOptions) (*Message, error) {
+	return MessageFromType(reflect.TypeFor[T](), options)
+}
+
+// MessageFromType creates a message from the exported fields of the
+// struct type t, or of the struct t points to.
+//
+// Go kinds are mapped to the corresponding scalar types, with int and uint
+// becoming int64 and uint64. Slices and arrays become repeated fields,
+// except for byte slices which become bytes, maps become map fields,
+// and pointers become optional fields. Set TypeOptions.Syntax for files
+// that use editions, where the optional label is not allowed.
+// time.Time and time.Duration refer to google.protobuf.Timestamp and
+// google.protobuf.Duration, and types generated by protoc-gen-go refer to
+// their own message, so the file must import the corresponding proto
+// files. Other struct types become messages nested in the first message
+// that uses them.
+//
+// Fields are configured with a `protowrite:"name,number,flags..."` tag.
+// The name defaults to the Go field name in snake_case, and the number to
+// the number of the previous field plus one. The flags are "deprecated",
+// and "optional" which makes a field optional without using a pointer.
+// Fields tagged with `protowrite:"-"` are skipped
+func MessageFromType(t reflect.Type, options *TypeOptions) (*Message, error) {
+	c := &typeConverter{messages: make(map[reflect.Type]*Message), syntax: options.syntax()}
+	m, err := c.root(t, options)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MessagesFromType is like MessageFromType, but creates top-level
+// messages for the struct types used by the fields, instead of nesting
+// them. The message for t comes first, followed by the others in the
+// order in which they are first used. Each struct type is converted
+// once, so that fields of the same type share a message
+func MessagesFromType(t reflect.Type, options *TypeOptions) ([]*Message, error) {
+	c := &typeConverter{messages: make(map[reflect.Type]*Message), syntax: options.syntax(), topLevel: true}
+	if _, err := c.root(t, options); err != nil {
+		return nil, err
+	}
+	return c.list, nil
+}
+
+type typeConverter struct {
+	builder Builder
+	// messages maps each struct type to its message. Messages are added
+	// before their fields are converted, so that recursive types refer
+	// to the message being built
+	messages map[reflect.Type]*Message
+	syntax   Syntax
+	topLevel bool
+	// list holds the top-level messages, in the order they were created
+	list []*Message
+}
+
+func (c *typeConverter) root(t reflect.Type, options *TypeOptions) (*Message, error) {
+	if t == nil {
+		return nil, fmt.Errorf(`failed to convert type: type must not be nil`)
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf(`failed to convert type %s: expected a struct type`, t)
+	}
+	name := options.name(t)
+	if name == "" {
+		return nil, fmt.Errorf(`failed to convert type %s: anonymous struct types require TypeOptions.Name`, t)
+	}
+	m, err := c.message(t, name)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to convert type %s: %w`, t, err)
+	}
+	return m, nil
+}
+
+// message converts the struct type t into a message called name, and
+// builds it once all of its fields have been converted
+func (c *typeConverter) message(t reflect.Type, name string) (*Message, error) {
+	mb := c.builder.Message(name)
+	c.messages[t] = mb.object
+	if c.topLevel {
+		c.list = append(c.list, mb.object)
+	}
+
+	number := 0
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag, err := parseTypeTag(sf)
+		if err != nil {
+			return nil, err
+		}
+		if tag.skip {
+			continue
+		}
+		if tag.number > 0 {
+			number = tag.number
+		} else {
+			number++
+		}
+
+		cardinality, typ, err := c.fieldType(mb, sf)
+		if err != nil {
+			return nil, fmt.Errorf(`failed to convert field %s: %w`, sf.Name, err)
+		}
+		if tag.optional {
+			if cardinality == CardinalityRepeated {
+				return nil, fmt.Errorf(`failed to convert field %s: repeated fields cannot be optional`, sf.Name)
+			}
+			if _, ok := typ.(*MapType); ok {
+				return nil, fmt.Errorf(`failed to convert field %s: map fields cannot be optional`, sf.Name)
+			}
+			cardinality = CardinalityOptional
+		}
+
+		fb := c.builder.TypedField(typ, tag.name, number)
+		if cardinality == CardinalityOptional && c.syntax == SyntaxEditions {
+			fb.Features(&FeatureSet{FieldPresence: FieldPresenceExplicit})
+		} else {
+			fb.Cardinality(cardinality)
+		}
+		if tag.deprecated {
+			fb.Deprecated(true)
+		}
+		field, err := fb.Build()
+		if err != nil {
+			return nil, fmt.Errorf(`failed to convert field %s: %w`, sf.Name, err)
+		}
+		mb.Fields(field)
+	}
+	return mb.Build()
+}
+
+// fieldType returns the cardinality and type of a field of type sf.Type
+func (c *typeConverter) fieldType(mb *MessageBuilder, sf reflect.StructField) (FieldCardinality, Type, error) {
+	t := sf.Type
+	cardinality := CardinalityDefault
+	switch {
+	case isBytes(t):
+	// generated messages are always used through pointers, which does not
+	// make them optional
+	case t.Kind() == reflect.Pointer && !t.Implements(protoMessageType):
+		cardinality = CardinalityOptional
+		t = t.Elem()
+		if t.Kind() == reflect.Map || (isList(t) && !isBytes(t)) {
+			return 0, nil, fmt.Errorf(`unsupported pointer type %s`, sf.Type)
+		}
+	case isList(t):
+		cardinality = CardinalityRepeated
+		t = t.Elem()
+		if t.Kind() == reflect.Map || (isList(t) && !isBytes(t)) {
+			return 0, nil, fmt.Errorf(`nested lists are not supported`)
+		}
+	case t.Kind() == reflect.Map:
+		key, ok := scalarTypeOf(t.Key())
+		if !ok || !key.validMapKey() {
+			return 0, nil, fmt.Errorf(`invalid map key type %s`, t.Key())
+		}
+		value := t.Elem()
+		if value.Kind() == reflect.Map || (isList(value) && !isBytes(value)) {
+			return 0, nil, fmt.Errorf(`unsupported map value type %s`, value)
+		}
+		typ, err := c.valueType(mb, value, sf.Name)
+		if err != nil {
+			return 0, nil, err
+		}
+		return cardinality, MapOf(key, typ), nil
+	}
+
+	typ, err := c.valueType(mb, t, sf.Name)
+	if err != nil {
+		return 0, nil, err
+	}
+	return cardinality, typ, nil
+}
+
+// valueType returns the type of a single value of type t. Struct types
+// are converted into messages, named after fieldName if they are anonymous
+func (c *typeConverter) valueType(mb *MessageBuilder, t reflect.Type, fieldName string) (Type, error) {
+	if t.Kind() == reflect.Pointer {
+		// elements of lists and maps can be pointers to structs
+		if t.Elem().Kind() != reflect.Struct {
+			return nil, fmt.Errorf(`unsupported type %s`, t)
+		}
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return ExternalMessage("google.protobuf.Timestamp"), nil
+	case durationType:
+		return ExternalMessage("google.protobuf.Duration"), nil
+	}
+	if ptr := reflect.PointerTo(t); ptr.Implements(protoMessageType) {
+		msg := reflect.Zero(ptr).Interface().(proto.Message)
+		return ExternalMessage(string(msg.ProtoReflect().Descriptor().FullName())), nil
+	}
+	if typ, ok := scalarTypeOf(t); ok {
+		return typ, nil
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf(`unsupported type %s`, t)
+	}
+
+	if m, ok := c.messages[t]; ok {
+		return m, nil
+	}
+	name := t.Name()
+	if name == "" {
+		name = fieldName
+	}
+	m, err := c.message(t, name)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to convert type %s: %w`, t, err)
+	}
+	if !c.topLevel {
+		mb.Messages(m)
+	}
+	return m, nil
+}
+
+// scalarTypeOf returns the scalar type used for values of kind t.Kind()
+func scalarTypeOf(t reflect.Type) (ScalarType, bool) {
+	if isBytes(t) {
+		return TypeBytes, true
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return TypeBool, true
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return TypeInt32, true
+	case reflect.Int, reflect.Int64:
+		return TypeInt64, true
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return TypeUint32, true
+	case reflect.Uint, reflect.Uint64:
+		return TypeUint64, true
+	case reflect.Float32:
+		return TypeFloat, true
+	case reflect.Float64:
+		return TypeDouble, true
+	case reflect.String:
+		return TypeString, true
+	default:
+		return "", false
+	}
+}
+
+func isList(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice || t.Kind() == reflect.Array
+}
+
+// isBytes returns true for byte slices and arrays, which are written as
+// a single bytes value
+func isBytes(t reflect.Type) bool {
+	return isList(t) && t.Elem().Kind() == reflect.Uint8
+}
+
+type typeTag struct {
+	skip       bool
+	name       string
+	number     int
+	deprecated bool
+	optional   bool
+}
+
+// parseTypeTag parses the protowrite tag of sf
+func parseTypeTag(sf reflect.StructField) (typeTag, error) {
+	value := sf.Tag.Get("protowrite")
+	if value == "-" {
+		return typeTag{skip: true}, nil
+	}
+
+	parts := strings.Split(value, ",")
+	tag := typeTag{name: parts[0]}
+	if tag.name == "" {
+		tag.name = snakeCase(sf.Name)
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n <= 0 {
+			return typeTag{}, fmt.Errorf(`invalid field number %q in tag of field %s`, parts[1], sf.Name)
+		}
+		tag.number = n
+	}
+	for i := 2; i < len(parts); i++ {
+		switch parts[i] {
+		case "deprecated":
+			tag.deprecated = true
+		case "optional":
+			tag.optional = true
+		default:
+			return typeTag{}, fmt.Errorf(`unknown flag %q in tag of field %s`, parts[i], sf.Name)
+		}
+	}
+	return tag, nil
+}
+
+// snakeCase converts a Go identifier such as UserID into user_id
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// start a new word after a lower case letter or a digit, and
+			// before the last upper case letter of an acronym
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,194 @@
+package protowrite_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/protowrite"
+	"github.com/stretchr/testify/require"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type reflectAddress struct {
+	City    string
+	ZipCode string `protowrite:"zip,5"`
+}
+
+type reflectUser struct {
+	ID        uint64 `protowrite:"user_id"`
+	Name      string
+	Nickname  *string
+	Age       int32 `protowrite:",,optional"`
+	Score     float64
+	Avatar    []byte
+	Tags      []string
+	Labels    map[string]int
+	Home      reflectAddress `protowrite:",10"`
+	Others    []*reflectAddress
+	CreatedAt time.Time
+	Timeout   time.Duration
+	Metadata  *structpb.Struct
+	Legacy    bool   `protowrite:"old,,deprecated"`
+	Ignored   string `protowrite:"-"`
+	internal  int
+}
+
+type reflectNode struct {
+	Value    string
+	Children []*reflectNode
+}
+
+func TestMessageFromType(t *testing.T) {
+	newFile := func(messages ...*protowrite.Message) *protowrite.File {
+		var b protowrite.Builder
+		return b.File().
+			Package(`foo.bar`).
+			Import("google/protobuf/duration.proto", protowrite.ImportDefault).
+			Import("google/protobuf/struct.proto", protowrite.ImportDefault).
+			Import("google/protobuf/timestamp.proto", protowrite.ImportDefault).
+			Messages(messages...).
+			MustBuild()
+	}
+
+	const user = `message reflectUser {
+    message reflectAddress {
+        string city = 1;
+        string zip = 5;
+    }
+    uint64 user_id = 1;
+    string name = 2;
+    optional string nickname = 3;
+    optional int32 age = 4;
+    double score = 5;
+    bytes avatar = 6;
+    repeated string tags = 7;
+    map<string, int64> labels = 8;
+    reflectAddress home = 10;
+    repeated reflectAddress others = 11;
+    google.protobuf.Timestamp created_at = 12;
+    google.protobuf.Duration timeout = 13;
+    google.protobuf.Struct metadata = 14;
+    bool old = 15 [deprecated = true];
+}`
+
+	t.Run("Nested", func(t *testing.T) {
+		msg, err := protowrite.MessageOf[reflectUser](nil)
+		require.NoError(t, err, `protowrite.MessageOf should succeed`)
+
+		file := newFile(msg)
+		require.NoError(t, file.Resolve(nil), `file.Resolve should succeed`)
+		buf, err := protowrite.Marshal(file)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Contains(t, string(buf), user)
+	})
+	t.Run("Editions", func(t *testing.T) {
+		newEditionsFile := func(msg *protowrite.Message) *protowrite.File {
+			file := newFile(msg)
+			file.Syntax = protowrite.SyntaxEditions
+			return file
+		}
+
+		// the optional label is not allowed in editions
+		msg, err := protowrite.MessageOf[reflectUser](nil)
+		require.NoError(t, err, `protowrite.MessageOf should succeed`)
+		_, err = protowrite.Marshal(newEditionsFile(msg))
+		require.Error(t, err, `protowrite.Marshal should fail`)
+
+		msg, err = protowrite.MessageOf[reflectUser](&protowrite.TypeOptions{Syntax: protowrite.SyntaxEditions})
+		require.NoError(t, err, `protowrite.MessageOf should succeed`)
+		file := newEditionsFile(msg)
+		buf, err := protowrite.Marshal(file)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		// presence is explicit by default, so the feature is not written
+		require.Contains(t, string(buf), "    string nickname = 3;\n")
+
+		file.Features = &protowrite.FeatureSet{FieldPresence: protowrite.FieldPresenceImplicit}
+		buf, err = protowrite.Marshal(file)
+		require.NoError(t, err, `protowrite.Marshal should succeed`)
+		require.Contains(t, string(buf), "    string name = 2;\n")
+		require.Contains(t, string(buf), "    string nickname = 3 [features.field_presence = EXPLICIT];\n")
+		require.Contains(t, string(buf), "    int32 age = 4 [features.field_presence = EXPLICIT];\n")
+	})
+	t.Run("Top-level", func(t *testing.T) {
+		list, err := protowrite.MessagesFromType(reflect.TypeFor[*reflectUser](), &protowrite.TypeOptions{Name: "User"})
+		require.NoError(t, err, `protowrite.MessagesFromType should succeed`)
+		require.Len(t, list, 2)
+		require.Equal(t, "User", list[0].Name)
+		require.Equal(t, "reflectAddress", list[1].Name)
+		require.Empty(t, list[0].Messages)
+		// both fields refer to the same message
+		require.Same(t, list[1], list[0].Fields[8].Type)
+		require.Same(t, list[1], list[0].Fields[9].Type)
+
+		require.NoError(t, newFile(list...).Resolve(nil), `file.Resolve should succeed`)
+	})
+	t.Run("Recursive type", func(t *testing.T) {
+		msg, err := protowrite.MessageOf[reflectNode](nil)
+		require.NoError(t, err, `protowrite.MessageOf should succeed`)
+		require.Same(t, msg, msg.Fields[1].Type)
+
+		buf, err := protowrite.MarshalNode(msg)
+		require.NoError(t, err, `protowrite.MarshalNode should succeed`)
+		require.Equal(t, `message reflectNode {
+    string value = 1;
+    repeated reflectNode children = 2;
+}`, string(buf))
+	})
+	t.Run("Anonymous struct", func(t *testing.T) {
+		type anonymous = struct {
+			Point struct {
+				X, Y int32
+			}
+		}
+		_, err := protowrite.MessageOf[anonymous](nil)
+		require.Error(t, err, `protowrite.MessageOf should fail without a name`)
+
+		msg, err := protowrite.MessageOf[anonymous](&protowrite.TypeOptions{Name: "Shape"})
+		require.NoError(t, err, `protowrite.MessageOf should succeed`)
+		require.Equal(t, "Shape", msg.Name)
+		require.Equal(t, "Point", msg.Messages[0].Name)
+		require.Equal(t, []string{"x", "y"}, []string{msg.Messages[0].Fields[0].Name, msg.Messages[0].Fields[1].Name})
+	})
+	t.Run("Field names", func(t *testing.T) {
+		type names struct {
+			UserID     string
+			HTTPServer string
+			Name2      string
+			X          string
+		}
+		msg, err := protowrite.MessageOf[names](nil)
+		require.NoError(t, err, `protowrite.MessageOf should succeed`)
+		var list []string
+		for _, f := range msg.Fields {
+			list = append(list, f.Name)
+		}
+		require.Equal(t, []string{"user_id", "http_server", "name2", "x"}, list)
+	})
+	t.Run("Errors", func(t *testing.T) {
+		for _, typ := range []reflect.Type{
+			reflect.TypeFor[int](),
+			reflect.TypeFor[struct{ Channel chan int }](),
+			reflect.TypeFor[struct{ Matrix [][]int }](),
+			reflect.TypeFor[struct{ Points map[float64]string }](),
+			reflect.TypeFor[struct{ Nested map[string][]string }](),
+			reflect.TypeFor[struct {
+				List []string `protowrite:",,optional"`
+			}](),
+			reflect.TypeFor[struct {
+				ID string `protowrite:",zero"`
+			}](),
+			reflect.TypeFor[struct {
+				ID string `protowrite:",,unknown"`
+			}](),
+			reflect.TypeFor[struct {
+				A string `protowrite:",2"`
+				B string `protowrite:",2"`
+			}](),
+		} {
+			_, err := protowrite.MessageFromType(typ, &protowrite.TypeOptions{Name: "Invalid"})
+			require.Error(t, err, `protowrite.MessageFromType should fail for %s`, typ)
+		}
+	})
+}
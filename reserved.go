@@ -0,0 +1,138 @@
+package protowrite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxFieldNumber is the largest field number allowed in a message
+	MaxFieldNumber = 536870911
+	// MaxEnumValue is the largest value allowed in an enum
+	MaxEnumValue = 2147483647
+	// MinEnumValue is the smallest value allowed in an enum
+	MinEnumValue = -2147483648
+)
+
+// Range represents a range of field numbers or enum values. Both Start and End are
+// inclusive. A range containing a single number has the same Start and End.
+// If ToMax is true, End is ignored and the range extends to the largest
+// allowed value, which is written as `max`
+type Range struct {
+	Start int
+	End   int
+	ToMax bool
+}
+
+// contains returns true if n is within the range. max is the value
+// that `max` stands for in the context where the range is used
+func (r *Range) contains(n, max int) bool {
+	return n >= r.Start && n <= r.end(max)
+}
+
+func (r *Range) end(max int) int {
+	if r.ToMax {
+		return max
+	}
+	return r.End
+}
+
+func (r *Range) validate(min, max int) error {
+	if r.Start < min {
+		return fmt.Errorf(`range start %d is smaller than %d`, r.Start, min)
+	}
+	if end := r.end(max); end > max {
+		return fmt.Errorf(`range end %d is larger than %d`, end, max)
+	} else if end < r.Start {
+		return fmt.Errorf(`range end %d is smaller than range start %d`, end, r.Start)
+	}
+	return nil
+}
+
+func (r *Range) String() string {
+	switch {
+	case r.ToMax:
+		return strconv.Itoa(r.Start) + " to max"
+	case r.Start == r.End:
+		return strconv.Itoa(r.Start)
+	default:
+		return strconv.Itoa(r.Start) + " to " + strconv.Itoa(r.End)
+	}
+}
+
+func formatRanges(list []*Range) string {
+	var sb strings.Builder
+	for i, r := range list {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(r.String())
+	}
+	return sb.String()
+}
+
+// Reserved is a reserved statement in the body of a Message. It records
+// where the statement appears in Message.Body, while the reserved numbers
+// and names themselves are held by Message.ReservedRanges and
+// Message.ReservedNames. Ranges and names that have been removed from the
+// message are not written
+type Reserved struct {
+	Ranges []*Range
+	Names  []string
+}
+
+func (r *Reserved) encode(e *encodeState) error {
+	encodeReserved(e, r.Ranges, r.Names)
+	return nil
+}
+
+// encodeReserved writes the `reserved` statements for the given ranges and names
+func encodeReserved(e *encodeState, ranges []*Range, names []string) {
+	if len(ranges) > 0 {
+		e.newline()
+		e.WriteString("reserved ")
+		e.WriteString(formatRanges(ranges))
+		e.WriteByte(';')
+	}
+	if len(names) > 0 {
+		e.newline()
+		e.WriteString("reserved ")
+		for i, name := range names {
+			if i > 0 {
+				e.WriteString(", ")
+			}
+			// Editions use identifiers instead of string literals for reserved names
+			if e.syntax == SyntaxEditions {
+				e.WriteString(name)
+			} else {
+				e.writeQuoted(name)
+			}
+		}
+		e.WriteByte(';')
+	}
+}
+
+// checkReserved returns an error if either number or name has been reserved
+func checkReserved(ranges []*Range, names []string, max int, number int, name string) error {
+	for _, r := range ranges {
+		if r.contains(number, max) {
+			return fmt.Errorf(`number %d is reserved (%s)`, number, r)
+		}
+	}
+	for _, reserved := range names {
+		if reserved == name {
+			return fmt.Errorf(`name %q is reserved`, name)
+		}
+	}
+	return nil
+}
+
+func validateReservedRanges(ranges []*Range, min, max int) error {
+	for i, r := range ranges {
+		if err := r.validate(min, max); err != nil {
+			return fmt.Errorf(`invalid reserved range %d: %w`, i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,470 @@
+package protowrite
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type symbolKind int
+
+const (
+	symbolPackage symbolKind = iota + 1
+	symbolMessage
+	symbolEnum
+	symbolEnumValue
+	symbolField
+	symbolOneOf
+	symbolExtension
+	symbolService
+	symbolMethod
+)
+
+func (k symbolKind) String() string {
+	switch k {
+	case symbolPackage:
+		return "package"
+	case symbolMessage:
+		return "message"
+	case symbolEnum:
+		return "enum"
+	case symbolEnumValue:
+		return "enum value"
+	case symbolField:
+		return "field"
+	case symbolOneOf:
+		return "oneof"
+	case symbolExtension:
+		return "extension"
+	case symbolService:
+		return "service"
+	case symbolMethod:
+		return "method"
+	default:
+		return "unknown symbol"
+	}
+}
+
+func (k symbolKind) isType() bool {
+	return k == symbolMessage || k == symbolEnum
+}
+
+// isAggregate returns true if symbols of this kind can contain other symbols
+func (k symbolKind) isAggregate() bool {
+	switch k {
+	case symbolPackage, symbolMessage, symbolEnum, symbolService:
+		return true
+	default:
+		return false
+	}
+}
+
+// symbolTable maps fully-qualified names (without the leading dot) to
+// the kind of the symbol that they refer to
+type symbolTable map[string]symbolKind
+
+func joinName(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func parentScope(scope string) string {
+	if i := strings.LastIndexByte(scope, '.'); i >= 0 {
+		return scope[:i]
+	}
+	return ""
+}
+
+func (st symbolTable) addPackage(pkg string) {
+	for pkg != "" {
+		st[pkg] = symbolPackage
+		pkg = parentScope(pkg)
+	}
+}
+
+// addFile registers all symbols declared in f
+func (st symbolTable) addFile(f *File) {
+	st.addPackage(f.Package)
+	st.addMessages(f.Package, f.Messages)
+	st.addEnums(f.Package, f.Enums)
+	st.addExtensions(f.Package, f.Extensions)
+	for _, s := range f.Services {
+		name := joinName(f.Package, s.Name)
+		st[name] = symbolService
+		for _, m := range s.Methods {
+			st[joinName(name, m.Name)] = symbolMethod
+		}
+	}
+}
+
+func (st symbolTable) addMessages(scope string, list []*Message) {
+	for _, m := range list {
+		name := joinName(scope, m.Name)
+		st[name] = symbolMessage
+		for _, field := range m.Fields {
+			st[joinName(name, field.Name)] = symbolField
+		}
+		for _, oneof := range m.OneOfs {
+			st[joinName(name, oneof.Name)] = symbolOneOf
+			for _, field := range oneof.Fields {
+				st[joinName(name, field.Name)] = symbolField
+			}
+		}
+		st.addMessages(name, m.Messages)
+		st.addEnums(name, m.Enums)
+		st.addExtensions(name, m.Extensions)
+	}
+}
+
+func (st symbolTable) addEnums(scope string, list []*Enum) {
+	for _, e := range list {
+		st[joinName(scope, e.Name)] = symbolEnum
+		// enum values are siblings of their enum, not children
+		for _, v := range e.Elements {
+			st[joinName(scope, v.Name)] = symbolEnumValue
+		}
+	}
+}
+
+func (st symbolTable) addExtensions(scope string, list []*Extension) {
+	for _, ext := range list {
+		for _, field := range ext.Fields {
+			st[joinName(scope, field.Name)] = symbolExtension
+		}
+	}
+}
+
+// addDescriptor registers all symbols declared in fd
+func (st symbolTable) addDescriptor(fd protoreflect.FileDescriptor) {
+	st.addPackage(string(fd.Package()))
+	st.addMessageDescriptors(fd.Messages())
+	st.addEnumDescriptors(fd.Enums())
+	st.addExtensionDescriptors(fd.Extensions())
+	for i := 0; i < fd.Services().Len(); i++ {
+		s := fd.Services().Get(i)
+		st[string(s.FullName())] = symbolService
+		for j := 0; j < s.Methods().Len(); j++ {
+			st[string(s.Methods().Get(j).FullName())] = symbolMethod
+		}
+	}
+}
+
+func (st symbolTable) addMessageDescriptors(list protoreflect.MessageDescriptors) {
+	for i := 0; i < list.Len(); i++ {
+		md := list.Get(i)
+		st[string(md.FullName())] = symbolMessage
+		for j := 0; j < md.Fields().Len(); j++ {
+			st[string(md.Fields().Get(j).FullName())] = symbolField
+		}
+		for j := 0; j < md.Oneofs().Len(); j++ {
+			st[string(md.Oneofs().Get(j).FullName())] = symbolOneOf
+		}
+		st.addMessageDescriptors(md.Messages())
+		st.addEnumDescriptors(md.Enums())
+		st.addExtensionDescriptors(md.Extensions())
+	}
+}
+
+func (st symbolTable) addEnumDescriptors(list protoreflect.EnumDescriptors) {
+	for i := 0; i < list.Len(); i++ {
+		ed := list.Get(i)
+		st[string(ed.FullName())] = symbolEnum
+		for j := 0; j < ed.Values().Len(); j++ {
+			st[string(ed.Values().Get(j).FullName())] = symbolEnumValue
+		}
+	}
+}
+
+func (st symbolTable) addExtensionDescriptors(list protoreflect.ExtensionDescriptors) {
+	for i := 0; i < list.Len(); i++ {
+		st[string(list.Get(i).FullName())] = symbolExtension
+	}
+}
+
+// resolve looks up name from within scope, following the protobuf scoping
+// rules: the first component of a relative name is searched for starting
+// from the innermost scope, moving outwards. Once the first component is
+// found, the rest of the name must be found within it.
+//
+// If typesOnly is true, single component names that refer to something
+// other than a message or an enum are skipped over.
+//
+// It returns the fully-qualified name (without the leading dot) and the
+// kind of the symbol.
+func (st symbolTable) resolve(scope, name string, typesOnly bool) (string, symbolKind, bool) {
+	if strings.HasPrefix(name, ".") {
+		kind, ok := st[name[1:]]
+		return name[1:], kind, ok
+	}
+
+	first := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		first = name[:i]
+	}
+
+	for {
+		candidate := joinName(scope, first)
+		if kind, ok := st[candidate]; ok {
+			if first == name {
+				if !typesOnly || kind.isType() {
+					return candidate, kind, true
+				}
+			} else if kind.isAggregate() {
+				full := joinName(scope, name)
+				kind, ok := st[full]
+				return full, kind, ok
+			}
+		}
+		if scope == "" {
+			return "", 0, false
+		}
+		scope = parentScope(scope)
+	}
+}
+
+// shadowed explains why name could not be resolved from within scope when
+// it would have been found from an outer scope: the first component of the
+// name was found in an inner scope first (blocker), and protobuf does not
+// continue searching outwards once it has been found. hidden is the
+// fully-qualified name that was probably meant
+func (st symbolTable) shadowed(scope, name string) (blocker, hidden string, ok bool) {
+	if strings.HasPrefix(name, ".") {
+		return "", "", false
+	}
+	first := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		first = name[:i]
+	}
+	for {
+		if blocker == "" {
+			if kind, ok := st[joinName(scope, first)]; ok && kind.isAggregate() {
+				blocker = joinName(scope, first)
+			}
+		}
+		if blocker != "" {
+			candidate := joinName(scope, name)
+			if kind, ok := st[candidate]; ok && kind.isType() && candidate != blocker {
+				return blocker, candidate, true
+			}
+		}
+		if scope == "" {
+			return "", "", false
+		}
+		scope = parentScope(scope)
+	}
+}
+
+// shortestName returns the shortest name that resolves to the fully-qualified
+// name full (without the leading dot) from within scope. If no such name
+// exists, the fully-qualified name is returned with a leading dot
+func (st symbolTable) shortestName(scope, full string, typesOnly bool) string {
+	parts := strings.Split(full, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		candidate := strings.Join(parts[i:], ".")
+		if resolved, _, ok := st.resolve(scope, candidate, typesOnly); ok && resolved == full {
+			return candidate
+		}
+	}
+	return "." + full
+}
+
+// typeNames knows the fully-qualified names of the messages and enums
+// declared in a File, and computes the names used to refer to them
+type typeNames struct {
+	symbols symbolTable
+	nodes   map[Type]string
+	// fullyQualified is true if references should always be written
+	// as fully-qualified names with a leading dot
+	fullyQualified bool
+}
+
+func newTypeNames(f *File) *typeNames {
+	tn := &typeNames{
+		symbols: make(symbolTable),
+		nodes:   make(map[Type]string),
+	}
+	tn.symbols.addFile(f)
+	tn.addMessages(f.Package, f.Messages)
+	tn.addEnums(f.Package, f.Enums)
+
+	// external types are not declared in f, but they must be known to
+	// the symbol table so that the shortest names for them can be found
+	tn.addExternals(f)
+	return tn
+}
+
+func (tn *typeNames) addMessages(scope string, list []*Message) {
+	for _, m := range list {
+		name := joinName(scope, m.Name)
+		tn.nodes[m] = name
+		tn.addMessages(name, m.Messages)
+		tn.addEnums(name, m.Enums)
+	}
+}
+
+func (tn *typeNames) addEnums(scope string, list []*Enum) {
+	for _, e := range list {
+		tn.nodes[e] = joinName(scope, e.Name)
+	}
+}
+
+func (tn *typeNames) addExternals(f *File) {
+	var addType func(Type)
+	addType = func(typ Type) {
+		switch typ := typ.(type) {
+		case ExternalType:
+			full := typ.String()
+			if _, ok := tn.symbols[full]; ok {
+				return
+			}
+			tn.symbols.addPackage(parentScope(full))
+			if typ.Kind == RefEnum {
+				tn.symbols[full] = symbolEnum
+			} else {
+				tn.symbols[full] = symbolMessage
+			}
+		case *MapType:
+			addType(typ.Key)
+			addType(typ.Value)
+		}
+	}
+	addFields := func(list []*Field) {
+		for _, field := range list {
+			addType(field.Type)
+		}
+	}
+	addExtensions := func(list []*Extension) {
+		for _, ext := range list {
+			addType(ext.Target)
+			addFields(ext.Fields)
+		}
+	}
+	var addMessages func([]*Message)
+	addMessages = func(list []*Message) {
+		for _, m := range list {
+			addFields(m.Fields)
+			for _, oneof := range m.OneOfs {
+				addFields(oneof.Fields)
+			}
+			addExtensions(m.Extensions)
+			addMessages(m.Messages)
+		}
+	}
+	addMessages(f.Messages)
+	addExtensions(f.Extensions)
+	for _, s := range f.Services {
+		for _, m := range s.Methods {
+			addType(m.Input)
+			addType(m.Output)
+		}
+	}
+}
+
+// addReferences registers the messages and enums that node refers to,
+// but that are not declared within it, as top-level declarations
+func (tn *typeNames) addReferences(node Node) {
+	var addType func(Type)
+	addType = func(typ Type) {
+		switch typ := typ.(type) {
+		case *Message:
+			if _, ok := tn.nodes[typ]; !ok {
+				tn.symbols.addMessages("", []*Message{typ})
+				tn.addMessages("", []*Message{typ})
+			}
+		case *Enum:
+			if _, ok := tn.nodes[typ]; !ok {
+				tn.symbols.addEnums("", []*Enum{typ})
+				tn.addEnums("", []*Enum{typ})
+			}
+		case *MapType:
+			addType(typ.Key)
+			addType(typ.Value)
+		}
+	}
+	addFields := func(list []*Field) {
+		for _, field := range list {
+			addType(field.Type)
+		}
+	}
+	var addMessage func(*Message)
+	addMessage = func(m *Message) {
+		addFields(m.Fields)
+		for _, oneof := range m.OneOfs {
+			addFields(oneof.Fields)
+		}
+		for _, ext := range m.Extensions {
+			addType(ext.Target)
+			addFields(ext.Fields)
+		}
+		for _, nested := range m.Messages {
+			addMessage(nested)
+		}
+	}
+
+	switch node := node.(type) {
+	case *Message:
+		addMessage(node)
+	case *Service:
+		for _, m := range node.Methods {
+			addType(m.Input)
+			addType(m.Output)
+		}
+	}
+}
+
+// fullName returns the fully-qualified name (without the leading dot)
+// of a message, enum or external type
+func (tn *typeNames) fullName(typ Type) (string, error) {
+	switch typ := typ.(type) {
+	case ExternalType:
+		return typ.String(), nil
+	case *Message, *Enum:
+		full, ok := tn.nodes[typ]
+		if !ok {
+			return "", fmt.Errorf(`%s %q is not declared in this file (use ExternalType to refer to types declared in other files)`, nodeKind(typ), typ)
+		}
+		return full, nil
+	default:
+		return "", fmt.Errorf(`%q is not a message or an enum`, typeString(typ))
+	}
+}
+
+// name returns the name that should be written to refer to typ
+// from within scope
+func (tn *typeNames) name(scope string, typ Type) (string, error) {
+	switch typ := typ.(type) {
+	case nil:
+		return "", fmt.Errorf(`type must be specified`)
+	case *MapType:
+		key, err := tn.name(scope, typ.Key)
+		if err != nil {
+			return "", err
+		}
+		value, err := tn.name(scope, typ.Value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("map<%s, %s>", key, value), nil
+	case *Message, *Enum, ExternalType:
+		full, err := tn.fullName(typ)
+		if err != nil {
+			return "", err
+		}
+		if tn.fullyQualified {
+			return "." + full, nil
+		}
+		return tn.symbols.shortestName(scope, full, true), nil
+	default:
+		return typ.String(), nil
+	}
+}
+
+func nodeKind(typ Type) string {
+	if _, ok := typ.(*Enum); ok {
+		return "enum"
+	}
+	return "message"
+}
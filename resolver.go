@@ -0,0 +1,250 @@
+package protowrite
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ResolveOptions specifies where the imports of a File are found
+// when resolving its type references
+type ResolveOptions struct {
+	// Imports maps import paths to Files. They are searched before Resolver
+	Imports map[string]*File
+	// Resolver is used to find imports that are not listed in Imports.
+	// The default is protoregistry.GlobalFiles
+	Resolver protodesc.Resolver
+}
+
+func (o *ResolveOptions) file(path string) (*File, bool) {
+	if o == nil {
+		return nil, false
+	}
+	f, ok := o.Imports[path]
+	return f, ok
+}
+
+func (o *ResolveOptions) resolver() protodesc.Resolver {
+	if o != nil && o.Resolver != nil {
+		return o.Resolver
+	}
+	return protoregistry.GlobalFiles
+}
+
+// Resolve checks that every type reference in f refers to something,
+// following the protobuf scoping rules for relative, nested and
+// fully-qualified names. The types that can be referred to are those
+// declared in f, in the files it imports, and in the files that those
+// files import publicly.
+//
+// Every field type, method input and output, and extendee is checked.
+// The returned ValidationErrors lists each reference that cannot be
+// resolved, that resolves to the wrong kind of declaration, or that is
+// ambiguous: one whose first component is hidden by a declaration in an
+// inner scope, or whose target is declared in more than one file.
+func (f *File) Resolve(options *ResolveOptions) error {
+	r := &resolver{
+		options: options,
+		symbols: make(symbolTable),
+		origins: make(map[string][]string),
+		nodes:   make(map[Type]string),
+		visited: make(map[string]struct{}),
+	}
+	r.addFile("", f)
+	for _, imp := range f.Imports {
+		r.addImport(f.Package, imp.Path)
+	}
+
+	for _, ext := range f.Extensions {
+		r.extension(f.Package, ext)
+	}
+	r.messages(f.Package, f.Messages)
+	for _, s := range f.Services {
+		for _, m := range s.Methods {
+			path := joinName(joinName(f.Package, s.Name), m.Name)
+			r.reference(path, f.Package, m.Input, RefMessage)
+			r.reference(path, f.Package, m.Output, RefMessage)
+		}
+	}
+	return r.errs.err()
+}
+
+type resolver struct {
+	options *ResolveOptions
+	symbols symbolTable
+	// origins lists the files that declare each symbol, so that symbols
+	// declared more than once can be reported
+	origins map[string][]string
+	// nodes holds the fully-qualified names of the messages and enums
+	// declared in the File and in the imported Files
+	nodes   map[Type]string
+	visited map[string]struct{}
+	errs    ValidationErrors
+}
+
+// addSymbols merges the symbols declared in a single file into the
+// symbol table, recording where they came from
+func (r *resolver) addSymbols(origin string, st symbolTable) {
+	for name, kind := range st {
+		if kind == symbolPackage {
+			if _, ok := r.symbols[name]; !ok {
+				r.symbols[name] = kind
+			}
+			continue
+		}
+		r.symbols[name] = kind
+		r.origins[name] = append(r.origins[name], origin)
+	}
+}
+
+func (r *resolver) addFile(path string, f *File) {
+	st := make(symbolTable)
+	st.addFile(f)
+	r.addSymbols(path, st)
+
+	for node, name := range newTypeNames(f).nodes {
+		r.nodes[node] = name
+	}
+}
+
+// addImport makes the symbols of the file at path visible, along with
+// those of the files it imports publicly
+func (r *resolver) addImport(scope, path string) {
+	if _, ok := r.visited[path]; ok {
+		return
+	}
+	r.visited[path] = struct{}{}
+
+	if f, ok := r.options.file(path); ok {
+		r.addFile(path, f)
+		for _, imp := range f.Imports {
+			if imp.Type == ImportPublic {
+				r.addImport(scope, imp.Path)
+			}
+		}
+		return
+	}
+
+	fd, err := r.options.resolver().FindFileByPath(path)
+	if err != nil {
+		r.errs.addf(scope, `failed to resolve import %q: %s`, path, err)
+		return
+	}
+	r.addDescriptor(fd)
+}
+
+func (r *resolver) addDescriptor(fd protoreflect.FileDescriptor) {
+	st := make(symbolTable)
+	st.addDescriptor(fd)
+	r.addSymbols(fd.Path(), st)
+
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		imp := imports.Get(i)
+		if _, ok := r.visited[imp.Path()]; !imp.IsPublic || ok {
+			continue
+		}
+		r.visited[imp.Path()] = struct{}{}
+		r.addDescriptor(imp.FileDescriptor)
+	}
+}
+
+func (r *resolver) messages(scope string, list []*Message) {
+	for _, m := range list {
+		name := joinName(scope, m.Name)
+		for _, oneof := range m.OneOfs {
+			r.fields(name, oneof.Fields)
+		}
+		r.fields(name, m.Fields)
+		for _, ext := range m.Extensions {
+			r.extension(name, ext)
+		}
+		r.messages(name, m.Messages)
+	}
+}
+
+func (r *resolver) fields(scope string, list []*Field) {
+	for _, field := range list {
+		r.reference(joinName(scope, field.Name), scope, field.Type, RefUnknown)
+	}
+}
+
+func (r *resolver) extension(scope string, ext *Extension) {
+	target := ext.Target
+	if target == nil {
+		target = TypeRef{Name: ext.Name}
+	}
+	r.reference(scope, scope, target, RefMessage)
+	for _, field := range ext.Fields {
+		r.reference(joinName(scope, field.Name), scope, field.Type, RefUnknown)
+	}
+}
+
+// reference checks a single type reference used within scope. want is
+// RefMessage if the reference must be to a message, and RefUnknown if it
+// may be to a message or an enum
+func (r *resolver) reference(path, scope string, typ Type, want RefKind) {
+	switch typ := typ.(type) {
+	case nil:
+		r.errs.addf(path, `type must be specified`)
+	case ScalarType:
+		if want == RefMessage {
+			r.errs.addf(path, `%q is not a message`, typ)
+		}
+	case *MapType:
+		r.reference(path, scope, typ.Key, want)
+		r.reference(path, scope, typ.Value, want)
+	case *Message, *Enum:
+		full, ok := r.nodes[typ]
+		if !ok {
+			r.errs.addf(path, `%s %q is not declared in this file or its imports`, nodeKind(typ), typ)
+			return
+		}
+		if _, ok := typ.(*Enum); ok && want == RefMessage {
+			r.errs.addf(path, `%q is an enum, not a message`, full)
+		}
+	case ExternalType:
+		r.resolve(path, scope, "."+typ.String(), typ.Kind, want)
+	case TypeRef:
+		r.resolve(path, scope, typ.Name, typ.Kind, want)
+	}
+}
+
+func (r *resolver) resolve(path, scope, name string, kind, want RefKind) {
+	full, found, ok := r.symbols.resolve(scope, name, true)
+	if !ok {
+		if blocker, hidden, ok := r.symbols.shadowed(scope, name); ok {
+			r.errs.addf(path, `%q is ambiguous: %q hides %q`, name, blocker, hidden)
+			return
+		}
+		r.errs.addf(path, `%q cannot be resolved from %q`, name, scope)
+		return
+	}
+	if !found.isType() {
+		r.errs.addf(path, `%q resolves to %s %q, which is not a type`, name, found, full)
+		return
+	}
+	if origins := r.origins[full]; len(origins) > 1 {
+		sorted := append([]string(nil), origins...)
+		sort.Strings(sorted)
+		for i, origin := range sorted {
+			if origin == "" {
+				sorted[i] = "this file"
+			} else {
+				sorted[i] = fmt.Sprintf("%q", origin)
+			}
+		}
+		r.errs.addf(path, `%q is ambiguous: %q is declared in %s`, name, full, strings.Join(sorted, " and "))
+		return
+	}
+	for _, k := range []RefKind{want, kind} {
+		if (k == RefMessage && found != symbolMessage) || (k == RefEnum && found != symbolEnum) {
+			r.errs.addf(path, `%q resolves to %s %q, expected a %s`, name, found, full, k)
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package protowrite_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lestrrat-go/protowrite"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	var b protowrite.Builder
+
+	// common.proto publicly imports types.proto, so the types declared
+	// in types.proto are visible to files that import common.proto
+	types := b.File().
+		Package(`foo.types`).
+		Enums(b.Enum("Color").Element("COLOR_UNSPECIFIED", 0).MustBuild()).
+		Messages(b.Message("Point").Int32Field("x", 1).Int32Field("y", 2).MustBuild()).
+		MustBuild()
+	common := b.File().
+		Package(`foo.common`).
+		Import("foo/types.proto", protowrite.ImportPublic).
+		Messages(b.Message("Empty").MustBuild()).
+		MustBuild()
+	imports := map[string]*protowrite.File{
+		"foo/types.proto":  types,
+		"foo/common.proto": common,
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		kind := b.Enum("Kind").Element("KIND_UNSPECIFIED", 0).MustBuild()
+		file := b.File().
+			Package(`foo.bar`).
+			Import("foo/common.proto", protowrite.ImportDefault).
+			Import("google/protobuf/timestamp.proto", protowrite.ImportDefault).
+			Import("google/protobuf/descriptor.proto", protowrite.ImportDefault).
+			Extensions(b.Extension("google.protobuf.FieldOptions").StringField("label", 50000).MustBuild()).
+			Messages(
+				b.Message("Message").
+					Enums(kind).
+					Messages(b.Message("Nested").MustBuild()).
+					Field("Nested", "nested", 1).
+					Field("Message.Nested", "qualified", 2).
+					Field(".foo.bar.Message.Nested", "absolute", 3).
+					Field("foo.types.Point", "point", 4).
+					Field("types.Color", "color", 5).
+					Field("map<string, google.protobuf.Timestamp>", "times", 6).
+					TypedField(kind, "kind", 7).
+					TypedField(protowrite.ExternalEnum("foo.types.Color"), "other_color", 8).
+					MustBuild(),
+			).
+			Services(
+				b.Service("Service").
+					Method("Call", "Message", "common.Empty").
+					MustBuild(),
+			).
+			MustBuild()
+
+		require.NoError(t, file.Resolve(&protowrite.ResolveOptions{Imports: imports}), `Resolve should succeed`)
+	})
+	t.Run("Errors", func(t *testing.T) {
+		file := b.File().
+			Package(`foo.bar`).
+			Import("foo/common.proto", protowrite.ImportDefault).
+			Extensions(b.Extension("Missing").StringField("ext", 100).MustBuild()).
+			Enums(b.Enum("Kind").Element("KIND_UNSPECIFIED", 0).MustBuild()).
+			Messages(
+				b.Message("Empty").MustBuild(),
+				b.Message("Message").
+					Messages(b.Message("foo").MustBuild()).
+					Field("Mesage", "typo", 1).
+					TypedField(protowrite.MessageRef("Kind"), "kind", 2).
+					Field("foo.types.Point", "point", 3).
+					Field("Message.typo", "field", 4).
+					TypedField(b.Message("Elsewhere").MustBuild(), "elsewhere", 5).
+					MustBuild(),
+				b.Message("Other").
+					Field("foo.bar.Message", "message", 1).
+					Field("google.protobuf.Timestamp", "not_imported", 2).
+					MustBuild(),
+			).
+			Services(
+				b.Service("Service").
+					Method("Call", "Kind", ".foo.common.Empty").
+					MustBuild(),
+			).
+			MustBuild()
+
+		err := file.Resolve(&protowrite.ResolveOptions{Imports: imports})
+		require.Error(t, err, `Resolve should fail`)
+
+		var list protowrite.ValidationErrors
+		require.True(t, errors.As(err, &list), `error should be a ValidationErrors`)
+
+		var paths []string
+		for _, e := range list {
+			paths = append(paths, e.Path)
+		}
+		require.Equal(t, []string{
+			"foo.bar",                   // extendee Missing
+			"foo.bar.Message.typo",      // typo in Message
+			"foo.bar.Message.kind",      // message reference to an enum
+			"foo.bar.Message.point",     // foo is hidden by foo.bar.Message.foo
+			"foo.bar.Message.field",     // refers to a field
+			"foo.bar.Message.elsewhere", // message not declared anywhere
+			"foo.bar.Other.not_imported",
+			"foo.bar.Service.Call", // input is an enum
+		}, paths, `paths should match (%s)`, err)
+	})
+	t.Run("Duplicate declarations", func(t *testing.T) {
+		file := b.File().
+			Package(`foo.common`).
+			Import("foo/common.proto", protowrite.ImportDefault).
+			Messages(
+				b.Message("Empty").MustBuild(),
+				b.Message("Message").Field("Empty", "empty", 1).MustBuild(),
+			).
+			MustBuild()
+
+		err := file.Resolve(&protowrite.ResolveOptions{Imports: imports})
+		require.Error(t, err, `Resolve should fail`)
+		require.Contains(t, err.Error(), `is ambiguous`)
+	})
+	t.Run("Missing import", func(t *testing.T) {
+		file := b.File().
+			Package(`foo.bar`).
+			Import("does/not/exist.proto", protowrite.ImportDefault).
+			MustBuild()
+		require.Error(t, file.Resolve(nil), `Resolve should fail`)
+	})
+}
@@ -0,0 +1,294 @@
+package protowrite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type is the type of a field. It is one of ScalarType, TypeRef, *MapType,
+// ExternalType, or a *Message or *Enum declared in the same File. Messages,
+// enums and external types are written using the shortest name that
+// refers to them from where they are used
+type Type interface {
+	String() string
+	isType()
+}
+
+// ScalarType is one of the scalar value types built into protobuf
+type ScalarType string
+
+const (
+	TypeDouble   ScalarType = "double"
+	TypeFloat    ScalarType = "float"
+	TypeInt32    ScalarType = "int32"
+	TypeInt64    ScalarType = "int64"
+	TypeUint32   ScalarType = "uint32"
+	TypeUint64   ScalarType = "uint64"
+	TypeSint32   ScalarType = "sint32"
+	TypeSint64   ScalarType = "sint64"
+	TypeFixed32  ScalarType = "fixed32"
+	TypeFixed64  ScalarType = "fixed64"
+	TypeSfixed32 ScalarType = "sfixed32"
+	TypeSfixed64 ScalarType = "sfixed64"
+	TypeBool     ScalarType = "bool"
+	TypeString   ScalarType = "string"
+	TypeBytes    ScalarType = "bytes"
+)
+
+// scalarTypes lists all scalar types, in the order used by descriptor.proto
+var scalarTypes = []ScalarType{
+	TypeDouble,
+	TypeFloat,
+	TypeInt64,
+	TypeUint64,
+	TypeInt32,
+	TypeFixed64,
+	TypeFixed32,
+	TypeBool,
+	TypeString,
+	TypeBytes,
+	TypeUint32,
+	TypeSfixed32,
+	TypeSfixed64,
+	TypeSint32,
+	TypeSint64,
+}
+
+func (t ScalarType) String() string {
+	return string(t)
+}
+
+func (ScalarType) isType() {}
+
+// valid returns true if t is one of the predefined scalar types
+func (t ScalarType) valid() bool {
+	for _, v := range scalarTypes {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// validMapKey returns true if t can be used as the key of a map field
+func (t ScalarType) validMapKey() bool {
+	switch t {
+	case TypeDouble, TypeFloat, TypeBytes:
+		return false
+	default:
+		return t.valid()
+	}
+}
+
+// RefKind specifies what kind of type a TypeRef refers to
+type RefKind int
+
+const (
+	// RefUnknown is used when the kind is not known, such as for
+	// references read from protobuf source. It is determined when
+	// the name is resolved
+	RefUnknown RefKind = iota
+	RefMessage
+	RefEnum
+)
+
+func (k RefKind) String() string {
+	switch k {
+	case RefMessage:
+		return "message"
+	case RefEnum:
+		return "enum"
+	default:
+		return "type"
+	}
+}
+
+// TypeRef refers to a message or an enum by name. The name may be relative
+// to the scope where it is used, or fully-qualified with a leading dot
+type TypeRef struct {
+	Name string
+	Kind RefKind
+}
+
+// MessageRef creates a reference to the message with the given name
+func MessageRef(name string) TypeRef {
+	return TypeRef{Name: name, Kind: RefMessage}
+}
+
+// EnumRef creates a reference to the enum with the given name
+func EnumRef(name string) TypeRef {
+	return TypeRef{Name: name, Kind: RefEnum}
+}
+
+func (t TypeRef) String() string {
+	return t.Name
+}
+
+func (TypeRef) isType() {}
+
+// ExternalType refers to a message or an enum that is declared in
+// another file, such as one of the well-known types
+type ExternalType struct {
+	// FullName is the fully-qualified name of the type, such as
+	// `google.protobuf.Timestamp`. A leading dot is allowed but not required
+	FullName string
+	Kind     RefKind
+}
+
+// ExternalMessage creates a reference to a message declared in another file
+func ExternalMessage(fullName string) ExternalType {
+	return ExternalType{FullName: fullName, Kind: RefMessage}
+}
+
+// ExternalEnum creates a reference to an enum declared in another file
+func ExternalEnum(fullName string) ExternalType {
+	return ExternalType{FullName: fullName, Kind: RefEnum}
+}
+
+func (t ExternalType) String() string {
+	return strings.TrimPrefix(t.FullName, ".")
+}
+
+func (ExternalType) isType() {}
+
+// String returns the name of the message. It allows a *Message to be
+// used as the Type of a field
+func (m *Message) String() string {
+	return m.Name
+}
+
+func (*Message) isType() {}
+
+// String returns the name of the enum. It allows an *Enum to be
+// used as the Type of a field
+func (e *Enum) String() string {
+	return e.Name
+}
+
+func (*Enum) isType() {}
+
+// MapType describes the key and value types of a map field
+type MapType struct {
+	// Key is the type of the map key. It must be an integral type,
+	// bool, or string
+	Key Type
+	// Value is the type of the map value. It may be any type other
+	// than another map
+	Value Type
+}
+
+// MapOf creates a map type with the given key and value types
+func MapOf(key ScalarType, value Type) *MapType {
+	return &MapType{Key: key, Value: value}
+}
+
+func (mt *MapType) String() string {
+	return fmt.Sprintf("map<%s, %s>", mt.Key, mt.Value)
+}
+
+func (*MapType) isType() {}
+
+func (mt *MapType) validate() error {
+	if key, ok := mt.Key.(ScalarType); !ok || !key.validMapKey() {
+		return fmt.Errorf(`invalid map key type %q`, typeString(mt.Key))
+	}
+	switch value := mt.Value.(type) {
+	case nil:
+		return fmt.Errorf(`map value type must be specified`)
+	case *MapType:
+		return fmt.Errorf(`map value type may not be a map`)
+	case ScalarType:
+		if !value.valid() {
+			return fmt.Errorf(`unknown scalar type %q`, value)
+		}
+	}
+	return nil
+}
+
+// typeString is like t.String(), but also handles nil types
+func typeString(t Type) string {
+	if t == nil {
+		return ""
+	}
+	return t.String()
+}
+
+// typeOf returns the scalar type with the given name, or a reference
+// of unknown kind if name is not a scalar type
+func typeOf(name string) Type {
+	if t := ScalarType(name); t.valid() {
+		return t
+	}
+	return TypeRef{Name: name}
+}
+
+// ParseType parses a type as it would be written in a field declaration,
+// such as `int32`, `foo.bar.Message` or `map<string, .foo.Bar>`.
+// Names that are not scalar types are returned as a TypeRef of unknown kind
+func ParseType(s string) (Type, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "map<") && strings.HasSuffix(s, ">") {
+		inner := s[len("map<") : len(s)-1]
+		// the value may be another map, so split at the first comma
+		i := strings.IndexByte(inner, ',')
+		if i < 0 {
+			return nil, fmt.Errorf(`invalid map type %q: expected key and value types`, s)
+		}
+		key, err := ParseType(inner[:i])
+		if err != nil {
+			return nil, fmt.Errorf(`invalid map type %q: %w`, s, err)
+		}
+		value, err := ParseType(inner[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf(`invalid map type %q: %w`, s, err)
+		}
+		return &MapType{Key: key, Value: value}, nil
+	}
+	if !isFullIdentifier(strings.TrimPrefix(s, ".")) {
+		return nil, fmt.Errorf(`invalid type %q`, s)
+	}
+	return typeOf(s), nil
+}
+
+// misspelledScalar returns the scalar type that name is most likely a
+// misspelling of. Only lowercase names without dots are considered, as
+// messages and enums are conventionally written in CamelCase
+func misspelledScalar(name string) (ScalarType, bool) {
+	if name == "" || strings.ToLower(name) != name || strings.Contains(name, ".") {
+		return "", false
+	}
+	best, bestDistance := ScalarType(""), 3
+	for _, t := range scalarTypes {
+		if d := editDistance(name, string(t)); d < bestDistance {
+			best, bestDistance = t, d
+		}
+	}
+	return best, best != "" && best != ScalarType(name)
+}
+
+// editDistance returns the number of single character insertions,
+// deletions, substitutions and transpositions needed to turn a into b
+func editDistance(a, b string) int {
+	// d[i][j] is the distance between a[:i] and b[:j]
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
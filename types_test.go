@@ -0,0 +1,58 @@
+package protowrite_test
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/protowrite"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseType(t *testing.T) {
+	testcases := []struct {
+		Source   string
+		Expected protowrite.Type
+		Error    bool
+	}{
+		{Source: "int32", Expected: protowrite.TypeInt32},
+		{Source: " bytes ", Expected: protowrite.TypeBytes},
+		{Source: "Message", Expected: protowrite.TypeRef{Name: "Message"}},
+		{Source: ".google.protobuf.Any", Expected: protowrite.TypeRef{Name: ".google.protobuf.Any"}},
+		{Source: "map<string, int64>", Expected: protowrite.MapOf(protowrite.TypeString, protowrite.TypeInt64)},
+		{Source: "map<int32,foo.Bar>", Expected: protowrite.MapOf(protowrite.TypeInt32, protowrite.TypeRef{Name: "foo.Bar"})},
+		{Source: "", Error: true},
+		{Source: "foo..Bar", Error: true},
+		{Source: "map<string>", Error: true},
+		{Source: "map<string, 1>", Error: true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Source, func(t *testing.T) {
+			typ, err := protowrite.ParseType(tc.Source)
+			if tc.Error {
+				require.Error(t, err, `protowrite.ParseType should fail`)
+				return
+			}
+			require.NoError(t, err, `protowrite.ParseType should succeed`)
+			require.Equal(t, tc.Expected, typ)
+		})
+	}
+}
+
+func TestTypeRefKind(t *testing.T) {
+	var b protowrite.Builder
+
+	file, err := b.File().
+		Package(`foo.bar`).
+		Enums(b.Enum("Kind").Element("KIND_UNSPECIFIED", 0).MustBuild()).
+		Messages(b.Message("Message").MessageField("Kind", "kind", 1).MustBuild()).
+		Build()
+	require.NoError(t, err, `builder.Build should succeed`)
+
+	_, err = protowrite.ToDescriptorProto(file, nil)
+	require.Error(t, err, `protowrite.ToDescriptorProto should fail when a message reference resolves to an enum`)
+
+	file.Messages[0].Fields[0].Type = protowrite.EnumRef("Kind")
+	_, err = protowrite.ToDescriptorProto(file, nil)
+	require.NoError(t, err, `protowrite.ToDescriptorProto should succeed`)
+}
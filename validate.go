@@ -0,0 +1,328 @@
+package protowrite
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// firstReservedFieldNumber and lastReservedFieldNumber delimit the
+	// range of field numbers reserved for the protobuf implementation
+	firstReservedFieldNumber = 19000
+	lastReservedFieldNumber  = 19999
+)
+
+// ValidationError describes a single problem found while validating a File
+type ValidationError struct {
+	// Path is the fully-qualified name of the offending declaration,
+	// such as `foo.bar.Message.NestedMessage.kind`
+	Path string
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors lists every problem found while validating a File
+type ValidationErrors []*ValidationError
+
+func (list ValidationErrors) Error() string {
+	if len(list) == 1 {
+		return list[0].Error()
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d validation errors:", len(list))
+	for _, err := range list {
+		sb.WriteString("\n\t")
+		sb.WriteString(err.Error())
+	}
+	return sb.String()
+}
+
+func (list ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(list))
+	for i, err := range list {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (list *ValidationErrors) addf(path, format string, args ...interface{}) {
+	*list = append(*list, &ValidationError{Path: path, Err: fmt.Errorf(format, args...)})
+}
+
+func (list ValidationErrors) err() error {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || !isLetter(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isLetter(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isFullIdentifier returns true if s is a dot-separated list of identifiers
+func isFullIdentifier(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if !isIdentifier(part) {
+			return false
+		}
+	}
+	return true
+}
+
+type validator struct {
+	syntax Syntax
+	// checkSyntax is false when validating declarations outside of a
+	// File, in which case the syntax is not known and checks that depend
+	// on it are skipped
+	checkSyntax bool
+	// features holds the resolved Editions features of the current scope
+	features *FeatureSet
+	errs     ValidationErrors
+}
+
+func (v *validator) errorf(path, format string, args ...interface{}) {
+	v.errs.addf(path, format, args...)
+}
+
+func (v *validator) err() error {
+	return v.errs.err()
+}
+
+// declare records that name is declared in scope, reporting an error if
+// something else with the same name has already been declared there
+func (v *validator) declare(names map[string]string, scope, name, kind string) {
+	path := joinName(scope, name)
+	if !isIdentifier(name) {
+		v.errorf(path, `invalid %s name %q`, kind, name)
+	}
+	if prev, ok := names[name]; ok {
+		v.errorf(path, `%s %q is already declared as a %s in %q`, kind, name, prev, scope)
+		return
+	}
+	names[name] = kind
+}
+
+// Validate checks f for problems that would make protoc reject the
+// generated file, such as duplicate field numbers or invalid names.
+// It returns a ValidationErrors containing every problem found, or nil
+func (f *File) Validate() error {
+	v := &validator{syntax: f.Syntax, checkSyntax: true}
+
+	switch f.Syntax {
+	case SyntaxProto2, SyntaxProto3:
+		if f.Edition != "" {
+			v.errorf(f.Package, `edition %q specified for a file with %s syntax`, f.Edition, f.Syntax)
+		}
+	case SyntaxEditions:
+		defaults, err := editionDefaults(f.edition())
+		if err != nil {
+			v.errorf(f.Package, `%s`, err)
+		}
+		v.features = f.Features.resolve(defaults)
+	default:
+		v.errorf(f.Package, `unknown syntax %s`, f.Syntax)
+	}
+
+	if f.Package != "" && !isFullIdentifier(f.Package) {
+		v.errorf(f.Package, `invalid package name %q`, f.Package)
+	}
+
+	names := make(map[string]string)
+	v.declarations(f.Package, names, f.Messages, f.Enums, f.Extensions)
+	for _, s := range f.Services {
+		v.declare(names, f.Package, s.Name, "service")
+		v.service(f.Package, s)
+	}
+	return v.err()
+}
+
+// declarations validates the messages, enums and extensions declared in scope
+func (v *validator) declarations(scope string, names map[string]string, messages []*Message, enums []*Enum, extensions []*Extension) {
+	for _, m := range messages {
+		v.declare(names, scope, m.Name, "message")
+		v.message(scope, m)
+	}
+	for _, e := range enums {
+		v.declare(names, scope, e.Name, "enum")
+		// enum values are siblings of their enum, not children
+		for _, ee := range e.Elements {
+			v.declare(names, scope, ee.Name, "enum value")
+		}
+		v.enum(scope, e)
+	}
+	for _, ext := range extensions {
+		v.extension(scope, names, ext)
+	}
+}
+
+// fieldNumber checks that n can be used as a field number
+func (v *validator) fieldNumber(path string, n int) {
+	switch {
+	case n < 1 || n > MaxFieldNumber:
+		v.errorf(path, `field number %d is out of range (1 to %d)`, n, MaxFieldNumber)
+	case n >= firstReservedFieldNumber && n <= lastReservedFieldNumber:
+		v.errorf(path, `field number %d is reserved for the protobuf implementation (%d to %d)`, n, firstReservedFieldNumber, lastReservedFieldNumber)
+	}
+}
+
+// fieldType checks that the type of a field is specified and is not
+// an unknown scalar type. Map key and value types are checked when
+// the field is written
+func (v *validator) fieldType(path string, typ Type) {
+	switch typ := typ.(type) {
+	case nil:
+		v.errorf(path, `field type must be specified`)
+	case ScalarType:
+		if !typ.valid() {
+			v.errorf(path, `unknown scalar type %q`, typ)
+		}
+	case TypeRef:
+		if !isFullIdentifier(strings.TrimPrefix(typ.Name, ".")) {
+			v.errorf(path, `invalid %s name %q`, typ.Kind, typ.Name)
+		}
+	case ExternalType:
+		if !isFullIdentifier(typ.String()) {
+			v.errorf(path, `invalid %s name %q`, typ.Kind, typ.FullName)
+		}
+	}
+}
+
+// messageType checks that typ can refer to a message, as is required
+// for the input and output of methods and the target of extensions
+func (v *validator) messageType(path string, typ Type) {
+	switch typ := typ.(type) {
+	case nil:
+		v.errorf(path, `message type must be specified`)
+	case *Message:
+	case TypeRef:
+		v.fieldType(path, typ)
+		if typ.Kind == RefEnum {
+			v.errorf(path, `%q is an enum, not a message`, typ)
+		}
+	case ExternalType:
+		v.fieldType(path, typ)
+		if typ.Kind == RefEnum {
+			v.errorf(path, `%q is an enum, not a message`, typ)
+		}
+	default:
+		v.errorf(path, `%q is not a message`, typ)
+	}
+}
+
+func (v *validator) message(scope string, m *Message) {
+	path := joinName(scope, m.Name)
+	parent := v.features
+	v.features = m.Features.resolve(parent)
+	defer func() { v.features = parent }()
+
+	names := make(map[string]string)
+	numbers := make(map[int]string)
+	field := func(field *Field) {
+		fieldPath := joinName(path, field.Name)
+		v.declare(names, path, field.Name, "field")
+		v.fieldNumber(fieldPath, field.ID)
+		v.fieldType(fieldPath, field.Type)
+		if prev, ok := numbers[field.ID]; ok {
+			v.errorf(fieldPath, `field number %d is already used by %q`, field.ID, prev)
+		} else {
+			numbers[field.ID] = field.Name
+		}
+	}
+
+	for _, oneof := range m.OneOfs {
+		v.declare(names, path, oneof.Name, "oneof")
+		if len(oneof.Fields) == 0 {
+			v.errorf(joinName(path, oneof.Name), `oneof must contain at least one field`)
+		}
+		for _, f := range oneof.Fields {
+			field(f)
+		}
+	}
+	for _, f := range m.Fields {
+		field(f)
+	}
+	v.declarations(path, names, m.Messages, m.Enums, m.Extensions)
+}
+
+func (v *validator) enum(scope string, e *Enum) {
+	path := joinName(scope, e.Name)
+	parent := v.features
+	v.features = e.Features.resolve(parent)
+	defer func() { v.features = parent }()
+
+	if len(e.Elements) == 0 {
+		v.errorf(path, `enum must contain at least one value`)
+		return
+	}
+
+	if first := e.Elements[0]; first.Value != 0 && v.checkSyntax {
+		switch {
+		case v.syntax == SyntaxProto3:
+			v.errorf(joinName(path, first.Name), `the first value of an enum must be zero in proto3`)
+		case v.syntax == SyntaxEditions && v.features.EnumType != EnumTypeClosed:
+			v.errorf(joinName(path, first.Name), `the first value of an open enum must be zero`)
+		}
+	}
+
+	allowAlias := false
+	for _, option := range e.Options {
+		if option.Name == "allow_alias" {
+			allowAlias = option.Value == true || option.Value == Identifier("true")
+		}
+	}
+	values := make(map[int]string)
+	for _, ee := range e.Elements {
+		valuePath := joinName(path, ee.Name)
+		if ee.Value < MinEnumValue || ee.Value > MaxEnumValue {
+			v.errorf(valuePath, `enum value %d is out of range (%d to %d)`, ee.Value, MinEnumValue, MaxEnumValue)
+		}
+		if prev, ok := values[ee.Value]; ok && !allowAlias {
+			v.errorf(valuePath, `enum value %d is already used by %q (set option allow_alias to allow this)`, ee.Value, prev)
+		} else if !ok {
+			values[ee.Value] = ee.Name
+		}
+	}
+}
+
+func (v *validator) extension(scope string, names map[string]string, ext *Extension) {
+	if ext.Target != nil {
+		v.messageType(scope, ext.Target)
+	} else if !isFullIdentifier(strings.TrimPrefix(ext.Name, ".")) {
+		v.errorf(scope, `invalid extendee name %q`, ext.Name)
+	}
+	for _, field := range ext.Fields {
+		v.declare(names, scope, field.Name, "extension")
+		v.fieldNumber(joinName(scope, field.Name), field.ID)
+		v.fieldType(joinName(scope, field.Name), field.Type)
+	}
+}
+
+func (v *validator) service(scope string, s *Service) {
+	path := joinName(scope, s.Name)
+	names := make(map[string]string)
+	for _, m := range s.Methods {
+		v.declare(names, path, m.Name, "method")
+		for _, typ := range []Type{m.Input, m.Output} {
+			v.messageType(joinName(path, m.Name), typ)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package protowrite_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lestrrat-go/protowrite"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	var b protowrite.Builder
+
+	t.Run("Valid", func(t *testing.T) {
+		file, err := b.File().
+			Package(`foo.bar`).
+			Enums(b.Enum("Kind").Element("KIND_UNKNOWN", 0).MustBuild()).
+			Messages(
+				b.Message("Message").
+					StringField("name", 1).
+					Uint64Field("id", 2).
+					MustBuild(),
+			).
+			Build()
+		require.NoError(t, err, `builder.Build should succeed`)
+		require.NoError(t, file.Validate(), `file.Validate should succeed`)
+	})
+	t.Run("Aggregated errors", func(t *testing.T) {
+		file := &protowrite.File{
+			Package: `foo.bar`,
+			Messages: []*protowrite.Message{
+				{
+					Name: "Message",
+					Messages: []*protowrite.Message{
+						{
+							Name: "NestedMessage",
+							Fields: []*protowrite.Field{
+								{Type: protowrite.TypeString, Name: "name", ID: 1},
+								{Type: protowrite.TypeRef{Name: "Kind"}, Name: "kind", ID: 1},
+								{Type: protowrite.TypeString, Name: "internal", ID: 19500},
+								{Type: protowrite.TypeString, Name: "huge", ID: 536870912},
+								{Type: protowrite.TypeString, Name: "2bad", ID: 2},
+							},
+						},
+					},
+				},
+			},
+			Enums: []*protowrite.Enum{
+				{
+					Name: "Kind",
+					Elements: []*protowrite.EnumElement{
+						{Name: "KIND_ONE", Value: 1},
+					},
+				},
+			},
+		}
+
+		err := file.Validate()
+		require.Error(t, err, `file.Validate should fail`)
+
+		var list protowrite.ValidationErrors
+		require.True(t, errors.As(err, &list), `error should be a ValidationErrors`)
+
+		var paths []string
+		for _, v := range list {
+			paths = append(paths, v.Path)
+		}
+		require.Equal(t, []string{
+			`foo.bar.Message.NestedMessage.kind`,
+			`foo.bar.Message.NestedMessage.internal`,
+			`foo.bar.Message.NestedMessage.huge`,
+			`foo.bar.Message.NestedMessage.2bad`,
+			`foo.bar.Kind.KIND_ONE`,
+		}, paths)
+	})
+	t.Run("Errors", func(t *testing.T) {
+		testcases := []struct {
+			Name string
+			File *protowrite.File
+		}{
+			{
+				Name: "duplicate field names",
+				File: &protowrite.File{Messages: []*protowrite.Message{{Name: "Message", Fields: []*protowrite.Field{
+					{Type: protowrite.TypeString, Name: "a", ID: 1},
+					{Type: protowrite.TypeString, Name: "a", ID: 2},
+				}}}},
+			},
+			{
+				Name: "duplicate field number in oneof",
+				File: &protowrite.File{Messages: []*protowrite.Message{{
+					Name:   "Message",
+					Fields: []*protowrite.Field{{Type: protowrite.TypeString, Name: "a", ID: 1}},
+					OneOfs: []*protowrite.OneOf{{Name: "choice", Fields: []*protowrite.Field{{Type: protowrite.TypeString, Name: "b", ID: 1}}}},
+				}}},
+			},
+			{
+				Name: "zero field number",
+				File: &protowrite.File{Messages: []*protowrite.Message{{Name: "Message", Fields: []*protowrite.Field{{Type: protowrite.TypeString, Name: "a", ID: 0}}}}},
+			},
+			{
+				Name: "invalid message name",
+				File: &protowrite.File{Messages: []*protowrite.Message{{Name: "My Message"}}},
+			},
+			{
+				Name: "invalid package name",
+				File: &protowrite.File{Package: "foo..bar"},
+			},
+			{
+				Name: "open enum without zero value in editions",
+				File: &protowrite.File{Syntax: protowrite.SyntaxEditions, Enums: []*protowrite.Enum{{Name: "Kind", Elements: []*protowrite.EnumElement{{Name: "ONE", Value: 1}}}}},
+			},
+			{
+				Name: "duplicate enum values",
+				File: &protowrite.File{Enums: []*protowrite.Enum{{Name: "Kind", Elements: []*protowrite.EnumElement{{Name: "ZERO", Value: 0}, {Name: "NONE", Value: 0}}}}},
+			},
+			{
+				Name: "enum value conflicts with message",
+				File: &protowrite.File{
+					Messages: []*protowrite.Message{{Name: "ZERO"}},
+					Enums:    []*protowrite.Enum{{Name: "Kind", Elements: []*protowrite.EnumElement{{Name: "ZERO", Value: 0}}}},
+				},
+			},
+			{
+				Name: "extension number in reserved range",
+				File: &protowrite.File{Syntax: protowrite.SyntaxProto2, Extensions: []*protowrite.Extension{{Name: "Message", Fields: []*protowrite.Field{{Type: protowrite.TypeString, Name: "a", ID: 19000}}}}},
+			},
+			{
+				Name: "duplicate method names",
+				File: &protowrite.File{Services: []*protowrite.Service{{Name: "Svc", Methods: []*protowrite.Method{
+					{Name: "Call", Input: protowrite.MessageRef("Request"), Output: protowrite.MessageRef("Response")},
+					{Name: "Call", Input: protowrite.MessageRef("Request"), Output: protowrite.MessageRef("Response")},
+				}}}},
+			},
+		}
+
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				require.Error(t, tc.File.Validate(), `file.Validate should fail`)
+			})
+		}
+	})
+	t.Run("Allowed cases", func(t *testing.T) {
+		testcases := []struct {
+			Name string
+			File *protowrite.File
+		}{
+			{
+				Name: "closed enum without zero value in editions",
+				File: &protowrite.File{Syntax: protowrite.SyntaxEditions, Enums: []*protowrite.Enum{{
+					Name:     "Kind",
+					Features: &protowrite.FeatureSet{EnumType: protowrite.EnumTypeClosed},
+					Elements: []*protowrite.EnumElement{{Name: "ONE", Value: 1}},
+				}}},
+			},
+			{
+				Name: "proto2 enum without zero value",
+				File: &protowrite.File{Syntax: protowrite.SyntaxProto2, Enums: []*protowrite.Enum{{Name: "Kind", Elements: []*protowrite.EnumElement{{Name: "ONE", Value: 1}}}}},
+			},
+			{
+				Name: "aliased enum values",
+				File: &protowrite.File{Enums: []*protowrite.Enum{{
+					Name:     "Kind",
+					Options:  []*protowrite.Option{{Name: "allow_alias", Value: true}},
+					Elements: []*protowrite.EnumElement{{Name: "ZERO", Value: 0}, {Name: "NONE", Value: 0}},
+				}}},
+			},
+		}
+
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				require.NoError(t, tc.File.Validate(), `file.Validate should succeed`)
+			})
+		}
+	})
+	t.Run("Build", func(t *testing.T) {
+		_, err := b.File().
+			Package(`foo.bar`).
+			Enums(b.Enum("Kind").Element("ONE", 1).MustBuild()).
+			Build()
+		require.Error(t, err, `FileBuilder.Build should fail`)
+
+		_, err = b.Message("Message").StringField("a", 1).StringField("b", 1).Build()
+		require.Error(t, err, `MessageBuilder.Build should fail`)
+	})
+}